@@ -4,9 +4,14 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"reflect"
+	"strings"
+	"time"
 
+	"github.com/olli-ai/exposecontroller/exposestrategy"
 	"github.com/pkg/errors"
 	yaml "gopkg.in/yaml.v2"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	"k8s.io/klog"
 )
 
@@ -23,7 +28,7 @@ func LoadFile(path string) (*Config, bool, error) {
 		klog.Infof("No %s file found.  Will try to figure out defaults", path)
 	}
 
-	c, err := Load(string(content))
+	c, err := load(string(content), "file "+path)
 	if err != nil {
 		return nil, false, errors.Wrap(err, "failed to read config file")
 	}
@@ -32,14 +37,18 @@ func LoadFile(path string) (*Config, bool, error) {
 
 // Load loads the config from yaml string
 func Load(s string) (*Config, error) {
+	return load(s, "config")
+}
+
+func load(s, source string) (*Config, error) {
 	cfg := &Config{}
 	// If the entire config body is empty the UnmarshalYAML method is
 	// never called. We thus have to set the DefaultConfig at the entry
 	// point as well.
 	*cfg = DefaultConfig
-	err := yaml.Unmarshal([]byte(s), &cfg)
+	err := cfg.Merge(s, source)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to unmarshal config")
+		return nil, err
 	}
 
 	cfg.original = s
@@ -49,24 +58,92 @@ func Load(s string) (*Config, error) {
 
 // Config is the global config of the program
 type Config struct {
-	Domain                string   `yaml:"domain,omitempty" json:"domain"`
-	InternalDomain        string   `yaml:"internal-domain,omitempty" json:"internal_domain"`
-	Exposer               string   `yaml:"exposer" json:"exposer"`
-	PathMode              string   `yaml:"path-mode" json:"path_mode"`
-	NodeIP                string   `yaml:"node-ip,omitempty" json:"node_ip"`
-	AuthorizePath         string   `yaml:"authorize-path,omitempty" json:"authorize_path"`
-	WatchNamespaces       string   `yaml:"watch-namespaces" json:"watch_namespaces"`
-	WatchCurrentNamespace bool     `yaml:"watch-current-namespace" json:"watch_current_namespace"`
-	HTTP                  bool     `yaml:"http" json:"http"`
-	TLSAcme               bool     `yaml:"tls-acme" json:"tls_acme"`
-	TLSSecretName         string   `yaml:"tls-secret-name" json:"tls_secret_name"`
-	TLSUseWildcard        bool     `yaml:"tls-use-wildcard" json:"tls_use_wildcard"`
-	URLTemplate           string   `yaml:"urltemplate,omitempty" json:"url_template"`
-	Services              []string `yaml:"services,omitempty" json:"services"`
-	IngressClass          string   `yaml:"ingress-class" json:"ingress_class"`
-	NamePrefix            string   `yaml:"name-prefix,omitempty" json:"name_prefix"`
+	Domain                string                                        `yaml:"domain,omitempty" json:"domain"`
+	InternalDomain        string                                        `yaml:"internal-domain,omitempty" json:"internal_domain"`
+	Exposer               string                                        `yaml:"exposer" json:"exposer"`
+	PathMode              string                                        `yaml:"path-mode" json:"path_mode"`
+	PathTemplate          string                                        `yaml:"path-template,omitempty" json:"path_template"`
+	NodeIP                string                                        `yaml:"node-ip,omitempty" json:"node_ip"`
+	NodeSelector          string                                        `yaml:"node-selector,omitempty" json:"node_selector"`
+	NodePortRange         string                                        `yaml:"node-port-range,omitempty" json:"node_port_range"`
+	AuthorizePath         string                                        `yaml:"authorize-path,omitempty" json:"authorize_path"`
+	WatchNamespaces       string                                        `yaml:"watch-namespaces" json:"watch_namespaces"`
+	WatchCurrentNamespace bool                                          `yaml:"watch-current-namespace" json:"watch_current_namespace"`
+	HTTP                  bool                                          `yaml:"http" json:"http"`
+	TLSAcme               bool                                          `yaml:"tls-acme" json:"tls_acme"`
+	TLSSecretName         string                                        `yaml:"tls-secret-name" json:"tls_secret_name"`
+	TLSUseWildcard        bool                                          `yaml:"tls-use-wildcard" json:"tls_use_wildcard"`
+	URLTemplate           string                                        `yaml:"urltemplate,omitempty" json:"url_template"`
+	Services              []string                                      `yaml:"services,omitempty" json:"services"`
+	CrossNamespaceExports map[string][]string                           `yaml:"cross-namespace-exports,omitempty" json:"cross_namespace_exports"`
+	IngressClass          string                                        `yaml:"ingress-class" json:"ingress_class"`
+	AllowedHosts          []string                                      `yaml:"allowed-hosts,omitempty" json:"allowed_hosts"`
+	ClusterName           string                                        `yaml:"cluster-name,omitempty" json:"cluster_name"`
+	Domains               map[string]exposestrategy.DomainProfile       `yaml:"domains,omitempty" json:"domains"`
+	DomainRules           []exposestrategy.DomainRule                   `yaml:"domain-rules,omitempty" json:"domain_rules"`
+	NamePrefix            string                                        `yaml:"name-prefix,omitempty" json:"name_prefix"`
+	AmbassadorMode        string                                        `yaml:"ambassador-mode,omitempty" json:"ambassador_mode"`
+	AmbassadorAPIVersion  string                                        `yaml:"ambassador-api-version,omitempty" json:"ambassador_api_version"`
+	WildcardDNS           string                                        `yaml:"wildcard-dns,omitempty" json:"wildcard_dns"`
+	LoadBalancerTimeout   time.Duration                                 `yaml:"loadbalancer-timeout,omitempty" json:"loadbalancer_timeout"`
+	LoadBalancerProfile   string                                        `yaml:"loadbalancer-profile,omitempty" json:"loadbalancer_profile"`
+	LoadBalancerProfiles  map[string]exposestrategy.LoadBalancerProfile `yaml:"loadbalancer-profiles,omitempty" json:"loadbalancer_profiles"`
+	LoadBalancerIPs       []string                                      `yaml:"loadbalancer-ips,omitempty" json:"loadbalancer_ips"`
+	LoadBalancerIPLedger  string                                        `yaml:"loadbalancer-ip-ledger,omitempty" json:"loadbalancer_ip_ledger"`
+	ExternalIPs           []string                                      `yaml:"external-ips,omitempty" json:"external_ips"`
+	WebhookURL            string                                        `yaml:"webhook-url,omitempty" json:"webhook_url"`
+	WebhookSecret         string                                        `yaml:"webhook-secret,omitempty" json:"webhook_secret"`
+	WebhookTimeout        time.Duration                                 `yaml:"webhook-timeout,omitempty" json:"webhook_timeout"`
+	WebhookRetries        int                                           `yaml:"webhook-retries,omitempty" json:"webhook_retries"`
 	// original is the input from which the config was parsed.
-	original string `json:"original"`
+	original string
+	// sources are the sources which set the fields, by key
+	sources map[string]string
+	// unknownKeys are the errors about the unknown keys of the sources
+	unknownKeys []error
+}
+
+// Validate checks the values of the config
+// returns all the errors found, aggregated
+func (c *Config) Validate() error {
+	errs := []error{}
+	if err := exposestrategy.Validate(newStrategyConfig(nil, "", c, c.Exposer)); err != nil {
+		if agg, ok := err.(utilerrors.Aggregate); ok {
+			errs = append(errs, agg.Errors()...)
+		} else {
+			errs = append(errs, err)
+		}
+	}
+	for _, svc := range c.Services {
+		if strings.TrimSpace(svc) == "" {
+			errs = append(errs, errors.New("services must not contain empty names"))
+			break
+		}
+	}
+	for ns, namespaces := range c.CrossNamespaceExports {
+		for _, n := range namespaces {
+			if strings.TrimSpace(ns) == "" || strings.TrimSpace(n) == "" {
+				errs = append(errs, errors.New("cross-namespace-exports must not contain empty namespaces"))
+				break
+			}
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// CheckUnknownKeys checks the inputs from which the config was parsed only have known keys
+func (c *Config) CheckUnknownKeys() error {
+	return utilerrors.NewAggregate(c.unknownKeys)
+}
+
+// Effective returns the config with its actual values, once loaded and overridden
+// The values of the secret fields are hidden
+func (c Config) Effective() string {
+	b, err := yaml.Marshal(c.hideSecrets())
+	if err != nil {
+		return fmt.Sprintf("<error creating config string: %s>", err)
+	}
+	return string(b)
 }
 
 // DefaultConfig is the default values of Config
@@ -82,11 +159,13 @@ func MapToConfig(data map[string]string) (*Config, error) {
 	if err != nil {
 		return nil, err
 	}
-	err = yaml.Unmarshal(b, answer)
+	err = answer.Merge(string(b), "ConfigMap ingress-config")
+	answer.original = string(b)
 	return answer, err
 }
 
 func (c Config) String() string {
+	c = c.hideSecrets()
 	if c.original != "" {
 		return c.original
 	}
@@ -97,3 +176,41 @@ func (c Config) String() string {
 	}
 	return string(b)
 }
+
+// hideSecrets returns a copy of the config with the values of its secret fields hidden, in the original input too
+func (c Config) hideSecrets() Config {
+	value := reflect.ValueOf(&c).Elem()
+	for _, field := range configFields {
+		if isSecretKey(field.key) && field.typ.Kind() == reflect.String && value.Field(field.index).String() != "" {
+			value.Field(field.index).SetString(hiddenValue)
+		}
+	}
+	if c.original != "" {
+		c.original = hideOriginalSecrets(c.original)
+	}
+	return c
+}
+
+// hideOriginalSecrets hides the values of the secret keys of the YAML input
+// returns the input unchanged if it has no secret, empty if it can't be parsed
+func hideOriginalSecrets(original string) string {
+	items := yaml.MapSlice{}
+	if err := yaml.Unmarshal([]byte(original), &items); err != nil {
+		return ""
+	}
+	hidden := false
+	for i := range items {
+		if key, ok := items[i].Key.(string); ok && isSecretKey(key) && items[i].Value != nil && fmt.Sprint(items[i].Value) != "" {
+			items[i].Value = hiddenValue
+			hidden = true
+		}
+	}
+	if !hidden {
+		return original
+	}
+	b, err := yaml.Marshal(items)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
@@ -1,7 +1,10 @@
 package controller
 
 import (
+	"strings"
 	"testing"
+
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 )
 
 func TestMapToConfig(t *testing.T) {
@@ -29,3 +32,56 @@ func assertStringEquals(t *testing.T, expected, actual, message string) {
 		t.Errorf("%s was not equal. Expected %s but got %s\n", message, expected, actual)
 	}
 }
+
+func TestConfigValidate(t *testing.T) {
+	config, err := Load("exposer: ingress\ndomain: example.com\nservices: [my-app]\ncross-namespace-exports: {api: [web]}\n")
+	if err != nil {
+		t.Fatalf("Failed to load config %s\n", err)
+	}
+	if err := config.CheckUnknownKeys(); err != nil {
+		t.Errorf("Unexpected unknown keys %s\n", err)
+	}
+	if err := config.Validate(); err != nil {
+		t.Errorf("Unexpected invalid config %s\n", err)
+	}
+
+	config, err = Load("exposer: router\ndomian: example.com\nservices: ['']\ncross-namespace-exports: {api: ['']}\n")
+	if err != nil {
+		t.Fatalf("Failed to load config %s\n", err)
+	}
+	if err := config.CheckUnknownKeys(); err == nil {
+		t.Error("Unknown key domian not reported\n")
+	}
+	if err := config.Validate(); err == nil {
+		t.Error("Invalid config not reported\n")
+	} else if count := len(err.(utilerrors.Aggregate).Errors()); count != 3 {
+		t.Errorf("Expected 3 errors but got %d: %s\n", count, err)
+	}
+}
+
+func TestConfigHideSecrets(t *testing.T) {
+	config, err := Load("exposer: webhook\nwebhook-url: https://edge.example.com\nwebhook-secret: s3cr3t\ntls-secret-name: my-tls\n")
+	if err != nil {
+		t.Fatalf("Failed to load config %s\n", err)
+	}
+	for name, text := range map[string]string{
+		"original":  config.String(),
+		"effective": config.Effective(),
+		"sources":   config.PrintSources(),
+	} {
+		if strings.Contains(text, "s3cr3t") || !strings.Contains(text, hiddenValue) {
+			t.Errorf("%s config does not hide the webhook secret:\n%s", name, text)
+		}
+		if !strings.Contains(text, "my-tls") {
+			t.Errorf("%s config hides the TLS secret name:\n%s", name, text)
+		}
+	}
+	if config.WebhookSecret != "s3cr3t" {
+		t.Errorf("the webhook secret was changed to %s", config.WebhookSecret)
+	}
+
+	config.Override("webhook-secret", "0th3r", "flag --webhook-secret")
+	if text := config.String(); strings.Contains(text, "0th3r") {
+		t.Errorf("overridden config does not hide the webhook secret:\n%s", text)
+	}
+}
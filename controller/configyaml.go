@@ -0,0 +1,726 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+	"k8s.io/klog"
+
+	"k8s.io/api/core/v1"
+
+	"github.com/olli-ai/exposecontroller/exposestrategy"
+)
+
+// configYaml is an entry of the expose.config.fabric8.io/config-yaml annotation
+// It replaces the lines of the key starting with Prefix, or with a Format, sets the field Path of the document of the key
+type configYaml struct {
+	Key        string
+	Expression string
+	// Template is a go template of the value, executed with the values, instead of Expression
+	Template string
+	Prefix   string
+	Suffix   string
+	// Format is the format of the document of the key, "json", "yaml", "toml" or "properties"
+	Format string
+	// Path is the dot separated path of the field in the document, the whole key in properties
+	Path string
+}
+
+// configYamlValues returns the values of the exposed service available to the config-yaml expressions and templates
+func configYamlValues(svc *v1.Service, exposeURL string) map[string]string {
+	values := map[string]string{
+		"url":       exposeURL,
+		"path":      urlPath(exposeURL),
+		"clusterIP": svc.Spec.ClusterIP,
+		"urls":      strings.Join(exposedURLs(svc, exposeURL), ","),
+	}
+	if u, err := url.Parse(exposeURL); err == nil {
+		values["host"] = u.Host
+		values["protocol"] = u.Scheme
+		values["port"] = u.Port()
+		if values["port"] == "" && u.Scheme == "https" {
+			values["port"] = "443"
+		} else if values["port"] == "" && u.Scheme == "http" {
+			values["port"] = "80"
+		}
+	}
+	if port := exposedServicePort(svc); port != nil && port.NodePort > 0 {
+		values["nodePort"] = strconv.Itoa(int(port.NodePort))
+	}
+	return values
+}
+
+// exposedURLs returns all the URLs of the service, the exposeURL first, then the ones of fabric8.io/exposeURLs,
+// then the ones of the additional exposers
+func exposedURLs(svc *v1.Service, exposeURL string) []string {
+	urls := []string{exposeURL}
+	seen := map[string]bool{exposeURL: true}
+	add := func(u string) {
+		if u = strings.TrimSpace(u); u != "" && !seen[u] {
+			urls = append(urls, u)
+			seen[u] = true
+		}
+	}
+	for _, u := range strings.Split(svc.Annotations[exposestrategy.ExposeURLsAnnotationKey], ",") {
+		add(u)
+	}
+	keys := []string{}
+	for key := range svc.Annotations {
+		if strings.HasPrefix(key, exposestrategy.ExposeURLAnnotationKeyFor("")) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		add(svc.Annotations[key])
+	}
+	return urls
+}
+
+// exposedServicePort returns the port of the expose port annotation, else the first port
+func exposedServicePort(svc *v1.Service) *v1.ServicePort {
+	if len(svc.Spec.Ports) == 0 {
+		return nil
+	}
+	if port, err := strconv.Atoi(svc.Annotations[exposestrategy.ExposePortAnnotationKey]); err == nil {
+		for i := range svc.Spec.Ports {
+			if int(svc.Spec.Ports[i].Port) == port {
+				return &svc.Spec.Ports[i]
+			}
+		}
+	}
+	return &svc.Spec.Ports[0]
+}
+
+// value returns the value of the entry, from its template or its expression
+func (c *configYaml) value(values map[string]string) (string, error) {
+	if c.Template == "" {
+		return values[c.Expression], nil
+	}
+	// the missing values are empty
+	tmpl, err := template.New("config-yaml").Funcs(exposestrategy.TemplateFuncs()).Option("missingkey=zero").Parse(c.Template)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to parse template \"%s\"", c.Template)
+	}
+	var buffer bytes.Buffer
+	if err = tmpl.Execute(&buffer, values); err != nil {
+		return "", errors.Wrapf(err, "failed to execute template \"%s\"", c.Template)
+	}
+	return buffer.String(), nil
+}
+
+func (c *configYaml) updateData(kind, name string, data map[string]string, values map[string]string) bool {
+	key := c.Key
+	if key == "" {
+		klog.Warningf("%s %s does not have a key in yaml config %#v\n", kind, name, c)
+		return false
+	}
+	expValue, err := c.value(values)
+	if err != nil {
+		klog.Warningf("Invalid yaml config %#v on %s %s: %v\n", c, kind, name, err)
+		return false
+	}
+	if expValue == "" {
+		klog.Warningf("Could not calculate expression %s from the yaml config %#v possible values are %v\n", c.Expression, c, values)
+		return false
+	}
+	value := data[key]
+	var newValue string
+	if c.Format != "" {
+		newValue, err = setDocumentField(c.Format, value, c.Path, expValue)
+		if err != nil {
+			klog.Warningf("Failed to set the field %s of the key %s of %s %s: %v\n", c.Path, key, kind, name, err)
+			return false
+		}
+	} else if value == "" {
+		klog.Warningf("%s %s does not have a key %s when trying to apply the yaml config %#v\n", kind, name, key, c)
+		return false
+	} else {
+		lines := strings.Split(value, "\n")
+		var buffer bytes.Buffer
+		for _, line := range lines {
+			if strings.HasPrefix(line, c.Prefix) {
+				buffer.WriteString(c.Prefix + expValue + c.Suffix)
+			} else {
+				buffer.WriteString(line)
+			}
+			buffer.WriteString("\n")
+		}
+		newValue = buffer.String()
+	}
+	if newValue != value {
+		data[key] = newValue
+		return true
+	}
+	return false
+}
+
+// setDocumentField sets the string field of the document, keeping the other fields
+// returns the document unchanged if the field already has the value
+func setDocumentField(format, document, path, value string) (string, error) {
+	if path == "" {
+		return "", errors.Errorf("a path is required with format %s", format)
+	}
+	switch strings.ToLower(format) {
+	case "json":
+		return setJSONField(document, strings.Split(path, "."), value)
+	case "yaml", "yml":
+		return setYAMLField(document, strings.Split(path, "."), value)
+	case "toml":
+		return setTOMLField(document, path, value), nil
+	case "properties":
+		return setPropertiesField(document, path, value), nil
+	default:
+		return "", errors.Errorf("unknown format %s, must be json, yaml, toml or properties", format)
+	}
+}
+
+// setJSONField sets the field of the JSON document, re-indented with 2 spaces
+// The order of the keys and the numbers are kept as they are
+func setJSONField(document string, path []string, value string) (string, error) {
+	root := &jsonObject{values: map[string]interface{}{}}
+	if strings.TrimSpace(document) != "" {
+		decoder := json.NewDecoder(strings.NewReader(document))
+		decoder.UseNumber()
+		parsed, err := decodeJSONValue(decoder)
+		if err == nil && decoder.More() {
+			err = errors.New("unexpected data after the document")
+		}
+		if err != nil {
+			return "", errors.Wrap(err, "failed to parse the JSON document")
+		}
+		var ok bool
+		if root, ok = parsed.(*jsonObject); !ok {
+			return "", errors.New("the JSON document is not an object")
+		}
+	}
+	object := root
+	for i, part := range path[:len(path)-1] {
+		switch child := object.values[part].(type) {
+		case *jsonObject:
+			object = child
+		case nil:
+			created := &jsonObject{values: map[string]interface{}{}}
+			object.set(part, created)
+			object = created
+		default:
+			return "", errors.Errorf("field %s is not an object", strings.Join(path[:i+1], "."))
+		}
+	}
+	last := path[len(path)-1]
+	if object.values[last] == value {
+		return document, nil
+	}
+	object.set(last, value)
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(root); err != nil {
+		return "", errors.Wrap(err, "failed to write the JSON document")
+	}
+	return buffer.String(), nil
+}
+
+// jsonObject is a JSON object keeping the order of its keys
+type jsonObject struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+// set sets the value of the key, added after the other keys if missing
+func (o *jsonObject) set(key string, value interface{}) {
+	if _, ok := o.values[key]; !ok {
+		o.keys = append(o.keys, key)
+	}
+	o.values[key] = value
+}
+
+// MarshalJSON writes the keys in their order
+func (o *jsonObject) MarshalJSON() ([]byte, error) {
+	var buffer bytes.Buffer
+	buffer.WriteString("{")
+	for i, key := range o.keys {
+		if i > 0 {
+			buffer.WriteString(",")
+		}
+		k, err := encodeJSON(key)
+		if err != nil {
+			return nil, err
+		}
+		v, err := encodeJSON(o.values[key])
+		if err != nil {
+			return nil, err
+		}
+		buffer.Write(k)
+		buffer.WriteString(":")
+		buffer.Write(v)
+	}
+	buffer.WriteString("}")
+	return buffer.Bytes(), nil
+}
+
+// encodeJSON encodes the value without escaping the HTML characters, like the "&" of the URLs
+func encodeJSON(value interface{}) ([]byte, error) {
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buffer.Bytes(), []byte("\n")), nil
+}
+
+// decodeJSONValue decodes the next value, the objects as *jsonObject and the numbers as json.Number
+func decodeJSONValue(decoder *json.Decoder) (interface{}, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch token {
+	case json.Delim('{'):
+		object := &jsonObject{values: map[string]interface{}{}}
+		for decoder.More() {
+			key, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeJSONValue(decoder)
+			if err != nil {
+				return nil, err
+			}
+			object.set(key.(string), value)
+		}
+		_, err = decoder.Token()
+		return object, err
+	case json.Delim('['):
+		array := []interface{}{}
+		for decoder.More() {
+			value, err := decodeJSONValue(decoder)
+			if err != nil {
+				return nil, err
+			}
+			array = append(array, value)
+		}
+		_, err = decoder.Token()
+		return array, err
+	}
+	return token, nil
+}
+
+// setYAMLField sets the field of the YAML document, editing the lines to keep the rest of the document
+// The documents the lines can't be edited in, like with flow mappings or multi-line values, are written again,
+// keeping the order of the keys but not the comments
+func setYAMLField(document string, path []string, value string) (string, error) {
+	root := yaml.MapSlice{}
+	if err := yaml.Unmarshal([]byte(document), &root); err != nil {
+		return "", errors.Wrap(err, "failed to parse the YAML document")
+	}
+	changed, err := setMapSliceField(&root, path, value)
+	if err != nil {
+		return "", err
+	} else if !changed {
+		return document, nil
+	}
+	expected, err := yaml.Marshal(root)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to write the YAML document")
+	}
+	if edited, ok := editYAMLLines(document, path, value); ok {
+		// the edit is only kept if it gives the expected document
+		parsed := yaml.MapSlice{}
+		if err := yaml.Unmarshal([]byte(edited), &parsed); err == nil {
+			if b, err := yaml.Marshal(parsed); err == nil && string(b) == string(expected) {
+				return edited, nil
+			}
+		}
+	}
+	klog.V(2).Infof("Writing again the YAML document to set the field %s, without its comments", strings.Join(path, "."))
+	return string(expected), nil
+}
+
+// yamlKeyLine is a line of the YAML document holding a key of a block mapping
+type yamlKeyLine struct {
+	index  int
+	indent int
+	path   []string
+	// rest is what follows the colon, the value and the comment
+	rest string
+}
+
+// editYAMLLines sets the field by editing the line of the field, or by inserting lines in its parent mapping
+// returns false if the document is not simple enough to be edited this way
+func editYAMLLines(document string, path []string, value string) (string, bool) {
+	scalar, ok := yamlScalar(value)
+	if !ok {
+		return "", false
+	}
+	var lines []string
+	if document != "" {
+		lines = strings.Split(strings.TrimSuffix(document, "\n"), "\n")
+	}
+	keyLines := yamlKeyLines(lines)
+	for _, keyLine := range keyLines {
+		if !equalPaths(keyLine.path, path) {
+			continue
+		}
+		start := len(keyLine.rest) - len(strings.TrimLeft(keyLine.rest, " "))
+		old, trailer := splitYAMLValue(keyLine.rest[start:])
+		if old == "" {
+			// a mapping or a multi-line value
+			return "", false
+		}
+		line := lines[keyLine.index]
+		lines[keyLine.index] = line[:len(line)-len(keyLine.rest)] + keyLine.rest[:start] + scalar + trailer
+		return strings.Join(lines, "\n") + "\n", true
+	}
+
+	// the deepest existing mapping of the path, the root by default
+	parent := yamlKeyLine{index: -1, indent: -1}
+	for _, keyLine := range keyLines {
+		if len(keyLine.path) < len(path) && len(keyLine.path) > len(parent.path) && equalPaths(keyLine.path, path[:len(keyLine.path)]) {
+			parent = keyLine
+		}
+	}
+	if parent.index >= 0 {
+		if value, _ := splitYAMLValue(strings.TrimLeft(parent.rest, " ")); value != "" {
+			// a flow mapping or a null value
+			return "", false
+		}
+	}
+	// inserted after the last line of the parent, indented like its first child
+	last, indent := parent.index, -1
+	for i := parent.index + 1; i < len(lines); i++ {
+		trimmed := strings.TrimLeft(lines[i], " ")
+		if trimmed == "" || trimmed[0] == '#' {
+			continue
+		}
+		lineIndent := len(lines[i]) - len(trimmed)
+		if lineIndent <= parent.indent {
+			break
+		}
+		if indent < 0 {
+			indent = lineIndent
+		}
+		last = i
+	}
+	if indent < 0 {
+		indent = parent.indent + 2
+		if parent.index < 0 {
+			indent = 0
+		}
+	}
+	inserted := []string{}
+	for i, part := range path[len(parent.path):] {
+		key, ok := yamlScalar(part)
+		if !ok {
+			return "", false
+		}
+		line := strings.Repeat(" ", indent+2*i) + key + ":"
+		if len(parent.path)+i == len(path)-1 {
+			line += " " + scalar
+		}
+		inserted = append(inserted, line)
+	}
+	lines = append(lines[:last+1], append(inserted, lines[last+1:]...)...)
+	return strings.Join(lines, "\n") + "\n", true
+}
+
+// yamlKeyLines returns the lines holding the keys of the block mappings, with their paths
+// The items of the sequences are part of the paths as "-", so that their keys never match a path
+func yamlKeyLines(lines []string) []yamlKeyLine {
+	type level struct {
+		indent int
+		key    string
+	}
+	keyLines := []yamlKeyLine{}
+	stack := []level{}
+	blockIndent := -1
+	for i, line := range lines {
+		trimmed := strings.TrimLeft(line, " ")
+		indent := len(line) - len(trimmed)
+		if blockIndent >= 0 {
+			// the lines of a block scalar
+			if strings.TrimSpace(line) == "" || indent > blockIndent {
+				continue
+			}
+			blockIndent = -1
+		}
+		if trimmed == "" || trimmed[0] == '#' || strings.HasPrefix(trimmed, "---") {
+			continue
+		}
+		for len(stack) > 0 && stack[len(stack)-1].indent >= indent {
+			stack = stack[:len(stack)-1]
+		}
+		if trimmed == "-" || strings.HasPrefix(trimmed, "- ") {
+			stack = append(stack, level{indent, "-"})
+			continue
+		}
+		key, rest, ok := splitYAMLKey(trimmed)
+		if !ok {
+			continue
+		}
+		stack = append(stack, level{indent, key})
+		path := make([]string, len(stack))
+		for j := range stack {
+			path[j] = stack[j].key
+		}
+		keyLines = append(keyLines, yamlKeyLine{i, indent, path, rest})
+		if value, _ := splitYAMLValue(strings.TrimLeft(rest, " ")); strings.HasPrefix(value, "|") || strings.HasPrefix(value, ">") {
+			blockIndent = indent
+		}
+	}
+	return keyLines
+}
+
+// splitYAMLKey splits the line of a mapping key into the key, unquoted, and what follows the colon
+func splitYAMLKey(line string) (string, string, bool) {
+	if line[0] == '"' || line[0] == '\'' {
+		end := strings.IndexByte(line[1:], line[0])
+		if end < 0 {
+			return "", "", false
+		}
+		key, after := line[1:end+1], line[end+2:]
+		if !strings.HasPrefix(after, ":") || (len(after) > 1 && after[1] != ' ') {
+			return "", "", false
+		}
+		return key, after[1:], true
+	}
+	for i := 0; i < len(line); i++ {
+		switch {
+		case line[i] == '#' && i > 0 && line[i-1] == ' ':
+			return "", "", false
+		case line[i] == ':' && (i+1 == len(line) || line[i+1] == ' '):
+			key := strings.TrimSpace(line[:i])
+			if key == "" || strings.ContainsAny(key[:1], "{[&*!|>%@`?") {
+				return "", "", false
+			}
+			return key, line[i+1:], true
+		}
+	}
+	return "", "", false
+}
+
+// splitYAMLValue splits the inline value from its comment, like " # comment"
+func splitYAMLValue(text string) (string, string) {
+	end := len(text)
+	switch {
+	case strings.HasPrefix(text, `"`):
+		for i := 1; i < len(text); i++ {
+			if text[i] == '\\' {
+				i++
+			} else if text[i] == '"' {
+				end = i + 1
+				break
+			}
+		}
+	case strings.HasPrefix(text, "'"):
+		for i := 1; i < len(text); i++ {
+			if text[i] == '\'' && i+1 < len(text) && text[i+1] == '\'' {
+				i++
+			} else if text[i] == '\'' {
+				end = i + 1
+				break
+			}
+		}
+	case strings.HasPrefix(text, "#"):
+		end = 0
+	default:
+		if i := strings.Index(text, " #"); i >= 0 {
+			end = i
+		}
+	}
+	return strings.TrimRight(text[:end], " "), text[len(strings.TrimRight(text[:end], " ")):]
+}
+
+// yamlScalar returns the single line YAML representation of the string, quoted if needed
+func yamlScalar(value string) (string, bool) {
+	b, err := yaml.Marshal(value)
+	if err != nil {
+		return "", false
+	}
+	scalar := strings.TrimSuffix(string(b), "\n")
+	return scalar, !strings.Contains(scalar, "\n")
+}
+
+func equalPaths(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func setMapSliceField(object *yaml.MapSlice, path []string, value string) (bool, error) {
+	for i := range *object {
+		item := &(*object)[i]
+		if fmt.Sprint(item.Key) != path[0] {
+			continue
+		}
+		if len(path) == 1 {
+			if item.Value == value {
+				return false, nil
+			}
+			item.Value = value
+			return true, nil
+		}
+		switch child := item.Value.(type) {
+		case yaml.MapSlice:
+			changed, err := setMapSliceField(&child, path[1:], value)
+			item.Value = child
+			return changed, errors.Wrapf(err, "field %s", path[0])
+		case nil:
+			created := yaml.MapSlice{}
+			_, err := setMapSliceField(&created, path[1:], value)
+			item.Value = created
+			return true, err
+		default:
+			return false, errors.Errorf("field %s is not an object", path[0])
+		}
+	}
+	var newValue interface{} = value
+	for i := len(path) - 1; i > 0; i-- {
+		newValue = yaml.MapSlice{{Key: path[i], Value: newValue}}
+	}
+	*object = append(*object, yaml.MapItem{Key: path[0], Value: newValue})
+	return true, nil
+}
+
+// setTOMLField sets the string field of the TOML document, editing the lines to keep the rest of the document
+// the last part of the path is the key, the other ones the table
+func setTOMLField(document, path, value string) string {
+	quoted := `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value) + `"`
+	table, key := "", path
+	if i := strings.LastIndex(path, "."); i >= 0 {
+		table, key = path[:i], path[i+1:]
+	}
+	lines := strings.Split(document, "\n")
+	current, tableLine := "", -1
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "[[") {
+			// the arrays of tables are never edited
+			current = "[["
+			continue
+		} else if strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]") {
+			current = tomlKey(trimmed[1 : len(trimmed)-1])
+			if current == table {
+				tableLine = i
+			}
+			continue
+		}
+		eq := strings.Index(line, "=")
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || eq < 0 {
+			continue
+		}
+		fullKey := tomlKey(line[:eq])
+		if current != "" {
+			fullKey = current + "." + fullKey
+		}
+		if fullKey == path {
+			rest := line[eq+1:]
+			start := len(rest) - len(strings.TrimLeft(rest, " \t"))
+			// keeps the comment after the value
+			lines[i] = line[:eq+1] + rest[:start] + quoted + tomlValueTrailer(rest[start:])
+			return strings.Join(lines, "\n")
+		}
+	}
+	newLine := key + " = " + quoted
+	if table == "" {
+		return newLine + "\n" + document
+	} else if tableLine >= 0 {
+		lines = append(lines[:tableLine+1], append([]string{newLine}, lines[tableLine+1:]...)...)
+		return strings.Join(lines, "\n")
+	}
+	if document != "" && !strings.HasSuffix(document, "\n") {
+		document += "\n"
+	}
+	return document + "[" + table + "]\n" + newLine + "\n"
+}
+
+// tomlValueTrailer returns what follows the single line value, like " # comment"
+func tomlValueTrailer(value string) string {
+	end := len(value)
+	switch {
+	case strings.HasPrefix(value, `"`):
+		for i := 1; i < len(value); i++ {
+			if value[i] == '\\' {
+				i++
+			} else if value[i] == '"' {
+				end = i + 1
+				break
+			}
+		}
+	case strings.HasPrefix(value, "'"):
+		if i := strings.Index(value[1:], "'"); i >= 0 {
+			end = i + 2
+		}
+	default:
+		if i := strings.Index(value, "#"); i >= 0 {
+			end = len(strings.TrimRight(value[:i], " \t"))
+		}
+	}
+	rest := value[end:]
+	trimmed := strings.TrimLeft(rest, " \t")
+	if !strings.HasPrefix(trimmed, "#") {
+		return ""
+	}
+	return rest
+}
+
+// tomlKey normalizes the dotted key, without the spaces and quotes around its parts
+func tomlKey(key string) string {
+	parts := strings.Split(key, ".")
+	for i, part := range parts {
+		parts[i] = strings.Trim(strings.TrimSpace(part), `"'`)
+	}
+	return strings.Join(parts, ".")
+}
+
+// setPropertiesField sets the key of the .properties document, editing the lines to keep the rest of the document
+func setPropertiesField(document, key, value string) string {
+	escaped := strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(value)
+	lines := strings.Split(document, "\n")
+	for i, line := range lines {
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed == "" || trimmed[0] == '#' || trimmed[0] == '!' {
+			continue
+		}
+		end := strings.IndexAny(trimmed, "=: \t")
+		if end < 0 {
+			end = len(trimmed)
+		}
+		if trimmed[:end] != key {
+			continue
+		}
+		// keeps the separator, like " = "
+		rest := trimmed[end:]
+		separator := len(rest) - len(strings.TrimLeft(rest, " \t"))
+		if separator < len(rest) && (rest[separator] == '=' || rest[separator] == ':') {
+			separator++
+			separator += len(rest[separator:]) - len(strings.TrimLeft(rest[separator:], " \t"))
+		}
+		if rest == "" {
+			// a key without separator nor value
+			rest, separator = "=", 1
+		}
+		lines[i] = line[:len(line)-len(trimmed)] + key + rest[:separator] + escaped
+		return strings.Join(lines, "\n")
+	}
+	if document != "" && !strings.HasSuffix(document, "\n") {
+		document += "\n"
+	}
+	return document + key + "=" + escaped + "\n"
+}
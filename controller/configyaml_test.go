@@ -0,0 +1,191 @@
+package controller
+
+import (
+	"testing"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/olli-ai/exposecontroller/exposestrategy"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigYamlValues(t *testing.T) {
+	svc := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "main",
+			Name:      "my-service",
+			Annotations: map[string]string{
+				exposestrategy.ExposePortAnnotationKey:               "8080",
+				exposestrategy.ExposeURLsAnnotationKey:               "https://my-service.example.com/api,http://10.0.0.2:30080",
+				exposestrategy.ExposeURLAnnotationKeyFor("nodeport"): "http://10.0.0.2:30080",
+				exposestrategy.ExposeURLAnnotationKeyFor("webhook"):  "https://edge.example.com/",
+			},
+		},
+		Spec: v1.ServiceSpec{
+			ClusterIP: "10.96.0.1",
+			Ports:     []v1.ServicePort{{Port: 80, NodePort: 30000}, {Port: 8080, NodePort: 30080}},
+		},
+	}
+	assert.Equal(t, map[string]string{
+		"url":       "https://my-service.example.com/api",
+		"host":      "my-service.example.com",
+		"protocol":  "https",
+		"path":      "/api",
+		"port":      "443",
+		"clusterIP": "10.96.0.1",
+		"nodePort":  "30080",
+		"urls":      "https://my-service.example.com/api,http://10.0.0.2:30080,https://edge.example.com/",
+	}, configYamlValues(svc, "https://my-service.example.com/api"))
+
+	values := configYamlValues(&v1.Service{}, "http://my-service.example.com:8000")
+	assert.Equal(t, "8000", values["port"])
+	assert.Equal(t, "/", values["path"])
+	assert.NotContains(t, values, "nodePort")
+}
+
+func TestConfigYaml_updateData(t *testing.T) {
+	values := map[string]string{"host": "my-service.example.com", "url": "https://my-service.example.com/", "protocol": "https"}
+	for _, test := range []struct {
+		name     string
+		config   configYaml
+		data     string
+		expected string
+	}{{
+		"prefix",
+		configYaml{Key: "app.ini", Expression: "host", Prefix: "DOMAIN = "},
+		"[server]\nDOMAIN = localhost\nPORT = 80",
+		"[server]\nDOMAIN = my-service.example.com\nPORT = 80\n",
+	}, {
+		"template",
+		configYaml{Key: "app.ini", Template: "{{.url | trimSuffix \"/\"}}/callback?{{.missing}}", Prefix: "CALLBACK = "},
+		"CALLBACK = \n",
+		"CALLBACK = https://my-service.example.com/callback?\n\n",
+	}, {
+		"json",
+		configYaml{Key: "app.ini", Expression: "url", Format: "json", Path: "auth.callback"},
+		`{"name": "app", "auth": {"enabled": true, "timeout": 1e3, "id": 12345678901234567890}, "list": [1.50, {"b": 1, "a": 2}]}`,
+		"{\n  \"name\": \"app\",\n  \"auth\": {\n    \"enabled\": true,\n    \"timeout\": 1e3,\n    \"id\": 12345678901234567890,\n" +
+			"    \"callback\": \"https://my-service.example.com/\"\n  },\n  \"list\": [\n    1.50,\n    {\n      \"b\": 1,\n      \"a\": 2\n    }\n  ]\n}\n",
+	}, {
+		"json query",
+		configYaml{Key: "app.ini", Template: "{{.url}}?a=1&b=2", Format: "json", Path: "url"},
+		`{}`,
+		"{\n  \"url\": \"https://my-service.example.com/?a=1&b=2\"\n}\n",
+	}, {
+		"yaml",
+		configYaml{Key: "app.ini", Expression: "url", Format: "yaml", Path: "server.rootUrl"},
+		"name: app\nserver:\n  port: 80\nlist: [a, b]\n",
+		"name: app\nserver:\n  port: 80\n  rootUrl: https://my-service.example.com/\nlist: [a, b]\n",
+	}, {
+		"yaml comments",
+		configYaml{Key: "app.ini", Expression: "host", Format: "yaml", Path: "server.host"},
+		"# app config\nserver:\n    # the public host\n    host: 'local#host'  # overridden\n    port: 80\n\n# the database\ndb: {host: localhost}\n",
+		"# app config\nserver:\n    # the public host\n    host: my-service.example.com  # overridden\n    port: 80\n\n# the database\ndb: {host: localhost}\n",
+	}, {
+		"yaml comments new keys",
+		configYaml{Key: "app.ini", Template: "{{.protocol}}: {{.host}}", Format: "yaml", Path: "server.http.host"},
+		"server:\n  # the port\n  port: 80\n  routes:\n  - name: main\n    http: {}\n  script: |\n    http:\n  # end of server\nname: app # the name\n",
+		"server:\n  # the port\n  port: 80\n  routes:\n  - name: main\n    http: {}\n  script: |\n    http:\n  http:\n    host: 'https: my-service.example.com'\n  # end of server\nname: app # the name\n",
+	}, {
+		"yaml flow mapping",
+		configYaml{Key: "app.ini", Expression: "host", Format: "yaml", Path: "server.host"},
+		"# app config\nserver: {port: 80}\n",
+		"server:\n  port: 80\n  host: my-service.example.com\n",
+	}, {
+		"yaml new",
+		configYaml{Key: "app.ini", Expression: "host", Format: "yaml", Path: "server.host"},
+		"",
+		"server:\n  host: my-service.example.com\n",
+	}, {
+		"toml",
+		configYaml{Key: "app.ini", Template: "{{.protocol}}://{{.host}}", Format: "toml", Path: "server.root_url"},
+		"# app config\nname = \"app\"\n\n[server]\nport = 80\nroot_url=\"http://localhost\"\n\n[db]\nroot_url = \"db\"\n",
+		"# app config\nname = \"app\"\n\n[server]\nport = 80\nroot_url=\"https://my-service.example.com\"\n\n[db]\nroot_url = \"db\"\n",
+	}, {
+		"toml comment",
+		configYaml{Key: "app.ini", Expression: "host", Format: "toml", Path: "server.host"},
+		"[server]\nhost = \"local#host\"  # the public host\nport = 80 # the port\n",
+		"[server]\nhost = \"my-service.example.com\"  # the public host\nport = 80 # the port\n",
+	}, {
+		"toml comment after a bare value",
+		configYaml{Key: "app.ini", Expression: "host", Format: "toml", Path: "host"},
+		"host = 0   # not set\n",
+		"host = \"my-service.example.com\"   # not set\n",
+	}, {
+		"toml new key",
+		configYaml{Key: "app.ini", Expression: "host", Format: "toml", Path: "server.host"},
+		"name = \"app\"\n\n[server]\nport = 80\n",
+		"name = \"app\"\n\n[server]\nhost = \"my-service.example.com\"\nport = 80\n",
+	}, {
+		"toml new table",
+		configYaml{Key: "app.ini", Expression: "host", Format: "toml", Path: "server.http.host"},
+		"name = \"app\"",
+		"name = \"app\"\n[server.http]\nhost = \"my-service.example.com\"\n",
+	}, {
+		"properties",
+		configYaml{Key: "app.ini", Expression: "url", Format: "properties", Path: "server.url"},
+		"# urls\nserver.url : http://localhost\nserver.url.internal=http://internal\n",
+		"# urls\nserver.url : https://my-service.example.com/\nserver.url.internal=http://internal\n",
+	}, {
+		"properties new key",
+		configYaml{Key: "app.ini", Expression: "host", Format: "properties", Path: "server.host"},
+		"server.port=80",
+		"server.port=80\nserver.host=my-service.example.com\n",
+	}, {
+		"properties key without separator",
+		configYaml{Key: "app.ini", Expression: "url", Format: "properties", Path: "server.url"},
+		"server.url\nother=1\n",
+		"server.url=https://my-service.example.com/\nother=1\n",
+	}} {
+		data := map[string]string{"app.ini": test.data}
+		assert.True(t, test.config.updateData("ConfigMap", "my-config", data, values), test.name)
+		assert.Equal(t, test.expected, data["app.ini"], test.name)
+		if test.config.Format != "" {
+			// unchanged once set
+			assert.False(t, test.config.updateData("ConfigMap", "my-config", data, values), test.name)
+		}
+	}
+
+	for _, test := range []struct {
+		name   string
+		config configYaml
+		data   string
+	}{
+		{"missing key", configYaml{Key: "app.ini", Expression: "host", Prefix: "DOMAIN = "}, ""},
+		{"unknown expression", configYaml{Key: "app.ini", Expression: "unknown", Prefix: "DOMAIN = "}, "DOMAIN = "},
+		{"invalid template", configYaml{Key: "app.ini", Template: "{{.host", Prefix: "DOMAIN = "}, "DOMAIN = "},
+		{"unknown format", configYaml{Key: "app.ini", Expression: "host", Format: "ini", Path: "domain"}, ""},
+		{"missing path", configYaml{Key: "app.ini", Expression: "host", Format: "json"}, ""},
+		{"invalid json", configYaml{Key: "app.ini", Expression: "host", Format: "json", Path: "domain"}, "{"},
+		{"trailing json", configYaml{Key: "app.ini", Expression: "host", Format: "json", Path: "domain"}, "{} {}"},
+		{"json array", configYaml{Key: "app.ini", Expression: "host", Format: "json", Path: "domain"}, "[]"},
+		{"not an object", configYaml{Key: "app.ini", Expression: "host", Format: "yaml", Path: "name.domain"}, "name: app\n"},
+	} {
+		data := map[string]string{"app.ini": test.data}
+		assert.False(t, test.config.updateData("ConfigMap", "my-config", data, values), test.name)
+		assert.Equal(t, test.data, data["app.ini"], test.name)
+	}
+}
+
+func TestUpdateServiceConfigMap_structured(t *testing.T) {
+	svc := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "main",
+			Name:      "my-service",
+			Annotations: map[string]string{
+				exposestrategy.ExposeAnnotationKey: "https://my-service.example.com",
+			},
+		},
+	}
+	data := map[string]string{"config.json": `{"name": "app"}`}
+	annotations := map[string]string{ExposeConfigYamlAnnotation: `
+- key: config.json
+  format: json
+  path: server.url
+  template: '{{.url}}/api'`}
+	require.True(t, updateServiceData(svc, "ConfigMap", "my-service", annotations, data))
+	assert.Equal(t, "{\n  \"name\": \"app\",\n  \"server\": {\n    \"url\": \"https://my-service.example.com/api\"\n  }\n}\n", data["config.json"])
+}
@@ -1,11 +1,11 @@
 package controller
 
 import (
-	"bytes"
 	"fmt"
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
@@ -15,6 +15,7 @@ import (
 	"k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/cache"
 
@@ -43,7 +44,7 @@ const (
 )
 
 // Run runs the controller until synced or timeout
-func Run(client kubernetes.Interface, namespace string, config *Config, timeout time.Duration) error {
+func Run(client kubernetes.Interface, dynamicClient dynamic.Interface, namespace string, config *Config, timeout time.Duration) error {
 	var hasSyncedTimeout <-chan time.Time
 	if timeout > 0*time.Second {
 		hasSyncedTimeout = time.After(timeout)
@@ -54,7 +55,7 @@ func Run(client kubernetes.Interface, namespace string, config *Config, timeout
 	hasSyncedController := make(chan struct{})
 	hasSyncedStrategy := make(chan struct{})
 
-	controller, err := createController(client, namespace, config, time.Hour, hasSyncedController, hasSyncedStrategy)
+	controller, err := createController(client, dynamicClient, namespace, config, time.Hour, hasSyncedController, hasSyncedStrategy)
 	if err != nil {
 		return err
 	}
@@ -73,17 +74,19 @@ func Run(client kubernetes.Interface, namespace string, config *Config, timeout
 }
 
 // Daemon returns a controller for a daemon run
-func Daemon(client kubernetes.Interface, namespace string, config *Config, resyncPeriod time.Duration) (cache.Controller, error) {
-	return createController(client, namespace, config, resyncPeriod, nil, nil)
+func Daemon(client kubernetes.Interface, dynamicClient dynamic.Interface, namespace string, config *Config, resyncPeriod time.Duration) (cache.Controller, error) {
+	return createController(client, dynamicClient, namespace, config, resyncPeriod, nil, nil)
 }
 
-func createController(client kubernetes.Interface, namespace string, config *Config, resyncPeriod time.Duration, hasSyncedController, hasSyncedStrategy chan struct{}) (cache.Controller, error) {
-	strategy, err := getStrategy(client, namespace, config)
+func createController(client kubernetes.Interface, dynamicClient dynamic.Interface, namespace string, config *Config, resyncPeriod time.Duration, hasSyncedController, hasSyncedStrategy chan struct{}) (cache.Controller, error) {
+	strategy, err := newStrategySet(client, dynamicClient, namespace, config)
 	if err != nil {
 		return nil, err
 	}
 
 	var controller cache.Controller
+	// the namespaces controller adds the services again from its own goroutine
+	var lock sync.Mutex
 	isSyncing := false
 	needCheckSynced := false
 	checkSynced := func() {
@@ -99,6 +102,8 @@ func createController(client kubernetes.Interface, namespace string, config *Con
 
 	handlers := cache.ResourceEventHandlerFuncs{
 		AddFunc: func(obj interface{}) {
+			lock.Lock()
+			defer lock.Unlock()
 			svc := obj.(*v1.Service)
 			if shouldExposeService(svc) {
 				if !isServiceWhitelisted(svc.Name, config) {
@@ -108,7 +113,7 @@ func createController(client kubernetes.Interface, namespace string, config *Con
 				if err != nil {
 					klog.Errorf("Add failed: %v", err)
 				}
-				updateRelatedResources(client, svc, config)
+				updateRelatedResources(client, svc, config.forService(strategy.namespaces, svc))
 			} else if isSyncing {
 				if !isServiceWhitelisted(svc.Name, config) {
 					return
@@ -130,7 +135,10 @@ func createController(client kubernetes.Interface, namespace string, config *Con
 			}
 		},
 		UpdateFunc: func(oldObj interface{}, newObj interface{}) {
+			lock.Lock()
+			defer lock.Unlock()
 			svc := newObj.(*v1.Service)
+			strategy.remember(oldObj.(*v1.Service))
 			if shouldExposeService(svc) {
 				if !isServiceWhitelisted(svc.Name, config) {
 					return
@@ -139,7 +147,7 @@ func createController(client kubernetes.Interface, namespace string, config *Con
 				if err != nil {
 					klog.Errorf("Add failed: %v", err)
 				}
-				updateRelatedResources(client, svc, config)
+				updateRelatedResources(client, svc, config.forService(strategy.namespaces, svc))
 			} else if shouldExposeService(oldObj.(*v1.Service)) {
 				if !isServiceWhitelisted(svc.Name, config) {
 					return
@@ -157,6 +165,8 @@ func createController(client kubernetes.Interface, namespace string, config *Con
 			}
 		},
 		DeleteFunc: func(obj interface{}) {
+			lock.Lock()
+			defer lock.Unlock()
 			svc := obj.(*v1.Service)
 			if shouldExposeService(svc) {
 				if !isServiceWhitelisted(svc.Name, config) {
@@ -178,10 +188,13 @@ func createController(client kubernetes.Interface, namespace string, config *Con
 
 	services := client.CoreV1().Services(namespace)
 
-	_, controller = cache.NewInformer(
+	var store cache.Store
+	store, controller = cache.NewInformer(
 		&cache.ListWatch{
 			ListFunc:  func(options metav1.ListOptions) (runtime.Object, error) {
+				lock.Lock()
 				err := strategy.Sync()
+				lock.Unlock()
 				if err != nil {
 					return nil, err
 				}
@@ -200,36 +213,101 @@ func createController(client kubernetes.Interface, namespace string, config *Con
 		handlers,
 	)
 
+	refresh := func(ns, name string) {
+		if obj, exists, _ := store.GetByKey(ns + "/" + name); exists {
+			svc := obj.(*v1.Service)
+			handlers.UpdateFunc(svc, svc)
+		}
+	}
+	// exposes the service again, now that it won or lost its host and path
+	strategy.claims.OnChange(refresh)
+	// exposes the service again, when the IPs of the nodes change
+	strategy.refresh = refresh
+	onChange := func(ns string) {
+		// exposes the services of the namespace, or of all namespaces, with the new overrides
+		for _, obj := range store.List() {
+			if svc := obj.(*v1.Service); ns == "" || svc.Namespace == ns {
+				handlers.UpdateFunc(svc, svc)
+			}
+		}
+	}
+	informers := newPoliciesControllers(dynamicClient, namespace, strategy.namespaces, resyncPeriod, onChange)
+	if namespaces := newNamespacesController(client, namespace, strategy.namespaces, resyncPeriod, onChange); namespaces != nil {
+		informers = append(informers, namespaces)
+	}
+	// the strategies stop watching once the controller stops
+	controller = &informersController{controller, informers, strategy.stop}
 	return controller, nil
 }
 
 // for testing only
 var testStrategy exposestrategy.ExposeStrategy
 
-func getStrategy(client kubernetes.Interface, namespace string, config *Config) (exposestrategy.ExposeStrategy, error) {
+// getStrategy creates the strategy for the exposer
+// returns the name of the strategy actually chosen, in case of auto strategy
+// the strategies share the namespace overrides, the host claims, the event recorder and the stop channel of the set
+func getStrategy(set *strategySet, exposer string) (exposestrategy.ExposeStrategy, string, error) {
 	// for testing only
 	if testStrategy != nil {
-		return testStrategy, nil
+		return testStrategy, exposer, nil
+	}
+	strategyConfig := newStrategyConfig(set.dynamicClient, set.namespace, set.config, exposer)
+	strategyConfig.Namespaces = set.namespaces
+	strategyConfig.Claims = set.claims
+	strategyConfig.Recorder = set.recorder
+	strategyConfig.Refresh = set.refreshService
+	strategyConfig.Stop = set.stop
+	strategy, err := exposestrategy.New(set.client, strategyConfig)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "failed to create new strategy")
 	}
-	strategy, err := exposestrategy.New(client, &exposestrategy.Config{
-		Exposer:        config.Exposer,
+	// the auto strategy sets the exposer it chose
+	return strategy, strings.ToLower(strategyConfig.Exposer), nil
+}
+
+// newStrategyConfig creates the config of the strategy for the exposer
+func newStrategyConfig(dynamicClient dynamic.Interface, namespace string, config *Config, exposer string) *exposestrategy.Config {
+	return &exposestrategy.Config{
+		Exposer:        exposer,
 		Namespace:      namespace,
 		NamePrefix:     config.NamePrefix,
 		Domain:         config.Domain,
 		InternalDomain: config.InternalDomain,
 		NodeIP:         config.NodeIP,
+		WildcardDNS:    config.WildcardDNS,
+		NodeSelector:   config.NodeSelector,
+		NodePortRange:  config.NodePortRange,
 		TLSSecretName:  config.TLSSecretName,
 		TLSUseWildcard: config.TLSUseWildcard,
 		HTTP:           config.HTTP,
 		TLSAcme:        config.TLSAcme,
 		URLTemplate:    config.URLTemplate,
 		PathMode:       config.PathMode,
+		PathTemplate:   config.PathTemplate,
 		IngressClass:   config.IngressClass,
-	})
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to create new strategy")
+		AllowedHosts:   config.AllowedHosts,
+		ClusterName:    config.ClusterName,
+		Domains:        config.Domains,
+		DomainRules:    config.DomainRules,
+
+		AmbassadorMode:       config.AmbassadorMode,
+		AmbassadorAPIVersion: config.AmbassadorAPIVersion,
+
+		LoadBalancerTimeout:  config.LoadBalancerTimeout,
+		LoadBalancerProfile:  config.LoadBalancerProfile,
+		LoadBalancerProfiles: config.LoadBalancerProfiles,
+		LoadBalancerIPs:      config.LoadBalancerIPs,
+		LoadBalancerIPLedger: config.LoadBalancerIPLedger,
+
+		ExternalIPs: config.ExternalIPs,
+
+		WebhookURL:     config.WebhookURL,
+		WebhookSecret:  config.WebhookSecret,
+		WebhookTimeout: config.WebhookTimeout,
+		WebhookRetries: config.WebhookRetries,
+
+		DynamicClient: dynamicClient,
 	}
-	return strategy, nil
 }
 
 func shouldExposeService(svc *v1.Service) bool {
@@ -255,10 +333,14 @@ func isServiceWhitelisted(service string, config *Config) bool {
 
 func updateRelatedResources(c kubernetes.Interface, svc *v1.Service, config *Config) {
 	updateServiceConfigMap(c, svc, config)
+	updateServiceSecret(c, svc)
 
 	exposeURL := svc.Annotations[exposestrategy.ExposeAnnotationKey]
 	if exposeURL != "" {
 		updateOtherConfigMaps(c, svc, config, exposeURL)
+		if err := updateOtherSecrets(c, svc, config, exposeURL); err != nil {
+			klog.Warningf("Failed to update the Secrets of service %s/%s: %v", svc.Namespace, svc.Name, err)
+		}
 	}
 }
 
@@ -276,119 +358,119 @@ func getServicePort(svc *v1.Service) string {
 	return ""
 }
 
-type configYaml struct {
-	Key        string
-	Expression string
-	Prefix     string
-	Suffix     string
-}
-
 func updateServiceConfigMap(c kubernetes.Interface, svc *v1.Service, config *Config) {
 	name := svc.Name
 	ns := svc.Namespace
 	cm, err := c.CoreV1().ConfigMaps(ns).Get(name, metav1.GetOptions{})
 	if err == nil {
-		updated := false
-
-		clusterIP := svc.Spec.ClusterIP
-		if clusterIP != "" {
-			clusterIPKey := cm.Annotations[ExposeConfigClusterIPKeyAnnotation]
-			clusterIPPortKey := cm.Annotations[ExposeConfigClusterIPPortKeyAnnotation]
-			clusterIPPortIfEmptyKey := cm.Annotations[ExposeConfigClusterIPPortIfEmptyKeyAnnotation]
-
-			if clusterIPKey != "" {
-				if cm.Data[clusterIPKey] != clusterIP {
-					cm.Data[clusterIPKey] = clusterIP
-					updated = true
-				}
+		if cm.Data == nil {
+			cm.Data = map[string]string{}
+		}
+		if updateServiceData(svc, "ConfigMap", cm.Name, cm.Annotations, cm.Data) {
+			klog.Infof("Updating ConfigMap %s/%s", ns, name)
+			_, err = c.CoreV1().ConfigMaps(ns).Update(cm)
+			if err != nil {
+				klog.Errorf("Failed to update ConfigMap %s error: %v", name, err)
 			}
-
-			port := getServicePort(svc)
-			if port != "" {
-				clusterIPAndPort := clusterIP + ":" + port
-
-				if clusterIPPortKey != "" {
-					if cm.Data[clusterIPPortKey] != clusterIPAndPort {
-						cm.Data[clusterIPPortKey] = clusterIPAndPort
-						updated = true
-					}
-				}
-				if clusterIPPortIfEmptyKey != "" {
-					if cm.Data[clusterIPPortIfEmptyKey] == "" {
-						cm.Data[clusterIPPortIfEmptyKey] = clusterIPAndPort
-						updated = true
-					}
-				}
+			err = rollingUpgradeDeployments(cm, c)
+			if err != nil {
+				klog.Errorf("Failed to update Deployments after change to ConfigMap %s error: %v", name, err)
 			}
 		}
-		exposeURL := svc.Annotations[exposestrategy.ExposeAnnotationKey]
-		if exposeURL != "" {
-			host := ""
-			url, err := url.Parse(exposeURL)
-			if err != nil {
-				klog.Errorf("Failed to parse expose URL %s for service %s  error: %v", exposeURL, name, err)
+	}
+}
 
-			} else {
-				host = url.Host
+// updateServiceData exports the info of the service to the data of the ConfigMap or Secret with the same name,
+// as requested by its expose.config.fabric8.io annotations
+// returns true if the data changed
+func updateServiceData(svc *v1.Service, kind, name string, annotations, data map[string]string) bool {
+	updated := false
+
+	clusterIP := svc.Spec.ClusterIP
+	if clusterIP != "" {
+		clusterIPKey := annotations[ExposeConfigClusterIPKeyAnnotation]
+		clusterIPPortKey := annotations[ExposeConfigClusterIPPortKeyAnnotation]
+		clusterIPPortIfEmptyKey := annotations[ExposeConfigClusterIPPortIfEmptyKeyAnnotation]
+
+		if clusterIPKey != "" {
+			if data[clusterIPKey] != clusterIP {
+				data[clusterIPKey] = clusterIP
+				updated = true
 			}
-			urlKey := cm.Annotations[ExposeConfigURLKeyAnnotation]
-			domainKey := cm.Annotations[ExposeConfigHostKeyAnnotation]
-			if urlKey != "" {
-				if cm.Data[urlKey] != exposeURL {
-					cm.Data[urlKey] = exposeURL
+		}
+
+		port := getServicePort(svc)
+		if port != "" {
+			clusterIPAndPort := clusterIP + ":" + port
+
+			if clusterIPPortKey != "" {
+				if data[clusterIPPortKey] != clusterIPAndPort {
+					data[clusterIPPortKey] = clusterIPAndPort
 					updated = true
 				}
 			}
-			if host != "" && domainKey != "" {
-				if cm.Data[domainKey] != host {
-					cm.Data[domainKey] = host
+			if clusterIPPortIfEmptyKey != "" {
+				if data[clusterIPPortIfEmptyKey] == "" {
+					data[clusterIPPortIfEmptyKey] = clusterIPAndPort
 					updated = true
 				}
 			}
+		}
+	}
+	exposeURL := svc.Annotations[exposestrategy.ExposeAnnotationKey]
+	if exposeURL != "" {
+		host := ""
+		url, err := url.Parse(exposeURL)
+		if err != nil {
+			klog.Errorf("Failed to parse expose URL %s for service %s  error: %v", exposeURL, svc.Name, err)
 
-			pathKey := cm.Annotations[ExposeConfigClusterPathKeyAnnotation]
-			if pathKey != "" {
-				path := urlPath(exposeURL)
-				if cm.Data[pathKey] != path {
-					cm.Data[pathKey] = path
-					updated = true
-				}
-				klog.Infof("Found key %s and has path %s\n", pathKey, path)
+		} else {
+			host = url.Host
+		}
+		urlKey := annotations[ExposeConfigURLKeyAnnotation]
+		domainKey := annotations[ExposeConfigHostKeyAnnotation]
+		if urlKey != "" {
+			if data[urlKey] != exposeURL {
+				data[urlKey] = exposeURL
+				updated = true
 			}
-
-			configYamlS := cm.Annotations[ExposeConfigYamlAnnotation]
-			if configYamlS != "" {
-				fmt.Printf("Processing yaml config on configMap %s\n", cm.Name)
-				configs := []configYaml{}
-				err := yaml.Unmarshal([]byte(configYamlS), &configs)
-				if err != nil {
-					klog.Errorf("Failed to unmarshal Config YAML on configMap %s due to %s : YAML: %s", cm.Name, err, configYamlS)
-				} else {
-					values := map[string]string{
-						"host":              host,
-						"url":               exposeURL,
-					}
-					fmt.Printf("Loading yaml config %#v\n", configs)
-					for _, c := range configs {
-						if c.updateConfigMap(cm, values) {
-							updated = true
-						}
-					}
-				}
+		}
+		if host != "" && domainKey != "" {
+			if data[domainKey] != host {
+				data[domainKey] = host
+				updated = true
 			}
 		}
-		if updated {
-			klog.Infof("Updating ConfigMap %s/%s", ns, name)
-			_, err = c.CoreV1().ConfigMaps(ns).Update(cm)
-			if err != nil {
-				klog.Errorf("Failed to update ConfigMap %s error: %v", name, err)
+
+		pathKey := annotations[ExposeConfigClusterPathKeyAnnotation]
+		if pathKey != "" {
+			path := urlPath(exposeURL)
+			if data[pathKey] != path {
+				data[pathKey] = path
+				updated = true
 			}
-			err = rollingUpgradeDeployments(cm, c)
+			klog.Infof("Found key %s and has path %s\n", pathKey, path)
+		}
+
+		configYamlS := annotations[ExposeConfigYamlAnnotation]
+		if configYamlS != "" {
+			fmt.Printf("Processing yaml config on %s %s\n", kind, name)
+			configs := []configYaml{}
+			err := yaml.Unmarshal([]byte(configYamlS), &configs)
 			if err != nil {
-				klog.Errorf("Failed to update Deployments after change to ConfigMap %s error: %v", name, err)
+				klog.Errorf("Failed to unmarshal Config YAML on %s %s due to %s : YAML: %s", kind, name, err, configYamlS)
+			} else {
+				values := configYamlValues(svc, exposeURL)
+				fmt.Printf("Loading yaml config %#v\n", configs)
+				for _, c := range configs {
+					if c.updateData(kind, name, data, values) {
+						updated = true
+					}
+				}
 			}
 		}
 	}
+	return updated
 }
 
 // returns the path starting with a `/` character for the given URL
@@ -408,166 +490,172 @@ func urlPath(urlText string) string {
 	return answer
 }
 
-func (c *configYaml) updateConfigMap(configMap *v1.ConfigMap, values map[string]string) bool {
-	key := c.Key
-	if key == "" {
-		klog.Warningf("ConfigMap %s does not have a key in yaml config %#v\n", configMap.Name, c)
-		return false
-	}
-	expValue := values[c.Expression]
-	if expValue == "" {
-		klog.Warningf("Could not calculate expression %s from the yaml config %#v possible values are %v\n", c.Expression, c, values)
-		return false
-	}
-	value := configMap.Data[key]
-	if value == "" {
-		klog.Warningf("ConfigMap %s does not have a key %s when trying to apply the yaml config %#v\n", configMap.Name, key, c)
-		return false
-	}
-	lines := strings.Split(value, "\n")
-	var buffer bytes.Buffer
-	for _, line := range lines {
-		if strings.HasPrefix(line, c.Prefix) {
-			buffer.WriteString(c.Prefix + expValue + c.Suffix)
-		} else {
-			buffer.WriteString(line)
-		}
-		buffer.WriteString("\n")
-	}
-	newValue := buffer.String()
-	if newValue != value {
-		configMap.Data[key] = newValue
-		return true
-	}
-	return false
-}
-
 // updateOtherConfigMaps lets update all other configmaps which want to be injected by this svc exposeURL
+// including the ones of the namespaces allowed by cross-namespace-exports
 func updateOtherConfigMaps(c kubernetes.Interface, svc *v1.Service, config *Config, exposeURL string) error {
-	serviceName := svc.Name
-	annotationKey := "expose.service-key.config.fabric8.io/" + serviceName
-	annotationFullKey := "expose-full.service-key.config.fabric8.io/" + serviceName
-	annotationNoProtocolKey := "expose-no-protocol.service-key.config.fabric8.io/" + serviceName
-	annotationNoPathKey := "expose-no-path.service-key.config.fabric8.io/" + serviceName
-	annotationFullNoProtocolKey := "expose-full-no-protocol.service-key.config.fabric8.io/" + serviceName
-	annotationProtocolKey := "expose-protocol.service-key.config.fabric8.io/" + serviceName
-	ns := svc.Namespace
-	cms, err := c.CoreV1().ConfigMaps(ns).List(metav1.ListOptions{})
-	if err != nil {
-		return err
-	}
-	for _, cm := range cms.Items {
-		update := false
-		updateKey := cm.Annotations[annotationKey]
-		if cm.Data == nil {
-			cm.Data = map[string]string{}
-		}
-		if updateKey != "" {
-			exposeURL = strings.TrimSuffix(exposeURL, "/")
-			keys := strings.Split(updateKey, ",")
-			for _, key := range keys {
-				value := cm.Data[key]
-				if value != exposeURL {
-					cm.Data[key] = exposeURL
-					klog.Infof("Updating ConfigMap %s in namespace %s with key %s", cm.Name, ns, key)
-					update = true
-				}
+	for _, ns := range exportNamespaces(svc, config) {
+		cms, err := c.CoreV1().ConfigMaps(ns).List(metav1.ListOptions{})
+		if err != nil {
+			if ns == svc.Namespace {
+				return err
 			}
+			klog.Warningf("Failed to list the ConfigMaps to export service %s/%s to: %v", svc.Namespace, svc.Name, err)
+			continue
 		}
-		updateKey = cm.Annotations[annotationFullKey]
-		if updateKey != "" {
-			if !strings.HasSuffix(exposeURL, "/") {
-				exposeURL += "/"
+		for _, cm := range cms.Items {
+			if cm.Data == nil {
+				cm.Data = map[string]string{}
 			}
-			keys := strings.Split(updateKey, ",")
-			for _, key := range keys {
-				value := cm.Data[key]
-				if value != exposeURL {
-					cm.Data[key] = exposeURL
-					klog.Infof("Updating ConfigMap %s in namespace %s with key %s", cm.Name, ns, key)
+			update := false
+			for _, serviceKey := range exportServiceKeys(svc, cm.Namespace) {
+				if updateOtherData(serviceKey, config, exposeURL, "ConfigMap", cm.Namespace, cm.Name, cm.Annotations, cm.Data) {
 					update = true
 				}
 			}
-		}
-		updateKey = cm.Annotations[annotationNoPathKey]
-		if cm.Data == nil {
-			cm.Data = map[string]string{}
-		}
-		if updateKey != "" {
-			u, err := url.Parse(exposeURL)
-			if err != nil {
-				klog.Warningf("Failed to parse URL %s due to %s", exposeURL, err)
-			} else {
-				u.Path = "/"
-				noPathURL := u.String()
-				keys := strings.Split(updateKey, ",")
-				for _, key := range keys {
-					value := cm.Data[key]
-					if value != noPathURL {
-						cm.Data[key] = noPathURL
-						klog.Infof("Updating ConfigMap %s in namespace %s with key %s", cm.Name, ns, key)
-						update = true
-					}
+			if update {
+				_, err = c.CoreV1().ConfigMaps(cm.Namespace).Update(&cm)
+				if err != nil {
+					return fmt.Errorf("Failed to update ConfigMap %s in namespace %s due to %v", cm.Name, cm.Namespace, err)
 				}
 			}
 		}
-		updateKey = cm.Annotations[annotationNoProtocolKey]
-		if cm.Data == nil {
-			cm.Data = map[string]string{}
+	}
+	return nil
+}
+
+// exportNamespaces returns the namespaces whose ConfigMaps and Secrets can import the exposeURL of the service:
+// its own namespace, and the ones allowed by cross-namespace-exports, "" for all
+func exportNamespaces(svc *v1.Service, config *Config) []string {
+	namespaces := []string{svc.Namespace}
+	for _, ns := range config.CrossNamespaceExports[svc.Namespace] {
+		if ns == "*" {
+			return []string{metav1.NamespaceAll}
 		}
-		if updateKey != "" {
-			exposeURL = strings.TrimSuffix(exposeURL, "/")
-			exposeURL = strings.TrimPrefix(exposeURL, "http://")
-			exposeURL = strings.TrimPrefix(exposeURL, "https://")
-			keys := strings.Split(updateKey, ",")
-			for _, key := range keys {
-				value := cm.Data[key]
-				if value != exposeURL {
-					cm.Data[key] = exposeURL
-					klog.Infof("Updating ConfigMap %s in namespace %s with key %s", cm.Name, ns, key)
-					update = true
-				}
+		found := false
+		for _, n := range namespaces {
+			found = found || n == ns
+		}
+		if !found {
+			namespaces = append(namespaces, ns)
+		}
+	}
+	return namespaces
+}
+
+// exportServiceKeys returns the keys of the service in the annotations of the ConfigMaps and Secrets of the namespace:
+// "<namespace>.<service>", and "<service>" in its own namespace
+func exportServiceKeys(svc *v1.Service, namespace string) []string {
+	if namespace == svc.Namespace {
+		return []string{svc.Name, svc.Namespace + "." + svc.Name}
+	}
+	return []string{svc.Namespace + "." + svc.Name}
+}
+
+// updateOtherData exports the exposeURL of the service to the data of another ConfigMap or Secret,
+// as requested by its expose*.service-key.config.fabric8.io/<serviceKey> annotations
+// returns true if the data changed
+func updateOtherData(serviceKey string, config *Config, exposeURL, kind, namespace, name string, annotations, data map[string]string) bool {
+	annotationKey := "expose.service-key.config.fabric8.io/" + serviceKey
+	annotationFullKey := "expose-full.service-key.config.fabric8.io/" + serviceKey
+	annotationNoProtocolKey := "expose-no-protocol.service-key.config.fabric8.io/" + serviceKey
+	annotationNoPathKey := "expose-no-path.service-key.config.fabric8.io/" + serviceKey
+	annotationFullNoProtocolKey := "expose-full-no-protocol.service-key.config.fabric8.io/" + serviceKey
+	annotationProtocolKey := "expose-protocol.service-key.config.fabric8.io/" + serviceKey
+	update := false
+	updateKey := annotations[annotationKey]
+	if updateKey != "" {
+		exposeURL = strings.TrimSuffix(exposeURL, "/")
+		keys := strings.Split(updateKey, ",")
+		for _, key := range keys {
+			value := data[key]
+			if value != exposeURL {
+				data[key] = exposeURL
+				klog.Infof("Updating %s %s in namespace %s with key %s", kind, name, namespace, key)
+				update = true
 			}
 		}
-		updateKey = cm.Annotations[annotationFullNoProtocolKey]
-		if updateKey != "" {
-			if !strings.HasSuffix(exposeURL, "/") {
-				exposeURL += "/"
+	}
+	updateKey = annotations[annotationFullKey]
+	if updateKey != "" {
+		if !strings.HasSuffix(exposeURL, "/") {
+			exposeURL += "/"
+		}
+		keys := strings.Split(updateKey, ",")
+		for _, key := range keys {
+			value := data[key]
+			if value != exposeURL {
+				data[key] = exposeURL
+				klog.Infof("Updating %s %s in namespace %s with key %s", kind, name, namespace, key)
+				update = true
 			}
-			exposeURL = strings.TrimPrefix(exposeURL, "http://")
-			exposeURL = strings.TrimPrefix(exposeURL, "https://")
+		}
+	}
+	updateKey = annotations[annotationNoPathKey]
+	if updateKey != "" {
+		u, err := url.Parse(exposeURL)
+		if err != nil {
+			klog.Warningf("Failed to parse URL %s due to %s", exposeURL, err)
+		} else {
+			u.Path = "/"
+			noPathURL := u.String()
 			keys := strings.Split(updateKey, ",")
 			for _, key := range keys {
-				value := cm.Data[key]
-				if value != exposeURL {
-					cm.Data[key] = exposeURL
-					klog.Infof("Updating ConfigMap %s in namespace %s with key %s", cm.Name, ns, key)
+				value := data[key]
+				if value != noPathURL {
+					data[key] = noPathURL
+					klog.Infof("Updating %s %s in namespace %s with key %s", kind, name, namespace, key)
 					update = true
 				}
 			}
 		}
-		updateKey = cm.Annotations[annotationProtocolKey]
-		if updateKey != "" {
-			protocol := "https"
-			if config.HTTP {
-				protocol = "http"
+	}
+	updateKey = annotations[annotationNoProtocolKey]
+	if updateKey != "" {
+		exposeURL = strings.TrimSuffix(exposeURL, "/")
+		exposeURL = strings.TrimPrefix(exposeURL, "http://")
+		exposeURL = strings.TrimPrefix(exposeURL, "https://")
+		keys := strings.Split(updateKey, ",")
+		for _, key := range keys {
+			value := data[key]
+			if value != exposeURL {
+				data[key] = exposeURL
+				klog.Infof("Updating %s %s in namespace %s with key %s", kind, name, namespace, key)
+				update = true
 			}
-			keys := strings.Split(updateKey, ",")
-			for _, key := range keys {
-				value := cm.Data[key]
-				if value != protocol {
-					cm.Data[key] = protocol
-					klog.Infof("Updating ConfigMap %s in namespace %s with key %s", cm.Name, ns, key)
-					update = true
-				}
+		}
+	}
+	updateKey = annotations[annotationFullNoProtocolKey]
+	if updateKey != "" {
+		if !strings.HasSuffix(exposeURL, "/") {
+			exposeURL += "/"
+		}
+		exposeURL = strings.TrimPrefix(exposeURL, "http://")
+		exposeURL = strings.TrimPrefix(exposeURL, "https://")
+		keys := strings.Split(updateKey, ",")
+		for _, key := range keys {
+			value := data[key]
+			if value != exposeURL {
+				data[key] = exposeURL
+				klog.Infof("Updating %s %s in namespace %s with key %s", kind, name, namespace, key)
+				update = true
 			}
 		}
-		if update {
-			_, err = c.CoreV1().ConfigMaps(ns).Update(&cm)
-			if err != nil {
-				return fmt.Errorf("Failed to update ConfigMap %s in namespace %s with key %s due to %v", cm.Name, ns, updateKey, err)
+	}
+	updateKey = annotations[annotationProtocolKey]
+	if updateKey != "" {
+		protocol := "https"
+		if config.HTTP {
+			protocol = "http"
+		}
+		keys := strings.Split(updateKey, ",")
+		for _, key := range keys {
+			value := data[key]
+			if value != protocol {
+				data[key] = protocol
+				klog.Infof("Updating %s %s in namespace %s with key %s", kind, name, namespace, key)
+				update = true
 			}
 		}
 	}
-	return nil
+	return update
 }
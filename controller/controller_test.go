@@ -190,7 +190,7 @@ func TestRun_controllerSynced(t *testing.T) {
 		testStrategy = nil
 	}()
 
-	err := Run(client, "main", &Config{}, time.Second)
+	err := Run(client, nil, "main", &Config{}, time.Second)
 	require.NoError(t, err)
 	strategy.checkEnd()
 }
@@ -294,7 +294,7 @@ func TestRun_strategySynced(t *testing.T) {
 		testStrategy = nil
 	}()
 
-	err := Run(client, "main", &Config{}, time.Second)
+	err := Run(client, nil, "main", &Config{}, time.Second)
 	require.NoError(t, err)
 	strategy.checkEnd()
 }
@@ -330,7 +330,7 @@ func TestRun_timeout(t *testing.T) {
 		testStrategy = nil
 	}()
 
-	err := Run(client, "main", &Config{}, time.Second)
+	err := Run(client, nil, "main", &Config{}, time.Second)
 	require.Error(t, err)
 	strategy.checkEnd()
 }
@@ -444,7 +444,7 @@ func TestDaemon(t *testing.T) {
 		testStrategy = nil
 	}()
 
-	controller, err := Daemon(client, "main", &Config{}, time.Hour)
+	controller, err := Daemon(client, nil, "main", &Config{}, time.Hour)
 	require.NoError(t, err)
 	stopChan := make(chan struct{})
 	defer close(stopChan)
@@ -498,3 +498,57 @@ func TestDaemon(t *testing.T) {
 	time.Sleep(500*time.Millisecond)
 	strategy.checkEnd()
 }
+
+func TestUpdateRelatedResources_crossNamespace(t *testing.T) {
+	svc := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "api",
+			Name:      "backend",
+			Annotations: map[string]string{
+				exposestrategy.ExposeAnnotationKey: "https://backend.api.example.com",
+			},
+		},
+	}
+	configMap := func(namespace, name, key string) *v1.ConfigMap {
+		return &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+			Namespace:   namespace,
+			Name:        name,
+			Annotations: map[string]string{"expose.service-key.config.fabric8.io/" + key: "backend"},
+		}}
+	}
+	for _, test := range []struct {
+		name     string
+		exports  map[string][]string
+		expected map[string]bool
+	}{
+		{"not allowed", nil, map[string]bool{"api": true, "api-short": true}},
+		{"allowed", map[string][]string{"api": {"web"}}, map[string]bool{"api": true, "api-short": true, "web": true}},
+		{"other service namespace", map[string][]string{"other": {"web", "admin"}}, map[string]bool{"api": true, "api-short": true}},
+		{"all", map[string][]string{"api": {"*"}}, map[string]bool{"api": true, "api-short": true, "web": true, "admin": true}},
+	} {
+		client := fake.NewSimpleClientset(svc,
+			configMap("api", "urls", "api.backend"),
+			configMap("web", "urls", "api.backend"),
+			configMap("admin", "urls", "api.backend"),
+			// the short form only matches the services of the same namespace
+			configMap("api", "short", "backend"),
+			configMap("web", "short", "backend"))
+		updateRelatedResources(client, svc, &Config{CrossNamespaceExports: test.exports})
+
+		for _, cm := range []struct{ namespace, name, key string }{
+			{"api", "urls", "api"},
+			{"api", "short", "api-short"},
+			{"web", "urls", "web"},
+			{"admin", "urls", "admin"},
+			{"web", "short", "web-short"},
+		} {
+			actual, err := client.CoreV1().ConfigMaps(cm.namespace).Get(cm.name, metav1.GetOptions{})
+			require.NoError(t, err, test.name)
+			if test.expected[cm.key] {
+				assert.Equal(t, "https://backend.api.example.com", actual.Data["backend"], "%s: %s", test.name, cm.key)
+			} else {
+				assert.Empty(t, actual.Data["backend"], "%s: %s", test.name, cm.key)
+			}
+		}
+	}
+}
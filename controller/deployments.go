@@ -14,9 +14,12 @@ import (
 )
 
 func rollingUpgradeDeployments(cm *v1.ConfigMap, c kubernetes.Interface) error {
-	ns := cm.Namespace
-	configMapName := cm.Name
-	configMapVersion := convertConfigMapToToken(cm)
+	return rollingUpgradeDeploymentsOnChange(c, cm.Namespace, cm.Name, convertConfigMapToToken(cm), updateOnChangeAnnotation, "CONFIGMAP")
+}
+
+// rollingUpgradeDeploymentsOnChange updates the env var of the deployments with the annotation listing the name
+// so that they roll out with the new version of the ConfigMap or Secret
+func rollingUpgradeDeploymentsOnChange(c kubernetes.Interface, ns, configMapName, configMapVersion, annotation, kind string) error {
 
 	deployments, err := c.ExtensionsV1beta1().Deployments(ns).List(metav1.ListOptions{})
 	if err != nil {
@@ -25,7 +28,7 @@ func rollingUpgradeDeployments(cm *v1.ConfigMap, c kubernetes.Interface) error {
 	for _, d := range deployments.Items {
 		containers := d.Spec.Template.Spec.Containers
 		// match deployments with the correct annotation
-		annotationValue, _ := d.ObjectMeta.Annotations[updateOnChangeAnnotation]
+		annotationValue, _ := d.ObjectMeta.Annotations[annotation]
 		if annotationValue != "" {
 			values := strings.Split(annotationValue, ",")
 			matches := false
@@ -36,7 +39,7 @@ func rollingUpgradeDeployments(cm *v1.ConfigMap, c kubernetes.Interface) error {
 				}
 			}
 			if matches {
-				updateContainers(containers, annotationValue, configMapVersion)
+				updateContainers(containers, annotationValue, configMapVersion, kind)
 
 				// update the deployment
 				_, err := c.ExtensionsV1beta1().Deployments(ns).Update(&d)
@@ -63,12 +66,12 @@ func convertConfigMapToToken(cm *v1.ConfigMap) string {
 	return text
 }
 
-func updateContainers(containers []v1.Container, annotationValue, configMapVersion string) bool {
+func updateContainers(containers []v1.Container, annotationValue, configMapVersion, kind string) bool {
 	// we can have multiple configmaps to update
 	answer := false
 	configmaps := strings.Split(annotationValue, ",")
 	for _, cmNameToUpdate := range configmaps {
-		configmapEnvar := "FABRIC8_" + convertToEnvVarName(cmNameToUpdate) + "_CONFIGMAP"
+		configmapEnvar := "FABRIC8_" + convertToEnvVarName(cmNameToUpdate) + "_" + kind
 
 		for i := range containers {
 			envs := containers[i].Env
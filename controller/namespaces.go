@@ -0,0 +1,131 @@
+package controller
+
+import (
+	"time"
+
+	"k8s.io/klog"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/olli-ai/exposecontroller/exposestrategy"
+)
+
+// informersController runs the services controller once the namespaces and policies informers are synced
+type informersController struct {
+	cache.Controller
+	informers []cache.Controller
+	// closed once the services controller stops
+	stopped chan struct{}
+}
+
+// Run runs the informers, then the services controller
+func (c *informersController) Run(stopCh <-chan struct{}) {
+	defer close(c.stopped)
+	synced := []cache.InformerSynced{}
+	for _, informer := range c.informers {
+		go informer.Run(stopCh)
+		synced = append(synced, informer.HasSynced)
+	}
+	if !cache.WaitForCacheSync(stopCh, synced...) {
+		return
+	}
+	c.Controller.Run(stopCh)
+}
+
+// HasSynced tells if the informers and the services controller are synced
+func (c *informersController) HasSynced() bool {
+	for _, informer := range c.informers {
+		if !informer.HasSynced() {
+			return false
+		}
+	}
+	return c.Controller.HasSynced()
+}
+
+// newNamespacesController watches the namespaces to keep their overrides up to date
+// onChange is called when the overrides of a namespace change
+// returns nil if the namespaces can't be listed
+func newNamespacesController(client kubernetes.Interface, namespace string, overrides *exposestrategy.NamespaceOverrides, resyncPeriod time.Duration, onChange func(namespace string)) cache.Controller {
+	tweak := func(options *metav1.ListOptions) {
+		if namespace != "" {
+			options.FieldSelector = fields.OneTermEqualSelector("metadata.name", namespace).String()
+		}
+	}
+	options := metav1.ListOptions{Limit: 1}
+	tweak(&options)
+	if _, err := client.CoreV1().Namespaces().List(options); err != nil {
+		klog.Warningf("Failed to list namespaces, ignoring the namespace annotations: %v", err)
+		return nil
+	}
+
+	update := func(obj interface{}) {
+		ns := obj.(*v1.Namespace)
+		if overrides.Update(ns) {
+			onChange(ns.Name)
+		}
+	}
+	_, controller := cache.NewInformer(
+		&cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				tweak(&options)
+				return client.CoreV1().Namespaces().List(options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				tweak(&options)
+				return client.CoreV1().Namespaces().Watch(options)
+			},
+		},
+		&v1.Namespace{},
+		resyncPeriod,
+		cache.ResourceEventHandlerFuncs{
+			AddFunc: update,
+			UpdateFunc: func(oldObj, newObj interface{}) {
+				update(newObj)
+			},
+			DeleteFunc: func(obj interface{}) {
+				if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+					obj = tombstone.Obj
+				}
+				if ns, ok := obj.(*v1.Namespace); ok {
+					overrides.Delete(ns.Name)
+				}
+			},
+		},
+	)
+	return controller
+}
+
+// forService returns the config with the settings overridden for the service
+func (c *Config) forService(overrides *exposestrategy.NamespaceOverrides, svc *v1.Service) *Config {
+	settings, ok := overrides.Apply(svc, exposestrategy.NamespaceSettings{
+		Domain:         c.Domain,
+		InternalDomain: c.InternalDomain,
+		TLSSecretName:  c.TLSSecretName,
+		TLSUseWildcard: c.TLSUseWildcard,
+		TLSAcme:        c.TLSAcme,
+		HTTP:           c.HTTP,
+		PathMode:       c.PathMode,
+		IngressClass:   c.IngressClass,
+		AllowedHosts:   c.AllowedHosts,
+	})
+	if !ok {
+		return c
+	}
+	clone := *c
+	clone.Domain = settings.Domain
+	clone.InternalDomain = settings.InternalDomain
+	clone.TLSSecretName = settings.TLSSecretName
+	clone.TLSUseWildcard = settings.TLSUseWildcard
+	clone.TLSAcme = settings.TLSAcme
+	clone.HTTP = settings.HTTP
+	clone.PathMode = settings.PathMode
+	clone.IngressClass = settings.IngressClass
+	clone.AllowedHosts = settings.AllowedHosts
+	return &clone
+}
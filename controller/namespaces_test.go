@@ -0,0 +1,108 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/olli-ai/exposecontroller/exposestrategy"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDaemon_namespaceOverrides(t *testing.T) {
+	client := fake.NewSimpleClientset(&v1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: "main"},
+	}, &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "main",
+			Name:      "svc1",
+			Annotations: map[string]string{
+				exposestrategy.ExposeAnnotation.Key: exposestrategy.ExposeAnnotation.Value,
+			},
+			ResourceVersion: "1",
+		},
+	}, &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:       "main",
+			Name:            "svc2",
+			ResourceVersion: "2",
+		},
+	})
+	strategy := fakeStrategy{
+		testing: t,
+		tasks: []map[string]bool{{
+			"Sync":            true,
+			"Add:main/svc1:1": true,
+		}},
+		ignore: []map[string]bool{{
+			"Clean:main/svc2:2": true,
+		}},
+	}
+	testStrategy = &strategy
+	defer func() {
+		testStrategy = nil
+	}()
+
+	controller, err := Daemon(client, nil, "main", &Config{}, time.Hour)
+	require.NoError(t, err)
+	stopChan := make(chan struct{})
+	defer close(stopChan)
+	go controller.Run(stopChan)
+
+	time.Sleep(500 * time.Millisecond)
+	strategy.checkEnd()
+
+	// only the exposed services are added again
+	strategy.tasks = []map[string]bool{{
+		"Add:main/svc1:1": true,
+	}}
+	client.CoreV1().Namespaces().Update(&v1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "main",
+			Annotations: map[string]string{
+				exposestrategy.NamespaceAnnotationPrefix + "domain": "main.example.com",
+			},
+		},
+	})
+
+	time.Sleep(500 * time.Millisecond)
+	strategy.checkEnd()
+
+	// other annotations don't change the overrides
+	client.CoreV1().Namespaces().Update(&v1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "main",
+			Annotations: map[string]string{
+				exposestrategy.NamespaceAnnotationPrefix + "domain": "main.example.com",
+				"owner": "team",
+			},
+		},
+	})
+
+	time.Sleep(500 * time.Millisecond)
+	strategy.checkEnd()
+}
+
+func TestConfigForNamespace(t *testing.T) {
+	config := &Config{Domain: "example.com", HTTP: false, Services: []string{"svc1"}}
+	overrides := exposestrategy.NewNamespaceOverrides()
+	overrides.Update(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name: "preview",
+		Annotations: map[string]string{
+			exposestrategy.NamespaceAnnotationPrefix + "http": "true",
+		},
+	}})
+
+	main := &v1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "main", Name: "svc1"}}
+	assert.True(t, config == config.forService(overrides, main), "same config without overrides")
+	preview := config.forService(overrides, &v1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "preview", Name: "svc1"}})
+	assert.True(t, preview.HTTP)
+	assert.Equal(t, "example.com", preview.Domain)
+	assert.Equal(t, []string{"svc1"}, preview.Services)
+	assert.False(t, config.HTTP, "unchanged config")
+}
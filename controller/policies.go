@@ -0,0 +1,289 @@
+package controller
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"k8s.io/klog"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/olli-ai/exposecontroller/exposestrategy"
+)
+
+const (
+	// ExposeConfigKind is the kind of the cluster resources configuring the services of the selected namespaces
+	ExposeConfigKind = "ExposeConfig"
+	// ExposePolicyKind is the kind of the namespaced resources configuring the selected services of their namespace
+	ExposePolicyKind = "ExposePolicy"
+	// ReadyCondition is the condition telling if the resource is valid and applied
+	ReadyCondition = "Ready"
+)
+
+var (
+	exposeConfigResource = schema.GroupVersionResource{Group: "exposecontroller.fabric8.io", Version: "v1alpha1", Resource: "exposeconfigs"}
+	exposePolicyResource = schema.GroupVersionResource{Group: "exposecontroller.fabric8.io", Version: "v1alpha1", Resource: "exposepolicies"}
+)
+
+// ExposeSpec is the spec of the ExposeConfig and ExposePolicy resources
+type ExposeSpec struct {
+	// NamespaceSelector selects the namespaces, only for ExposeConfig, all if missing
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	// ServiceSelector selects the services, all if missing
+	ServiceSelector *metav1.LabelSelector `json:"serviceSelector,omitempty"`
+
+	Exposer        string     `json:"exposer,omitempty"`
+	Domain         string     `json:"domain,omitempty"`
+	InternalDomain string     `json:"internalDomain,omitempty"`
+	URLTemplate    string     `json:"urlTemplate,omitempty"`
+	PathMode       string     `json:"pathMode,omitempty"`
+	IngressClass   string     `json:"ingressClass,omitempty"`
+	HTTP           *bool      `json:"http,omitempty"`
+	TLS            *ExposeTLS `json:"tls,omitempty"`
+	AllowedHosts   []string   `json:"allowedHosts,omitempty"`
+}
+
+// ExposeTLS is the TLS config of the ExposeConfig and ExposePolicy resources
+type ExposeTLS struct {
+	SecretName  string `json:"secretName,omitempty"`
+	Acme        *bool  `json:"acme,omitempty"`
+	UseWildcard *bool  `json:"useWildcard,omitempty"`
+}
+
+// overrides returns the settings set by the spec, with the keys of the config
+func (spec *ExposeSpec) overrides() map[string]string {
+	overrides := map[string]string{}
+	set := func(key, value string) {
+		if value != "" {
+			overrides[key] = value
+		}
+	}
+	setBool := func(key string, value *bool) {
+		if value != nil {
+			overrides[key] = strconv.FormatBool(*value)
+		}
+	}
+	set("exposer", spec.Exposer)
+	set("domain", spec.Domain)
+	set("internal-domain", spec.InternalDomain)
+	set("urltemplate", spec.URLTemplate)
+	set("path-mode", spec.PathMode)
+	set("ingress-class", spec.IngressClass)
+	setBool("http", spec.HTTP)
+	if spec.TLS != nil {
+		set("tls-secret-name", spec.TLS.SecretName)
+		setBool("tls-acme", spec.TLS.Acme)
+		setBool("tls-use-wildcard", spec.TLS.UseWildcard)
+	}
+	set("allowed-hosts", strings.Join(spec.AllowedHosts, ","))
+	return overrides
+}
+
+// policyName returns the name of the policy of the resource
+func policyName(obj *unstructured.Unstructured) string {
+	if obj.GetNamespace() == "" {
+		return obj.GetKind() + "/" + obj.GetName()
+	}
+	return obj.GetKind() + "/" + obj.GetNamespace() + "/" + obj.GetName()
+}
+
+// getPolicy parses and checks the ExposeConfig or ExposePolicy resource
+func getPolicy(obj *unstructured.Unstructured) (*exposestrategy.Policy, *ExposeSpec, error) {
+	spec := &ExposeSpec{}
+	if content, ok := obj.Object["spec"].(map[string]interface{}); ok {
+		err := runtime.DefaultUnstructuredConverter.FromUnstructured(content, spec)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "invalid spec")
+		}
+	}
+	policy := &exposestrategy.Policy{
+		Name:      policyName(obj),
+		Namespace: obj.GetNamespace(),
+		Overrides: spec.overrides(),
+	}
+	var err error
+	if spec.NamespaceSelector != nil {
+		if obj.GetKind() != ExposeConfigKind {
+			return nil, nil, errors.Errorf("namespaceSelector is only allowed on %s", ExposeConfigKind)
+		}
+		policy.NamespaceSelector, err = metav1.LabelSelectorAsSelector(spec.NamespaceSelector)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "invalid namespaceSelector")
+		}
+	}
+	if spec.ServiceSelector != nil {
+		policy.ServiceSelector, err = metav1.LabelSelectorAsSelector(spec.ServiceSelector)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "invalid serviceSelector")
+		}
+	}
+	if err = policy.Check(); err != nil {
+		return nil, nil, err
+	}
+	return policy, spec, nil
+}
+
+// updateReadyCondition sets the Ready condition of the resource status, if it changed
+func updateReadyCondition(resources dynamic.NamespaceableResourceInterface, obj *unstructured.Unstructured, policyErr error) {
+	status, reason, message := metav1.ConditionTrue, "Applied", "the settings are applied to the selected services"
+	if policyErr != nil {
+		status, reason, message = metav1.ConditionFalse, "Invalid", policyErr.Error()
+	}
+	conditions, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	transition := metav1.Now().UTC().Format(time.RFC3339)
+	for _, c := range conditions {
+		condition, ok := c.(map[string]interface{})
+		if !ok || condition["type"] != ReadyCondition {
+			continue
+		}
+		if condition["status"] == string(status) {
+			generation, _, _ := unstructured.NestedInt64(obj.Object, "status", "observedGeneration")
+			if condition["reason"] == reason && condition["message"] == message && generation == obj.GetGeneration() {
+				return
+			}
+			if t, ok := condition["lastTransitionTime"].(string); ok {
+				transition = t
+			}
+		}
+	}
+
+	clone := obj.DeepCopy()
+	unstructured.SetNestedField(clone.Object, obj.GetGeneration(), "status", "observedGeneration")
+	unstructured.SetNestedSlice(clone.Object, []interface{}{map[string]interface{}{
+		"type":               ReadyCondition,
+		"status":             string(status),
+		"reason":             reason,
+		"message":            message,
+		"lastTransitionTime": transition,
+	}}, "status", "conditions")
+	_, err := resources.Namespace(obj.GetNamespace()).UpdateStatus(clone, metav1.UpdateOptions{})
+	if err != nil {
+		klog.Warningf("Failed to update the status of %s: %v", policyName(obj), err)
+	}
+}
+
+// newPoliciesControllers watches the ExposeConfig resources and the ExposePolicy resources of the namespace
+// onChange is called with the namespace of the services whose settings may have changed, empty for all
+// returns no controller for the resources which can't be listed, like when their CRD isn't installed
+func newPoliciesControllers(dynamicClient dynamic.Interface, namespace string, overrides *exposestrategy.NamespaceOverrides, resyncPeriod time.Duration, onChange func(namespace string)) []cache.Controller {
+	if dynamicClient == nil {
+		return nil
+	}
+	controllers := []cache.Controller{}
+	for _, r := range []struct {
+		resource  schema.GroupVersionResource
+		kind      string
+		namespace string
+	}{
+		{exposeConfigResource, ExposeConfigKind, ""},
+		{exposePolicyResource, ExposePolicyKind, namespace},
+	} {
+		resources := dynamicClient.Resource(r.resource)
+		list := resources.Namespace(r.namespace)
+		if _, err := list.List(metav1.ListOptions{Limit: 1}); err != nil {
+			if apierrors.IsNotFound(err) {
+				klog.Infof("No %s resource definition found, ignoring them", r.kind)
+			} else {
+				klog.Warningf("Failed to list %s resources, ignoring them: %v", r.kind, err)
+			}
+			continue
+		}
+
+		update := func(obj interface{}) {
+			u := obj.(*unstructured.Unstructured)
+			policy, _, err := getPolicy(u)
+			updateReadyCondition(resources, u, err)
+			if err != nil {
+				klog.Warningf("Ignoring invalid %s: %v", policyName(u), err)
+				policy = overrides.DeletePolicy(policyName(u))
+				if policy != nil {
+					onChange(policy.Namespace)
+				}
+				return
+			}
+			if overrides.SetPolicy(policy) {
+				onChange(policy.Namespace)
+			}
+		}
+		_, controller := cache.NewInformer(
+			&cache.ListWatch{
+				ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+					return list.List(options)
+				},
+				WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+					return list.Watch(options)
+				},
+			},
+			&unstructured.Unstructured{},
+			resyncPeriod,
+			cache.ResourceEventHandlerFuncs{
+				AddFunc: update,
+				UpdateFunc: func(oldObj, newObj interface{}) {
+					// ignores the status updates
+					if oldObj.(*unstructured.Unstructured).GetGeneration() != newObj.(*unstructured.Unstructured).GetGeneration() ||
+						newObj.(*unstructured.Unstructured).GetGeneration() == 0 {
+						update(newObj)
+					}
+				},
+				DeleteFunc: func(obj interface{}) {
+					if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+						obj = tombstone.Obj
+					}
+					if u, ok := obj.(*unstructured.Unstructured); ok {
+						if policy := overrides.DeletePolicy(policyName(u)); policy != nil {
+							onChange(policy.Namespace)
+						}
+					}
+				},
+			},
+		)
+		controllers = append(controllers, controller)
+	}
+	return controllers
+}
+
+// MergeExposeConfigs merges the valid ExposeConfig resources selecting all the services into the config
+// returns true if any was found
+func (c *Config) MergeExposeConfigs(dynamicClient dynamic.Interface) (bool, error) {
+	list, err := dynamicClient.Resource(exposeConfigResource).List(metav1.ListOptions{})
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	} else if err != nil {
+		return false, errors.Wrapf(err, "failed to list %s resources", ExposeConfigKind)
+	}
+	sort.Slice(list.Items, func(i, j int) bool {
+		return list.Items[i].GetName() < list.Items[j].GetName()
+	})
+	found := false
+	for i := range list.Items {
+		policy, spec, err := getPolicy(&list.Items[i])
+		if err != nil {
+			klog.Warningf("Ignoring invalid %s: %v", policyName(&list.Items[i]), err)
+			continue
+		} else if spec.NamespaceSelector != nil || spec.ServiceSelector != nil {
+			continue
+		}
+		found = true
+		keys := make([]string, 0, len(policy.Overrides))
+		for key := range policy.Overrides {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if err := c.Override(key, policy.Overrides[key], policy.Name); err != nil {
+				return found, err
+			}
+		}
+	}
+	return found, nil
+}
@@ -0,0 +1,156 @@
+package controller
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+
+	"github.com/olli-ai/exposecontroller/exposestrategy"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newPolicyObject(kind, namespace, name string, spec map[string]interface{}) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{"spec": spec}}
+	obj.SetAPIVersion("exposecontroller.fabric8.io/v1alpha1")
+	obj.SetKind(kind)
+	obj.SetNamespace(namespace)
+	obj.SetName(name)
+	return obj
+}
+
+func TestGetPolicy(t *testing.T) {
+	policy, spec, err := getPolicy(newPolicyObject(ExposeConfigKind, "", "previews", map[string]interface{}{
+		"namespaceSelector": map[string]interface{}{"matchLabels": map[string]interface{}{"env": "preview"}},
+		"domain":            "preview.example.com",
+		"http":              false,
+		"tls": map[string]interface{}{
+			"secretName": "preview-tls",
+			"acme":       true,
+		},
+		"allowedHosts": []interface{}{"*.example.com", "example.com"},
+	}))
+	require.NoError(t, err)
+	assert.Equal(t, "ExposeConfig/previews", policy.Name)
+	assert.Equal(t, "", policy.Namespace)
+	assert.Equal(t, "env=preview", policy.NamespaceSelector.String())
+	assert.Nil(t, policy.ServiceSelector)
+	assert.Equal(t, "preview.example.com", spec.Domain)
+	assert.Equal(t, map[string]string{
+		"domain":          "preview.example.com",
+		"http":            "false",
+		"tls-secret-name": "preview-tls",
+		"tls-acme":        "true",
+		"allowed-hosts":   "*.example.com,example.com",
+	}, policy.Overrides)
+
+	policy, _, err = getPolicy(newPolicyObject(ExposePolicyKind, "preview", "public", map[string]interface{}{
+		"serviceSelector": map[string]interface{}{"matchLabels": map[string]interface{}{"public": "true"}},
+		"exposer":         "loadbalancer",
+	}))
+	require.NoError(t, err)
+	assert.Equal(t, "ExposePolicy/preview/public", policy.Name)
+	assert.Equal(t, "preview", policy.Namespace)
+	assert.Equal(t, "public=true", policy.ServiceSelector.String())
+
+	for _, spec := range []map[string]interface{}{
+		{"namespaceSelector": map[string]interface{}{}},
+		{"serviceSelector": map[string]interface{}{"matchExpressions": []interface{}{map[string]interface{}{"key": "a", "operator": "Maybe"}}}},
+		{"exposer": "router"},
+		{"pathMode": "subdomain"},
+		{"http": "yes"},
+	} {
+		_, _, err = getPolicy(newPolicyObject(ExposePolicyKind, "preview", "invalid", spec))
+		assert.Error(t, err, "%v", spec)
+	}
+}
+
+func TestNewPoliciesControllers(t *testing.T) {
+	dynamicClient := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(),
+		newPolicyObject(ExposeConfigKind, "", "default", map[string]interface{}{
+			"domain": "example.com",
+		}),
+		newPolicyObject(ExposePolicyKind, "main", "public", map[string]interface{}{
+			"exposer": "loadbalancer",
+		}),
+		newPolicyObject(ExposePolicyKind, "main", "invalid", map[string]interface{}{
+			"exposer": "router",
+		}),
+	)
+	overrides := exposestrategy.NewNamespaceOverrides()
+	var lock sync.Mutex
+	changed := map[string]int{}
+	controllers := newPoliciesControllers(dynamicClient, "main", overrides, time.Hour, func(namespace string) {
+		lock.Lock()
+		defer lock.Unlock()
+		changed[namespace]++
+	})
+	require.Len(t, controllers, 2)
+	stopChan := make(chan struct{})
+	defer close(stopChan)
+	for _, controller := range controllers {
+		go controller.Run(stopChan)
+	}
+	time.Sleep(500 * time.Millisecond)
+
+	lock.Lock()
+	assert.Equal(t, map[string]int{"": 1, "main": 1}, changed)
+	lock.Unlock()
+	settings, _ := overrides.Apply(&v1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "main", Name: "svc1"}}, exposestrategy.NamespaceSettings{})
+	assert.Equal(t, exposestrategy.NamespaceSettings{Domain: "example.com", Exposer: "loadbalancer"}, settings)
+
+	for name, expected := range map[string]string{"public": "True", "invalid": "False"} {
+		obj, err := dynamicClient.Resource(exposePolicyResource).Namespace("main").Get(name, metav1.GetOptions{})
+		require.NoError(t, err)
+		conditions, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+		if assert.Len(t, conditions, 1, name) {
+			condition := conditions[0].(map[string]interface{})
+			assert.Equal(t, ReadyCondition, condition["type"], name)
+			assert.Equal(t, expected, condition["status"], name)
+		}
+	}
+
+	err := dynamicClient.Resource(exposeConfigResource).Delete("default", &metav1.DeleteOptions{})
+	require.NoError(t, err)
+	time.Sleep(500 * time.Millisecond)
+	settings, _ = overrides.Apply(&v1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "main", Name: "svc1"}}, exposestrategy.NamespaceSettings{})
+	assert.Equal(t, exposestrategy.NamespaceSettings{Exposer: "loadbalancer"}, settings)
+	lock.Lock()
+	assert.Equal(t, 2, changed[""])
+	lock.Unlock()
+}
+
+func TestMergeExposeConfigs(t *testing.T) {
+	dynamicClient := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(),
+		newPolicyObject(ExposeConfigKind, "", "b-default", map[string]interface{}{
+			"domain": "example.com",
+			"tls":    map[string]interface{}{"acme": true},
+		}),
+		newPolicyObject(ExposeConfigKind, "", "a-default", map[string]interface{}{
+			"domain":  "other.example.com",
+			"exposer": "ingress",
+		}),
+		newPolicyObject(ExposeConfigKind, "", "previews", map[string]interface{}{
+			"namespaceSelector": map[string]interface{}{"matchLabels": map[string]interface{}{"env": "preview"}},
+			"domain":            "preview.example.com",
+		}),
+	)
+	config, err := Load("domain: config.example.com\nhttp: true\n")
+	require.NoError(t, err)
+	found, err := config.MergeExposeConfigs(dynamicClient)
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "example.com", config.Domain)
+	assert.Equal(t, "ingress", config.Exposer)
+	assert.True(t, config.TLSAcme)
+	assert.True(t, config.HTTP)
+	assert.Equal(t, "ExposeConfig/b-default", config.Source("domain"))
+	assert.Equal(t, "ExposeConfig/a-default", config.Source("exposer"))
+}
@@ -0,0 +1,101 @@
+package controller
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"k8s.io/klog"
+
+	"k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// secretUpdateOnChangeAnnotation annotation lists the Secrets whose changes roll out the deployment
+const secretUpdateOnChangeAnnotation = "secret.fabric8.io/update-on-change"
+
+// updateServiceSecret exports the info of the service to the Secret with the same name,
+// like updateServiceConfigMap
+func updateServiceSecret(c kubernetes.Interface, svc *v1.Service) {
+	name := svc.Name
+	ns := svc.Namespace
+	secret, err := c.CoreV1().Secrets(ns).Get(name, metav1.GetOptions{})
+	if err != nil {
+		if !apierrors.IsNotFound(err) {
+			klog.Warningf("Failed to get Secret %s/%s: %v", ns, name, err)
+		}
+		return
+	}
+	data := secretData(secret)
+	if updateServiceData(svc, "Secret", secret.Name, secret.Annotations, data) {
+		setSecretData(secret, data)
+		klog.Infof("Updating Secret %s/%s", ns, name)
+		_, err = c.CoreV1().Secrets(ns).Update(secret)
+		if err != nil {
+			klog.Errorf("Failed to update Secret %s error: %v", name, err)
+		}
+		err = rollingUpgradeDeploymentsOnChange(c, ns, name, convertSecretToToken(secret), secretUpdateOnChangeAnnotation, "SECRET")
+		if err != nil {
+			klog.Errorf("Failed to update Deployments after change to Secret %s error: %v", name, err)
+		}
+	}
+}
+
+// updateOtherSecrets updates the other Secrets which want to be injected by this svc exposeURL,
+// like updateOtherConfigMaps
+func updateOtherSecrets(c kubernetes.Interface, svc *v1.Service, config *Config, exposeURL string) error {
+	for _, ns := range exportNamespaces(svc, config) {
+		secrets, err := c.CoreV1().Secrets(ns).List(metav1.ListOptions{})
+		if err != nil {
+			if ns == svc.Namespace {
+				return err
+			}
+			klog.Warningf("Failed to list the Secrets to export service %s/%s to: %v", svc.Namespace, svc.Name, err)
+			continue
+		}
+		for _, secret := range secrets.Items {
+			data := secretData(&secret)
+			update := false
+			for _, serviceKey := range exportServiceKeys(svc, secret.Namespace) {
+				if updateOtherData(serviceKey, config, exposeURL, "Secret", secret.Namespace, secret.Name, secret.Annotations, data) {
+					update = true
+				}
+			}
+			if update {
+				setSecretData(&secret, data)
+				_, err = c.CoreV1().Secrets(secret.Namespace).Update(&secret)
+				if err != nil {
+					return fmt.Errorf("Failed to update Secret %s in namespace %s due to %v", secret.Name, secret.Namespace, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// secretData returns the decoded data of the Secret
+func secretData(secret *v1.Secret) map[string]string {
+	data := make(map[string]string, len(secret.Data))
+	for key, value := range secret.Data {
+		data[key] = string(value)
+	}
+	return data
+}
+
+// setSecretData sets the data of the Secret, to be encoded
+func setSecretData(secret *v1.Secret, data map[string]string) {
+	secret.Data = make(map[string][]byte, len(data))
+	for key, value := range data {
+		secret.Data[key] = []byte(value)
+	}
+}
+
+// convertSecretToToken converts the Secret into a unique token based on the data values
+// hashed, so that the values don't leak into the deployments
+func convertSecretToToken(secret *v1.Secret) string {
+	token := convertConfigMapToToken(&v1.ConfigMap{Data: secretData(secret)})
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,98 @@
+package controller
+
+import (
+	"testing"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/api/extensions/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/olli-ai/exposecontroller/exposestrategy"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpdateRelatedResources_secrets(t *testing.T) {
+	svc := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "main",
+			Name:      "my-service",
+			Annotations: map[string]string{
+				exposestrategy.ExposeAnnotationKey: "https://my-service.main.example.com/api",
+			},
+		},
+		Spec: v1.ServiceSpec{
+			ClusterIP: "10.0.0.1",
+			Ports:     []v1.ServicePort{{Port: 80, TargetPort: intstr.FromInt(8080)}},
+		},
+	}
+	client := fake.NewSimpleClientset(svc, &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "main",
+			Name:      "my-service",
+			Annotations: map[string]string{
+				ExposeConfigURLKeyAnnotation:           "url",
+				ExposeConfigClusterIPPortKeyAnnotation: "address",
+			},
+		},
+		Data: map[string][]byte{"password": []byte("secret")},
+	}, &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "main",
+			Name:      "oauth",
+			Annotations: map[string]string{
+				"expose-no-path.service-key.config.fabric8.io/my-service":  "callback,origin",
+				"expose-protocol.service-key.config.fabric8.io/my-service": "protocol",
+			},
+		},
+	}, &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "main",
+			Name:      "other",
+			Annotations: map[string]string{
+				"expose.service-key.config.fabric8.io/my-service": "url",
+			},
+		},
+	}, &v1beta1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "main",
+			Name:        "my-app",
+			Annotations: map[string]string{secretUpdateOnChangeAnnotation: "my-service"},
+		},
+		Spec: v1beta1.DeploymentSpec{Template: v1.PodTemplateSpec{Spec: v1.PodSpec{
+			Containers: []v1.Container{{Name: "app"}},
+		}}},
+	})
+	updateRelatedResources(client, svc, &Config{})
+
+	secret, err := client.CoreV1().Secrets("main").Get("my-service", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]byte{
+		"password": []byte("secret"),
+		"url":      []byte("https://my-service.main.example.com/api"),
+		"address":  []byte("10.0.0.1:8080"),
+	}, secret.Data)
+	secret, err = client.CoreV1().Secrets("main").Get("oauth", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]byte{
+		"callback": []byte("https://my-service.main.example.com/"),
+		"origin":   []byte("https://my-service.main.example.com/"),
+		"protocol": []byte("https"),
+	}, secret.Data)
+	cm, err := client.CoreV1().ConfigMaps("main").Get("other", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"url": "https://my-service.main.example.com/api"}, cm.Data)
+
+	// the deployment rolls out, without the values of the secret
+	deployment, err := client.ExtensionsV1beta1().Deployments("main").Get("my-app", metav1.GetOptions{})
+	require.NoError(t, err)
+	env := deployment.Spec.Template.Spec.Containers[0].Env
+	if assert.Len(t, env, 1) {
+		assert.Equal(t, "FABRIC8_MY_SERVICE_SECRET", env[0].Name)
+		assert.Len(t, env[0].Value, 64)
+		assert.NotContains(t, env[0].Value, "secret")
+	}
+}
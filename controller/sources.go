@@ -0,0 +1,249 @@
+package controller
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+// EnvPrefix is the prefix of the environment variables overriding the config
+const EnvPrefix = "EXPOSECONTROLLER_"
+
+// SourceDefault is the source of the fields not set by any source
+const SourceDefault = "default"
+
+// hiddenValue replaces the values of the secret fields when the config is printed
+const hiddenValue = "<hidden>"
+
+// configField is a field of Config, identified by its YAML key
+type configField struct {
+	key   string
+	index int
+	typ   reflect.Type
+}
+
+var configFields = getConfigFields()
+
+func getConfigFields() []configField {
+	fields := []configField{}
+	t := reflect.TypeOf(Config{})
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if field.PkgPath != "" || key == "" || key == "-" {
+			continue
+		}
+		fields = append(fields, configField{key, i, field.Type})
+	}
+	return fields
+}
+
+func getConfigField(key string) (configField, bool) {
+	for _, field := range configFields {
+		if field.key == key {
+			return field, true
+		}
+	}
+	return configField{}, false
+}
+
+// isSecretKey tells if the field of the config holds a secret, hidden when printed
+// The names of the Kubernetes secrets are not secret themselves
+func isSecretKey(key string) bool {
+	return strings.Contains(key, "secret") && !strings.HasSuffix(key, "-name")
+}
+
+// EnvName returns the environment variable overriding the field of the config
+func EnvName(key string) string {
+	return EnvPrefix + strings.ToUpper(strings.Replace(key, "-", "_", -1))
+}
+
+// Merge sets the fields present in the YAML text, keeping the other ones
+// The unknown keys are reported by CheckUnknownKeys
+func (c *Config) Merge(text, source string) error {
+	if strings.TrimSpace(text) == "" {
+		return nil
+	}
+	keys := map[string]interface{}{}
+	err := yaml.Unmarshal([]byte(text), &keys)
+	if err != nil {
+		return errors.Wrapf(err, "failed to unmarshal config from %s", source)
+	}
+	err = yaml.Unmarshal([]byte(text), c)
+	if err != nil {
+		return errors.Wrapf(err, "failed to unmarshal config from %s", source)
+	}
+	if err = yaml.UnmarshalStrict([]byte(text), &Config{}); err != nil {
+		c.unknownKeys = append(c.unknownKeys, errors.Wrapf(err, "invalid config keys in %s", source))
+	}
+	for key := range keys {
+		if _, ok := getConfigField(key); ok {
+			c.setSource(key, source)
+		}
+	}
+	c.original = ""
+	return nil
+}
+
+// Override sets a field of the config from a string value
+// The lists can be comma separated, the other values are YAML
+func (c *Config) Override(key, value, source string) error {
+	field, ok := getConfigField(key)
+	if !ok {
+		return errors.Errorf("unknown config key \"%s\"", key)
+	}
+	target := reflect.ValueOf(c).Elem().Field(field.index)
+	switch {
+	case field.typ.Kind() == reflect.String:
+		target.SetString(value)
+	case field.typ.Kind() == reflect.Slice && field.typ.Elem().Kind() == reflect.String &&
+		!strings.HasPrefix(strings.TrimSpace(value), "["):
+		values := []string{}
+		for _, v := range strings.Split(value, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+		target.Set(reflect.ValueOf(values))
+	case field.typ == reflect.TypeOf(time.Duration(0)):
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			return errors.Wrapf(err, "invalid %s from %s", key, source)
+		}
+		target.SetInt(int64(duration))
+	default:
+		parsed := reflect.New(field.typ)
+		err := yaml.Unmarshal([]byte(value), parsed.Interface())
+		if err != nil {
+			return errors.Wrapf(err, "invalid %s from %s", key, source)
+		}
+		target.Set(parsed.Elem())
+	}
+	c.setSource(key, source)
+	c.original = ""
+	return nil
+}
+
+// LoadEnv overrides the fields of the config with the EXPOSECONTROLLER_* environment variables
+func (c *Config) LoadEnv(lookupEnv func(string) (string, bool)) error {
+	errs := []error{}
+	for _, field := range configFields {
+		name := EnvName(field.key)
+		if value, ok := lookupEnv(name); ok {
+			if err := c.Override(field.key, value, "env "+name); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// Source returns the source which set the field of the config
+func (c *Config) Source(key string) string {
+	if source, ok := c.sources[key]; ok {
+		return source
+	}
+	return SourceDefault
+}
+
+func (c *Config) setSource(key, source string) {
+	if c.sources == nil {
+		c.sources = map[string]string{}
+	}
+	c.sources[key] = source
+}
+
+// PrintSources returns the effective value of each field of the config, with its source
+func (c *Config) PrintSources() string {
+	lines := make([]string, 0, len(configFields))
+	value := reflect.ValueOf(c).Elem()
+	for _, field := range configFields {
+		v := value.Field(field.index).Interface()
+		var text string
+		switch field.typ.Kind() {
+		case reflect.Slice, reflect.Map:
+			b, _ := json.Marshal(v)
+			text = string(b)
+		default:
+			text = fmt.Sprint(v)
+		}
+		if isSecretKey(field.key) && text != "" {
+			text = hiddenValue
+		}
+		lines = append(lines, fmt.Sprintf("%-25s %-40s %s", field.key, text, c.Source(field.key)))
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// Flags are the command line flags overriding the fields of the config
+type Flags struct {
+	flags  *flag.FlagSet
+	values map[string]*string
+}
+
+// boolFlag is a string flag which can be set without value, like a bool flag
+type boolFlag struct {
+	value *string
+}
+
+func (f boolFlag) String() string {
+	if f.value == nil {
+		return ""
+	}
+	return *f.value
+}
+
+func (f boolFlag) Set(value string) error {
+	*f.value = value
+	return nil
+}
+
+func (f boolFlag) IsBoolFlag() bool {
+	return true
+}
+
+// RegisterFlags defines a flag for each field of the config, unless already defined
+func RegisterFlags(flags *flag.FlagSet) *Flags {
+	f := &Flags{flags: flags, values: map[string]*string{}}
+	keys := make([]string, 0, len(configFields))
+	for _, field := range configFields {
+		keys = append(keys, field.key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if flags.Lookup(key) != nil {
+			continue
+		}
+		field, _ := getConfigField(key)
+		value := new(string)
+		usage := fmt.Sprintf("Overrides the %s config, like the %s environment variable", key, EnvName(key))
+		if field.typ.Kind() == reflect.Bool {
+			flags.Var(boolFlag{value}, key, usage)
+		} else {
+			flags.StringVar(value, key, "", usage)
+		}
+		f.values[key] = value
+	}
+	return f
+}
+
+// Apply overrides the fields of the config with the flags set on the command line
+func (f *Flags) Apply(c *Config) error {
+	errs := []error{}
+	f.flags.Visit(func(fl *flag.Flag) {
+		if value, ok := f.values[fl.Name]; ok {
+			if err := c.Override(fl.Name, *value, "flag --"+fl.Name); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	})
+	return utilerrors.NewAggregate(errs)
+}
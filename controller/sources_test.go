@@ -0,0 +1,73 @@
+package controller
+
+import (
+	"flag"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestConfigSources(t *testing.T) {
+	config, err := load("exposer: ingress\ndomain: example.com\nhttp: true\n", "file config.yml")
+	if err != nil {
+		t.Fatalf("Failed to load config %s\n", err)
+	}
+	err = config.Merge("domain: cluster.example.com\nloadbalancer-ips: [10.0.0.1]\ndomian: typo\n", "ConfigMap default/exposecontroller")
+	if err != nil {
+		t.Fatalf("Failed to merge config %s\n", err)
+	}
+	env := map[string]string{
+		"EXPOSECONTROLLER_HTTP":             "false",
+		"EXPOSECONTROLLER_SERVICES":         "my-app, other-app",
+		"EXPOSECONTROLLER_WEBHOOK_TIMEOUT":  "5s",
+		"EXPOSECONTROLLER_LOADBALANCER_IPS": "[10.0.0.2, 10.0.0.3]",
+	}
+	err = config.LoadEnv(func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	})
+	if err != nil {
+		t.Fatalf("Failed to load env %s\n", err)
+	}
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.String("exposer", "", "already defined")
+	configFlags := RegisterFlags(flags)
+	err = flags.Parse([]string{"--tls-acme", "--webhook-retries=3", "--internal-domain", "internal"})
+	if err != nil {
+		t.Fatalf("Failed to parse flags %s\n", err)
+	}
+	if err = configFlags.Apply(config); err != nil {
+		t.Fatalf("Failed to apply flags %s\n", err)
+	}
+
+	assertStringEquals(t, "ingress", config.Exposer, "Exposer")
+	assertStringEquals(t, "cluster.example.com", config.Domain, "Domain")
+	assertStringEquals(t, "internal", config.InternalDomain, "InternalDomain")
+	assertStringEquals(t, "[my-app other-app]", fmt.Sprint(config.Services), "Services")
+	assertStringEquals(t, "[10.0.0.2 10.0.0.3]", fmt.Sprint(config.LoadBalancerIPs), "LoadBalancerIPs")
+	if config.HTTP || !config.TLSAcme || config.WebhookTimeout != 5*time.Second || config.WebhookRetries != 3 {
+		t.Errorf("Unexpected overrides %#v\n", config)
+	}
+	sources := map[string]string{
+		"exposer":          "file config.yml",
+		"domain":           "ConfigMap default/exposecontroller",
+		"http":             "env EXPOSECONTROLLER_HTTP",
+		"loadbalancer-ips": "env EXPOSECONTROLLER_LOADBALANCER_IPS",
+		"tls-acme":         "flag --tls-acme",
+		"webhook-retries":  "flag --webhook-retries",
+		"node-ip":          SourceDefault,
+	}
+	for key, source := range sources {
+		assertStringEquals(t, source, config.Source(key), "Source of "+key)
+	}
+	if err := config.CheckUnknownKeys(); err == nil {
+		t.Error("Unknown key domian not reported\n")
+	}
+
+	if err := config.Override("webhook-retries", "three", "test"); err == nil {
+		t.Error("Invalid value not reported\n")
+	}
+	if err := config.Override("unknown", "value", "test"); err == nil {
+		t.Error("Unknown key not reported\n")
+	}
+}
@@ -0,0 +1,283 @@
+package controller
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"k8s.io/klog"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/olli-ai/exposecontroller/exposestrategy"
+)
+
+// strategySet holds the strategies instantiated by the controller
+// and routes each service to the strategies selected by its fabric8.io/exposer annotation
+// The first exposer of a service writes the fabric8.io/exposeURL annotation,
+// the other ones write fabric8.io/exposeURL.<exposer>
+type strategySet struct {
+	client        kubernetes.Interface
+	dynamicClient dynamic.Interface
+	namespace     string
+	config        *Config
+	// the exposer of the services without annotation
+	defaultExposer string
+	strategies     map[string]exposestrategy.ExposeStrategy
+	// the exposers used by each service, the first one is the main one
+	exposers map[string][]string
+	// the settings overridden by the namespace annotations, shared by the strategies
+	namespaces *exposestrategy.NamespaceOverrides
+	// the hosts and paths claimed by the services, shared by the strategies
+	claims   *exposestrategy.HostClaims
+	recorder record.EventRecorder
+	// exposes a service again, set by the controller once its store exists
+	refresh func(namespace, name string)
+	// closed when the controller stops, ending the watches of the strategies
+	stop chan struct{}
+}
+
+// exposerRole is an exposer used by a service, either as the main exposer or as an additional one
+type exposerRole struct {
+	exposer string
+	main    bool
+}
+
+func newStrategySet(client kubernetes.Interface, dynamicClient dynamic.Interface, namespace string, config *Config) (*strategySet, error) {
+	s := &strategySet{
+		client:        client,
+		dynamicClient: dynamicClient,
+		namespace:     namespace,
+		config:        config,
+		strategies:    map[string]exposestrategy.ExposeStrategy{},
+		exposers:      map[string][]string{},
+		namespaces:    exposestrategy.NewNamespaceOverrides(),
+		claims:        exposestrategy.NewHostClaims(),
+		recorder:      newEventRecorder(client),
+		stop:          make(chan struct{}),
+	}
+	exposer := strings.ToLower(config.Exposer)
+	if exposer == "" {
+		exposer = "auto"
+	}
+	_, resolved, err := s.create(exposer)
+	if err != nil {
+		return nil, err
+	}
+	s.defaultExposer = resolved
+	return s, nil
+}
+
+// refreshService asks the controller to expose the service again
+func (s *strategySet) refreshService(namespace, name string) {
+	if s.refresh != nil {
+		s.refresh(namespace, name)
+	}
+}
+
+// newEventRecorder creates a recorder sending the events to the cluster
+func newEventRecorder(client kubernetes.Interface) record.EventRecorder {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: client.CoreV1().Events("")})
+	return broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: "exposecontroller"})
+}
+
+// create instantiates a strategy
+// returns the name of the strategy actually chosen, in case of auto strategy
+func (s *strategySet) create(exposer string) (exposestrategy.ExposeStrategy, string, error) {
+	strategy, resolved, err := getStrategy(s, exposer)
+	if err != nil {
+		return nil, "", err
+	}
+	s.strategies[exposer] = strategy
+	s.strategies[resolved] = strategy
+	return strategy, resolved, nil
+}
+
+// get returns the strategy, instantiating and syncing it if needed
+func (s *strategySet) get(exposer string) (exposestrategy.ExposeStrategy, error) {
+	if strategy, ok := s.strategies[exposer]; ok {
+		return strategy, nil
+	}
+	strategy, _, err := s.create(exposer)
+	if err != nil {
+		return nil, err
+	}
+	err = strategy.Sync()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to sync %s expose strategy", exposer)
+	}
+	return strategy, nil
+}
+
+// serviceExposers returns the exposers selected by the service, the first one is the main one
+func (s *strategySet) serviceExposers(svc *v1.Service) []string {
+	// the namespace annotations and the policies can change the default exposer
+	defaultExposer := s.defaultExposer
+	settings, ok := s.namespaces.Apply(svc, exposestrategy.NamespaceSettings{Exposer: defaultExposer})
+	if ok && settings.Exposer != "" && settings.Exposer != "auto" {
+		defaultExposer = settings.Exposer
+	}
+	exposers := []string{}
+	found := map[string]bool{}
+	for _, exposer := range strings.Split(svc.Annotations[exposestrategy.ExposerAnnotationKey], ",") {
+		exposer = strings.ToLower(strings.TrimSpace(exposer))
+		if exposer == "" {
+			continue
+		} else if exposer == "auto" || exposer == "default" {
+			exposer = defaultExposer
+		}
+		if !found[exposer] {
+			found[exposer] = true
+			exposers = append(exposers, exposer)
+		}
+	}
+	if len(exposers) == 0 {
+		exposers = append(exposers, defaultExposer)
+	}
+	return exposers
+}
+
+// forService returns the copy of the service to give to a strategy
+func forService(svc *v1.Service, exposer string, main bool) *v1.Service {
+	if main {
+		return svc
+	}
+	return exposestrategy.WithExposeURLKey(svc, exposestrategy.ExposeURLAnnotationKeyFor(exposer))
+}
+
+// remember records the exposers of the previous version of the service, if unknown
+func (s *strategySet) remember(svc *v1.Service) {
+	svcKey := fmt.Sprintf("%s/%s", svc.Namespace, svc.Name)
+	if _, ok := s.exposers[svcKey]; !ok {
+		s.exposers[svcKey] = s.serviceExposers(svc)
+	}
+}
+
+// Sync syncs all the instantiated strategies
+func (s *strategySet) Sync() error {
+	synced := map[exposestrategy.ExposeStrategy]bool{}
+	for exposer, strategy := range s.strategies {
+		if synced[strategy] {
+			continue
+		}
+		synced[strategy] = true
+		err := strategy.Sync()
+		if err != nil {
+			return errors.Wrapf(err, "failed to sync %s expose strategy", exposer)
+		}
+	}
+	return nil
+}
+
+// HasSynced tells if all the instantiated strategies are complete
+func (s *strategySet) HasSynced() bool {
+	for _, strategy := range s.strategies {
+		if !strategy.HasSynced() {
+			return false
+		}
+	}
+	return true
+}
+
+// Add cleans the strategies the service doesn't use anymore
+// and adds the service to the strategies it selects
+// The main strategy is added last
+func (s *strategySet) Add(svc *v1.Service) error {
+	svcKey := fmt.Sprintf("%s/%s", svc.Namespace, svc.Name)
+	exposers := s.serviceExposers(svc)
+	keep := map[exposerRole]bool{}
+	for i, exposer := range exposers {
+		keep[exposerRole{exposer, i == 0}] = true
+	}
+	cleaned := false
+	for i, exposer := range s.exposers[svcKey] {
+		if keep[exposerRole{exposer, i == 0}] {
+			continue
+		}
+		strategy, err := s.get(exposer)
+		if err != nil {
+			klog.Errorf("failed to clean service %s from %s strategy: %v", svcKey, exposer, err)
+			continue
+		}
+		klog.Infof("cleaning service %s from %s strategy", svcKey, exposer)
+		err = strategy.Clean(forService(svc, exposer, i == 0))
+		if err != nil {
+			klog.Errorf("failed to clean service %s from %s strategy: %v", svcKey, exposer, err)
+		}
+		cleaned = true
+	}
+	s.exposers[svcKey] = exposers
+	if cleaned {
+		// the service was patched
+		latest, err := s.client.CoreV1().Services(svc.Namespace).Get(svc.Name, metav1.GetOptions{})
+		if err != nil {
+			return errors.Wrapf(err, "failed to get service %s", svcKey)
+		}
+		svc = latest
+	}
+
+	failed := []string{}
+	for i := len(exposers) - 1; i >= 0; i-- {
+		strategy, err := s.get(exposers[i])
+		if err == nil {
+			err = strategy.Add(forService(svc, exposers[i], i == 0))
+		}
+		if err != nil {
+			klog.Errorf("failed to add service %s to %s strategy: %v", svcKey, exposers[i], err)
+			failed = append(failed, exposers[i])
+		}
+	}
+	if len(failed) > 0 {
+		return errors.Errorf("failed to add service %s to strategies %s", svcKey, strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// Clean cleans the service from all the strategies it used or selects
+func (s *strategySet) Clean(svc *v1.Service) error {
+	return s.remove(svc, "clean", exposestrategy.ExposeStrategy.Clean)
+}
+
+// Delete deletes the service from all the strategies it used or selects
+func (s *strategySet) Delete(svc *v1.Service) error {
+	return s.remove(svc, "delete", exposestrategy.ExposeStrategy.Delete)
+}
+
+func (s *strategySet) remove(svc *v1.Service, action string, f func(exposestrategy.ExposeStrategy, *v1.Service) error) error {
+	svcKey := fmt.Sprintf("%s/%s", svc.Namespace, svc.Name)
+	roles := []exposerRole{}
+	done := map[exposerRole]bool{}
+	for _, exposers := range [][]string{s.exposers[svcKey], s.serviceExposers(svc)} {
+		for i, exposer := range exposers {
+			r := exposerRole{exposer, i == 0}
+			if !done[r] {
+				done[r] = true
+				roles = append(roles, r)
+			}
+		}
+	}
+	delete(s.exposers, svcKey)
+
+	failed := []string{}
+	for _, r := range roles {
+		strategy, err := s.get(r.exposer)
+		if err == nil {
+			err = f(strategy, forService(svc, r.exposer, r.main))
+		}
+		if err != nil {
+			klog.Errorf("failed to %s service %s from %s strategy: %v", action, svcKey, r.exposer, err)
+			failed = append(failed, r.exposer)
+		}
+	}
+	if len(failed) > 0 {
+		return errors.Errorf("failed to %s service %s from strategies %s", action, svcKey, strings.Join(failed, ", "))
+	}
+	return nil
+}
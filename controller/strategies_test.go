@@ -0,0 +1,113 @@
+package controller
+
+import (
+	"testing"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/olli-ai/exposecontroller/exposestrategy"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingStrategy struct {
+	name    string
+	actions *[]string
+}
+
+func (s *recordingStrategy) record(action string, svc *v1.Service) error {
+	*s.actions = append(*s.actions, action+":"+s.name+":"+svc.Annotations["fabric8.io/exposeURL.key"])
+	return nil
+}
+
+func (s *recordingStrategy) Sync() error {
+	*s.actions = append(*s.actions, "Sync:"+s.name)
+	return nil
+}
+
+func (s *recordingStrategy) HasSynced() bool {
+	return true
+}
+
+func (s *recordingStrategy) Add(svc *v1.Service) error {
+	return s.record("Add", svc)
+}
+
+func (s *recordingStrategy) Clean(svc *v1.Service) error {
+	return s.record("Clean", svc)
+}
+
+func (s *recordingStrategy) Delete(svc *v1.Service) error {
+	return s.record("Delete", svc)
+}
+
+func TestStrategySet(t *testing.T) {
+	svc := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "my-app",
+			Namespace: "main",
+		},
+	}
+	client := fake.NewSimpleClientset(svc)
+	actions := []string{}
+	s := &strategySet{
+		client:         client,
+		config:         &Config{},
+		defaultExposer: "ingress",
+		strategies:     map[string]exposestrategy.ExposeStrategy{},
+		exposers:       map[string][]string{},
+	}
+	for _, name := range []string{"ingress", "loadbalancer", "nodeport"} {
+		s.strategies[name] = &recordingStrategy{name: name, actions: &actions}
+	}
+
+	// no annotation, default exposer
+	err := s.Add(svc)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Add:ingress:"}, actions)
+
+	// several exposers, the main one is added last
+	actions = actions[:0]
+	svc.Annotations = map[string]string{"fabric8.io/exposer": "auto, LoadBalancer,ingress"}
+	err = s.Add(svc)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"Add:loadbalancer:fabric8.io/exposeURL.loadbalancer",
+		"Add:ingress:",
+	}, actions)
+
+	// the main exposer changes
+	actions = actions[:0]
+	svc.Annotations["fabric8.io/exposer"] = "loadbalancer,nodeport"
+	err = s.Add(svc)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{
+		"Clean:ingress:",
+		"Clean:loadbalancer:fabric8.io/exposeURL.loadbalancer",
+	}, actions[:2])
+	assert.Equal(t, []string{
+		"Add:nodeport:fabric8.io/exposeURL.nodeport",
+		"Add:loadbalancer:",
+	}, actions[2:])
+
+	// the exposers used before are also cleaned
+	actions = actions[:0]
+	svc.Annotations["fabric8.io/exposer"] = "nodeport"
+	err = s.Clean(svc)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"Clean:loadbalancer:",
+		"Clean:nodeport:fabric8.io/exposeURL.nodeport",
+		"Clean:nodeport:",
+	}, actions)
+
+	// unknown exposers are created on demand
+	actions = actions[:0]
+	svc.Annotations["fabric8.io/exposer"] = "unknown"
+	err = s.Add(svc)
+	assert.Error(t, err)
+	assert.Empty(t, actions)
+}
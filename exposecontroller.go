@@ -12,10 +12,14 @@ import (
 
 	"github.com/olli-ai/exposecontroller/controller"
 	"github.com/olli-ai/exposecontroller/exposestrategy"
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
 	"k8s.io/klog"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
@@ -42,20 +46,22 @@ var (
 
 	profiling = flag.Bool("profiling", true, `Enable profiling via web interface host:port/debug/pprof/`)
 
-	daemon  = flag.Bool("daemon", false, `Run as daemon mode watching changes as it happens.`)
-	cleanup = flag.Bool("cleanup", false, `Removes Ingress rules that were generated by exposecontroller`)
+	daemon         = flag.Bool("daemon", false, `Run as daemon mode watching changes as it happens.`)
+	cleanup        = flag.Bool("cleanup", false, `Removes Ingress rules that were generated by exposecontroller`)
+	validateConfig = flag.Bool("validate-config", false, `Prints the effective configuration and its errors, exits with a non-zero status if invalid`)
+	printConfig    = flag.Bool("print-config", false, `Prints the effective value of each configuration field and its source, then exits`)
 
-	domain                = flag.String("domain", "", "Domain to use with your DNS provider (default: .nip.io).")
 	filter                = flag.String("filter", "", "The filter of service names to look for when cleaning up")
-	exposer               = flag.String("exposer", "", "Which strategy exposecontroller should use to access applications")
-	httpb                 = flag.Bool("http", false, `Use HTTP`)
 	watchNamespaces       = flag.String("watch-namespaces", "", "Exposecontroller will only look at the provided namespace")
 	watchCurrentNamespace = flag.Bool("watch-current-namespace", true, `Exposecontroller will look at the current namespace only - (default: 'true' unless --watch-namespace specified)`)
-	services              = flag.String("services", "", "List of comma separated service names which will be exposed, if empty all services from namespace will be considered")
+
+	// configFlags override the other fields of the config
+	configFlags *controller.Flags
 )
 
 func init() {
 	klog.InitFlags(nil)
+	configFlags = controller.RegisterFlags(flag.CommandLine)
 }
 
 func main() {
@@ -70,12 +76,16 @@ func main() {
 		klog.Infof("using configuration from '%s'", *KubeConfig)
 		restClientConfig, err = clientcmd.BuildConfigFromFlags("", *KubeConfig)
 	}
-	if err != nil {
+	if err != nil && (*validateConfig || *printConfig) {
+		// the config can still be validated without the ConfigMaps
+		klog.Warningf("failed to create REST client config: %s", err)
+		os.Exit(validate(nil, nil, ""))
+	} else if err != nil {
 		klog.Fatalf("failed to create REST client config: %s", err)
 	}
 
 	kubeClient, err := kubernetes.NewForConfig(restClientConfig)
-	for i := 0; i < 30; i++ {
+	for i := 0; i < 30 && !*validateConfig && !*printConfig; i++ {
 		if err != nil {
 			klog.Warningf("failed to create client, retrying: %s", err)
 			time.Sleep(1 * time.Second)
@@ -84,81 +94,41 @@ func main() {
 			break
 		}
 	}
-	if err != nil {
-		klog.Fatalf("failed to create client: %s", err)
-	}
 	currentNamespace := os.Getenv("KUBERNETES_NAMESPACE")
 	if len(currentNamespace) == 0 {
 		currentNamespace = metav1.NamespaceDefault
 	}
-
-	controllerConfig, exists, err := controller.LoadFile(*configFile)
-	if !exists || err != nil {
+	if *validateConfig || *printConfig {
 		if err != nil {
-			klog.Warningf("failed to load config file: %s", err)
-		}
-
-		cc2 := tryFindConfig(kubeClient, currentNamespace)
-		if cc2 == nil {
-			// lets try find the ConfigMap in the dev namespace
-			resource, err := kubeClient.CoreV1().Namespaces().Get(currentNamespace, metav1.GetOptions{})
-			if err == nil && resource != nil {
-				labels := resource.Labels
-				if labels != nil {
-					ns := labels["team"]
-					if ns == "" {
-						klog.Warningf("No 'team' label on Namespace %s", currentNamespace)
-					} else {
-						klog.Infof("trying to find the ConfigMap in the Dev Namespace %s", ns)
-
-						cc2 = tryFindConfig(kubeClient, ns)
-					}
-				} else {
-					klog.Warningf("No labels on Namespace %s", currentNamespace)
-				}
-			} else {
-				klog.Warningf("Failed to load Namespace %s: %s", currentNamespace, err)
-
-				// lets try default to trimming the lasts path from the current namespace
-				idx := strings.LastIndex(currentNamespace, "-")
-				if idx > 1 {
-					ns := currentNamespace[0:idx]
-					cc2 = tryFindConfig(kubeClient, ns)
-				}
-			}
+			klog.Warningf("failed to create client: %s", err)
+			os.Exit(validate(nil, nil, currentNamespace))
 		}
-		if cc2 != nil {
-			controllerConfig = cc2
+		dynamicClient, err := dynamic.NewForConfig(restClientConfig)
+		if err != nil {
+			klog.Warningf("failed to create dynamic client: %s", err)
+			dynamicClient = nil
 		}
-	} else {
-		klog.Infof("Loaded config file %s", *configFile)
+		os.Exit(validate(kubeClient, dynamicClient, currentNamespace))
 	}
-	klog.Infof("Config file before overrides\n%s", controllerConfig.String())
-
-	if *domain != "" {
-		controllerConfig.Domain = *domain
-	}
-	if *exposer != "" {
-		controllerConfig.Exposer = *exposer
+	if err != nil {
+		klog.Fatalf("failed to create client: %s", err)
 	}
-	if *httpb {
-		controllerConfig.HTTP = *httpb
+	dynamicClient, err := dynamic.NewForConfig(restClientConfig)
+	if err != nil {
+		klog.Fatalf("failed to create dynamic client: %s", err)
 	}
 
-	if *watchCurrentNamespace {
-		controllerConfig.WatchCurrentNamespace = *watchCurrentNamespace
+	controllerConfig, err := loadConfig(kubeClient, dynamicClient, currentNamespace)
+	if err != nil {
+		klog.Fatalf("%s", err)
 	}
-	if *watchNamespaces != "" {
-		controllerConfig.WatchNamespaces = *watchNamespaces
-		controllerConfig.WatchCurrentNamespace = false
+	if err := controllerConfig.CheckUnknownKeys(); err != nil {
+		klog.Warningf("%s", err)
 	}
-
-	if *services != "" {
-		controllerConfig.Services = strings.Split(*services, ",")
+	if err := controllerConfig.Validate(); err != nil {
+		klog.Fatalf("invalid config: %s", err)
 	}
 
-	klog.Infof("Config file after overrides\n%s", controllerConfig.String())
-
 	//watchNamespaces := metav1.NamespaceAll
 	watchNamespaces := controllerConfig.WatchNamespaces
 	if controllerConfig.WatchCurrentNamespace {
@@ -178,14 +148,14 @@ func main() {
 
 	if *daemon {
 		klog.Infof("Watching services in namespaces: `%s`", watchNamespaces)
-		contr, err := controller.Daemon(kubeClient, watchNamespaces, controllerConfig, *resyncPeriod)
+		contr, err := controller.Daemon(kubeClient, dynamicClient, watchNamespaces, controllerConfig, *resyncPeriod)
 		if err == nil {
 			go registerHandlers(contr)
 			contr.Run(wait.NeverStop)
 		}
 	} else {
 		klog.Infof("Running in : `%s`", watchNamespaces)
-		err = controller.Run(kubeClient, watchNamespaces, controllerConfig, *timeout)
+		err = controller.Run(kubeClient, dynamicClient, watchNamespaces, controllerConfig, *timeout)
 	}
 
 	if err != nil {
@@ -193,19 +163,143 @@ func main() {
 	}
 }
 
-func tryFindConfig(kubeClient kubernetes.Interface, ns string) *controller.Config {
-	var controllerConfig *controller.Config
+// loadConfig loads the config from the file, the ExposeConfig resources or else the ConfigMaps,
+// the environment and the flags, each one overriding the previous ones
+// the clients may be nil, to skip the resources and the ConfigMaps
+func loadConfig(kubeClient kubernetes.Interface, dynamicClient dynamic.Interface, currentNamespace string) (*controller.Config, error) {
+	controllerConfig, exists, fileErr := controller.LoadFile(*configFile)
+	if fileErr != nil {
+		klog.Warningf("failed to load config file: %s", fileErr)
+		controllerConfig, _ = controller.Load("")
+	} else if exists {
+		klog.Infof("Loaded config file %s", *configFile)
+	}
+	found := false
+	var err error
+	if dynamicClient != nil {
+		found, err = controllerConfig.MergeExposeConfigs(dynamicClient)
+		if err != nil {
+			klog.Warningf("%s", err)
+		}
+	}
+	if found {
+		klog.Infof("Loaded the ExposeConfig resources, ignoring the ConfigMaps")
+	} else if kubeClient != nil {
+		text, source := findConfig(kubeClient, currentNamespace)
+		if text != "" {
+			err = controllerConfig.Merge(text, source)
+			if err != nil {
+				klog.Warningf("%s", err)
+			}
+		}
+	} else if fileErr != nil {
+		return nil, errors.Wrap(fileErr, "no config found")
+	}
+	klog.Infof("Config file before overrides\n%s", controllerConfig.String())
+
+	err = controllerConfig.LoadEnv(os.LookupEnv)
+	if err != nil {
+		return nil, err
+	}
+	err = configFlags.Apply(controllerConfig)
+	if err != nil {
+		return nil, err
+	}
+	flag.Visit(func(f *flag.Flag) {
+		switch {
+		case f.Name == "watch-current-namespace":
+			controllerConfig.Override(f.Name, f.Value.String(), "flag --"+f.Name)
+		case f.Name == "watch-namespaces" && *watchNamespaces != "":
+			controllerConfig.Override(f.Name, *watchNamespaces, "flag --"+f.Name)
+			controllerConfig.Override("watch-current-namespace", "false", "flag --"+f.Name)
+		}
+	})
+	if controllerConfig.Source("watch-current-namespace") == controller.SourceDefault {
+		// only watch the current namespace by default if no namespace is given
+		controllerConfig.WatchCurrentNamespace = controllerConfig.WatchNamespaces == ""
+	}
+
+	klog.Infof("Config file after overrides\n%s", controllerConfig.String())
+	return controllerConfig, nil
+}
+
+// findConfig looks for the ConfigMap in the current namespace, then in the dev namespace
+// returns the config text and its source, empty if not found
+func findConfig(kubeClient kubernetes.Interface, currentNamespace string) (string, string) {
+	text, source := tryFindConfig(kubeClient, currentNamespace)
+	if text != "" {
+		return text, source
+	}
+	// lets try find the ConfigMap in the dev namespace
+	resource, err := kubeClient.CoreV1().Namespaces().Get(currentNamespace, metav1.GetOptions{})
+	if err == nil && resource != nil {
+		labels := resource.Labels
+		if labels != nil {
+			ns := labels["team"]
+			if ns == "" {
+				klog.Warningf("No 'team' label on Namespace %s", currentNamespace)
+			} else {
+				klog.Infof("trying to find the ConfigMap in the Dev Namespace %s", ns)
+
+				return tryFindConfig(kubeClient, ns)
+			}
+		} else {
+			klog.Warningf("No labels on Namespace %s", currentNamespace)
+		}
+	} else {
+		klog.Warningf("Failed to load Namespace %s: %s", currentNamespace, err)
+
+		// lets try default to trimming the lasts path from the current namespace
+		idx := strings.LastIndex(currentNamespace, "-")
+		if idx > 1 {
+			ns := currentNamespace[0:idx]
+			return tryFindConfig(kubeClient, ns)
+		}
+	}
+	return "", ""
+}
+
+// validate loads the config like main does, prints it, or its sources, with its errors
+// returns the exit status
+func validate(kubeClient kubernetes.Interface, dynamicClient dynamic.Interface, currentNamespace string) int {
+	controllerConfig, err := loadConfig(kubeClient, dynamicClient, currentNamespace)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		return 1
+	}
+	if *printConfig {
+		fmt.Print(controllerConfig.PrintSources())
+	} else {
+		fmt.Print(controllerConfig.Effective())
+	}
+	status := 0
+	for _, check := range []func() error{controllerConfig.CheckUnknownKeys, controllerConfig.Validate} {
+		err := check()
+		if agg, ok := err.(utilerrors.Aggregate); ok {
+			for _, err := range agg.Errors() {
+				fmt.Fprintf(os.Stderr, "error: %s\n", err)
+			}
+			status = 1
+		} else if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s\n", err)
+			status = 1
+		}
+	}
+	if status == 0 {
+		fmt.Fprintln(os.Stderr, "config is valid")
+	}
+	return status
+}
+
+func tryFindConfig(kubeClient kubernetes.Interface, ns string) (string, string) {
 	cm, err := kubeClient.CoreV1().ConfigMaps(ns).Get("exposecontroller", metav1.GetOptions{})
 	if err == nil {
 		klog.Infof("Using ConfigMap exposecontroller to load configuration...")
 		// TODO we could allow the config to be passed in via key/value pairs?
 		text := cm.Data["config.yml"]
 		if text != "" {
-			controllerConfig, err = controller.Load(text)
-			if err != nil {
-				klog.Warningf("Could not parse the config text from exposecontroller ConfigMap  %v", err)
-			}
 			klog.Infof("Loaded ConfigMap exposecontroller to load configuration!")
+			return text, fmt.Sprintf("ConfigMap %s/exposecontroller", ns)
 		}
 	} else {
 		klog.Warningf("Could not find ConfigMap exposecontroller ConfigMap in namespace %s: %s", ns, err)
@@ -213,18 +307,16 @@ func tryFindConfig(kubeClient kubernetes.Interface, ns string) *controller.Confi
 		cm, err = kubeClient.CoreV1().ConfigMaps(ns).Get("ingress-config", metav1.GetOptions{})
 		if err != nil {
 			klog.Warningf("Could not find ConfigMap ingress-config ConfigMap in namespace %s: %s", ns, err)
-		} else {
+		} else if len(cm.Data) > 0 {
 			klog.Infof("Loaded ConfigMap ingress-config to load configuration!")
-			data := cm.Data
-			if data != nil {
-				controllerConfig, err = controller.MapToConfig(data)
-				if err != nil {
-					klog.Warningf("Failed to convert Map data %#v from configMap ingress-config in namespace %s due to: %s\n", controllerConfig, ns, err)
-				}
+			b, err := yaml.Marshal(cm.Data)
+			if err == nil {
+				return string(b), fmt.Sprintf("ConfigMap %s/ingress-config", ns)
 			}
+			klog.Warningf("Failed to convert Map data from configMap ingress-config in namespace %s due to: %s\n", ns, err)
 		}
 	}
-	return controllerConfig
+	return "", ""
 }
 
 func registerHandlers(controller cache.Controller) {
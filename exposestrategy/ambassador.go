@@ -11,23 +11,55 @@ import (
 	"k8s.io/klog"
 
 	"k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
 )
 
-// const (
-// 	PathModeUsePath = "path"
-// )
+const (
+	// AmbassadorModeAnnotation mode writes the deprecated getambassador.io/config service annotation
+	AmbassadorModeAnnotation = "annotation"
+	// AmbassadorModeCRD mode creates Mapping and Host resources owned by the service
+	AmbassadorModeCRD = "crd"
+	// AmbassadorAPIVersionV2 is the default API version of the Mapping and Host resources
+	AmbassadorAPIVersionV2 = "getambassador.io/v2"
+	// AmbassadorAPIVersionV3Alpha1 is the API version of the Mapping and Host resources for Emissary 2.x and later
+	AmbassadorAPIVersionV3Alpha1 = "getambassador.io/v3alpha1"
+)
 
 // AmbassadorStrategy is a strategy that adds the ambassador annotations
+// or creates ambassador resources
 type AmbassadorStrategy struct {
 	client  kubernetes.Interface
+	dynamic dynamic.Interface
 
+	namespace     string
 	domain        string
 	tlsSecretName string
 	http          bool
 	tlsAcme       bool
-	urltemplate   string
+	urltemplate   *urlTemplate
+	clusterName   string
 	pathMode      string
+	pathTemplate  *urlTemplate
+	mode          string
+	apiVersion    string
+	// the resources created for each service, in crd mode
+	existing     map[string][]ambassadorResource
+	allowedHosts []string
+	domains      *domainProfiles
+	namespaces   *NamespaceOverrides
+	claims       *HostClaims
+	recorder     record.EventRecorder
+}
+
+type ambassadorResource struct {
+	Kind string
+	Name string
 }
 
 // NewAmbassadorStrategy creates a new AmbassadorStrategy
@@ -35,34 +67,157 @@ func NewAmbassadorStrategy(client kubernetes.Interface, config *Config) (ExposeS
 
 	var err error
 	if config.Domain == "" {
-		config.Domain, err = getAutoDefaultDomain(client)
+		config.Domain, err = getAutoDefaultDomain(client, config)
 		if err != nil {
 			return nil, errors.Wrap(err, "failed to get a domain")
 		}
 	}
 	klog.Infof("Using domain: %s", config.Domain)
 
-	var urlformat string
-	urlformat, err = getURLFormat(config.URLTemplate)
+	urltemplate, err := parseURLTemplate(config.URLTemplate)
+	if err != nil {
+		return nil, err
+	}
+	pathtemplate, err := parsePathTemplate(config.PathTemplate)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to get a url format")
+		return nil, err
+	}
+	klog.Infof("Using url template [%s]", urltemplate.source())
+
+	mode, apiVersion, err := getAmbassadorMode(config)
+	if err != nil {
+		return nil, err
+	}
+	domains, err := getDomainProfiles(config)
+	if err != nil {
+		return nil, err
+	}
+	if mode == AmbassadorModeCRD {
+		if config.DynamicClient == nil {
+			return nil, errors.New("ambassador crd mode requires a dynamic client")
+		}
+		klog.Infof("Using ambassador %s resources", apiVersion)
 	}
-	klog.Infof("Using url template [%s] format [%s]", config.URLTemplate, urlformat)
 
 	return &AmbassadorStrategy{
 		client:        client,
+		dynamic:       config.DynamicClient,
+		namespace:     config.Namespace,
 		domain:        config.Domain,
 		http:          config.HTTP,
 		tlsAcme:       config.TLSAcme,
 		tlsSecretName: config.TLSSecretName,
-		urltemplate:   urlformat,
+		urltemplate:   urltemplate,
+		clusterName:   config.ClusterName,
 		pathMode:      config.PathMode,
+		pathTemplate:  pathtemplate,
+		mode:          mode,
+		apiVersion:    apiVersion,
+		existing:      map[string][]ambassadorResource{},
+		allowedHosts:  config.AllowedHosts,
+		domains:       domains,
+		namespaces:    config.Namespaces,
+		claims:        config.Claims,
+		recorder:      config.Recorder,
 	}, nil
 }
 
+// forService returns the strategy with the settings overridden for the service
+func (s *AmbassadorStrategy) forService(svc *v1.Service) *AmbassadorStrategy {
+	settings, ok := s.namespaces.Apply(svc, NamespaceSettings{
+		Domain:        s.domain,
+		TLSSecretName: s.tlsSecretName,
+		TLSAcme:       s.tlsAcme,
+		HTTP:          s.http,
+		URLTemplate:   s.urltemplate.source(),
+		PathMode:      s.pathMode,
+		AllowedHosts:  s.allowedHosts,
+	})
+	if !ok {
+		return s
+	}
+	clone := *s
+	clone.domain = settings.Domain
+	clone.tlsSecretName = settings.TLSSecretName
+	clone.tlsAcme = settings.TLSAcme
+	clone.http = settings.HTTP
+	clone.urltemplate = s.urltemplate.reparse(settings.URLTemplate)
+	clone.pathMode = settings.PathMode
+	clone.allowedHosts = settings.AllowedHosts
+	return &clone
+}
+
+// getAmbassadorMode returns the mode and the API version of the resources, with their defaults
+func getAmbassadorMode(config *Config) (string, string, error) {
+	mode := strings.ToLower(config.AmbassadorMode)
+	if mode == "" {
+		mode = AmbassadorModeAnnotation
+	}
+	apiVersion := config.AmbassadorAPIVersion
+	if apiVersion == "" {
+		apiVersion = AmbassadorAPIVersionV2
+	} else if apiVersion != AmbassadorAPIVersionV2 && apiVersion != AmbassadorAPIVersionV3Alpha1 {
+		return "", "", errors.Errorf("unknown ambassador api version \"%s\", must be one of \"%s\", \"%s\"",
+			apiVersion, AmbassadorAPIVersionV2, AmbassadorAPIVersionV3Alpha1)
+	}
+	if mode != AmbassadorModeAnnotation && mode != AmbassadorModeCRD {
+		return "", "", errors.Errorf("unknown ambassador mode \"%s\", must be one of \"%s\", \"%s\"",
+			mode, AmbassadorModeAnnotation, AmbassadorModeCRD)
+	}
+	return mode, apiVersion, nil
+}
+
+// forDomain returns the strategy with the settings of the named domain of the service, if any
+func (s *AmbassadorStrategy) forDomain(svc *v1.Service) (*AmbassadorStrategy, error) {
+	profile, err := s.domains.choose(svc, s.namespaces.Labels(svc.Namespace))
+	if err != nil || profile == nil {
+		return s, err
+	}
+	clone := *s
+	clone.domain = profile.Domain
+	if profile.TLSSecretName != "" {
+		clone.tlsSecretName = profile.TLSSecretName
+	}
+	if profile.urlTemplate != nil {
+		clone.urltemplate = profile.urlTemplate
+	}
+	return &clone, nil
+}
+
 // Sync is called before starting / resyncing
-// Nothing to do
+// In crd mode, gets the current list of all ambassador resources created by the controller
+// and deletes the ones without a valid owner
 func (s *AmbassadorStrategy) Sync() error {
+	if s.mode != AmbassadorModeCRD {
+		return nil
+	}
+	selector, err := metav1.LabelSelectorAsSelector(&metav1.LabelSelector{
+		MatchLabels: map[string]string{"provider": "fabric8"},
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to build selector")
+	}
+	listOptions := metav1.ListOptions{
+		LabelSelector: selector.String(),
+	}
+	existing := map[string][]ambassadorResource{}
+	for _, kind := range []string{"Mapping", "Host"} {
+		list, err := s.resources(kind, s.namespace).List(listOptions)
+		if err != nil {
+			return errors.Wrapf(err, "failed to list ambassador %ss", kind)
+		}
+		// check which service is referencing each resource
+		for index := range list.Items {
+			object := &list.Items[index]
+			svc, del := getOwnerService(object)
+			if del {
+				deleteResource(s.resources(kind, object.GetNamespace()), object)
+			} else if svc != "" {
+				existing[svc] = append(existing[svc], ambassadorResource{kind, object.GetName()})
+			}
+		}
+	}
+	s.existing = existing
 	return nil
 }
 
@@ -75,34 +230,26 @@ func (s *AmbassadorStrategy) HasSynced() bool {
 // Add is called when an exposed service is created or updated
 // Sets the ambassador annotations and various annotations
 func (s *AmbassadorStrategy) Add(svc *v1.Service) error {
-	appName := svc.Annotations["fabric8.io/ingress.name"]
-	if appName == "" {
-		if svc.Labels["release"] != "" {
-			appName = strings.Replace(svc.Name, svc.Labels["release"]+"-", "", 1)
-		} else {
-			appName = svc.Name
-		}
+	s, err := s.forService(svc).forDomain(svc)
+	if err != nil {
+		return err
 	}
-
-	hostName := svc.Annotations["fabric8.io/host.name"]
-	if hostName == "" {
-		hostName = appName
+	appName := getAppName(svc)
+	hostName, path, err := getHostAndPath(svc, appName, s.urltemplate, s.pathTemplate, s.domain, s.clusterName, s.pathMode)
+	if err != nil {
+		return err
 	}
-
-	hostName = fmt.Sprintf(s.urltemplate, hostName, svc.Namespace, s.domain)
-	path := svc.Annotations["fabric8.io/ingress.path"]
-	pathMode := svc.Annotations["fabric8.io/path.mode"]
-	if pathMode == "" {
-		pathMode = s.pathMode
+	if path == "" {
+		path = "/"
+	}
+	if err := checkAllowedHost(hostName, s.allowedHosts); err != nil {
+		return errors.Wrapf(err, "failed to expose service %s/%s", svc.Namespace, svc.Name)
 	}
-	if pathMode == PathModeUsePath {
-		if path == "" {
-			path = "/"
+	if err := s.claims.Claim(svc, "ambassador", hostName, path); err != nil {
+		if s.mode == AmbassadorModeCRD {
+			s.cleanResources(svc, nil)
 		}
-		path = URLJoin("/", svc.Namespace, appName, path)
-		hostName = s.domain
-	} else if path == "" || path[0] != '/' {
-		path = "/" + path
+		return reportHostConflict(s.client, s.recorder, svc, err, "getambassador.io/config")
 	}
 
 	exposePort := svc.Annotations[ExposePortAnnotationKey]
@@ -149,6 +296,7 @@ func (s *AmbassadorStrategy) Add(svc *v1.Service) error {
 	klog.Infof("Exposing Port %d of Service %s", servicePort, svc.Name)
 
 	clone := svc.DeepCopy()
+	clearHostConflict(clone)
 	if !s.http && tlsSecretName != "" {
 		err = addServiceAnnotationWithProtocol(clone, hostName, path, "https")
 	} else {
@@ -158,44 +306,19 @@ func (s *AmbassadorStrategy) Add(svc *v1.Service) error {
 		return errors.Wrapf(err, "failed to add annotation to service %s/%s",
 			svc.Namespace, svc.Name)
 	}
-	// Here's where we start adding the annotations to our service
-	ambassadorAnnotations := map[string]interface{}{
-		"apiVersion": "ambassador/v1",
-		"kind":       "Mapping",
-		"host":       hostName,
-		"name":       fmt.Sprintf("%s_%s_mapping", hostName, svc.Namespace),
-		"service":    fmt.Sprintf("%s.%s:%s", svc.Name, svc.Namespace, strconv.Itoa(servicePort)),
-		"prefix":     path,
-	}
-
-	joinedAnnotations := new(bytes.Buffer)
-	fmt.Fprintf(joinedAnnotations, "---\n")
-	yamlAnnotation, err := yaml.Marshal(&ambassadorAnnotations)
-	if err != nil {
-		return err
-	}
-	fmt.Fprintf(joinedAnnotations, "%s", string(yamlAnnotation))
-
-	if tlsSecretName != "" {
-		// we need to prepare the tls module config
-		ambassadorAnnotations = map[string]interface{}{
-			"apiVersion": "ambassador/v1",
-			"kind":       "Module",
-			"name":       "tls",
-			"config": map[string]interface{}{
-				"server": map[string]interface{}{
-					"enabled": "True",
-					"secret":  tlsSecretName}}}
-
-		yamlAnnotation, err = yaml.Marshal(&ambassadorAnnotations)
+	if s.mode == AmbassadorModeCRD {
+		err = s.applyResources(svc, appName, hostName, path, servicePort, tlsSecretName)
+		if err != nil {
+			return err
+		}
+		// the annotation isn't used anymore
+		delete(clone.Annotations, "getambassador.io/config")
+	} else {
+		err = s.addConfigAnnotation(svc, clone, hostName, path, servicePort, tlsSecretName)
 		if err != nil {
 			return err
 		}
-
-		fmt.Fprintf(joinedAnnotations, "---\n")
-		fmt.Fprintf(joinedAnnotations, "%s", string(yamlAnnotation))
 	}
-	clone.Annotations["getambassador.io/config"] = joinedAnnotations.String()
 
 	patch, err := createServicePatch(svc, clone)
 	if err != nil {
@@ -216,13 +339,18 @@ func (s *AmbassadorStrategy) Add(svc *v1.Service) error {
 }
 
 // Clean is called when an exposed service is unexposed
+// Deletes the related ambassador resources in crd mode
 // Cleans the ambassador annotations and and various annotations
 func (s *AmbassadorStrategy) Clean(svc *v1.Service) error {
+	if s.mode == AmbassadorModeCRD {
+		s.cleanResources(svc, nil)
+	}
+	s.claims.Release(svc, "ambassador")
 	clone := svc.DeepCopy()
 	if !removeServiceAnnotation(clone) {
 		return nil
 	}
-	delete(svc.Annotations, "getambassador.io/config")
+	delete(clone.Annotations, "getambassador.io/config")
 
 	patch, err := createServicePatch(svc, clone)
 	if err != nil {
@@ -242,7 +370,129 @@ func (s *AmbassadorStrategy) Clean(svc *v1.Service) error {
 }
 
 // Delete is called when an exposed service is deleted
-// Nothing to do
+// Deletes the related ambassador resources in crd mode
 func (s *AmbassadorStrategy) Delete(svc *v1.Service) error {
+	if s.mode == AmbassadorModeCRD {
+		s.cleanResources(svc, nil)
+	}
+	s.claims.Release(svc, "ambassador")
 	return nil
 }
+
+// addConfigAnnotation sets the getambassador.io/config annotation
+func (s *AmbassadorStrategy) addConfigAnnotation(svc, clone *v1.Service, hostName, path string, servicePort int, tlsSecretName string) error {
+	ambassadorAnnotations := map[string]interface{}{
+		"apiVersion": "ambassador/v1",
+		"kind":       "Mapping",
+		"host":       hostName,
+		"name":       fmt.Sprintf("%s_%s_mapping", hostName, svc.Namespace),
+		"service":    fmt.Sprintf("%s.%s:%s", svc.Name, svc.Namespace, strconv.Itoa(servicePort)),
+		"prefix":     path,
+	}
+
+	joinedAnnotations := new(bytes.Buffer)
+	fmt.Fprintf(joinedAnnotations, "---\n")
+	yamlAnnotation, err := yaml.Marshal(&ambassadorAnnotations)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(joinedAnnotations, "%s", string(yamlAnnotation))
+
+	if tlsSecretName != "" {
+		// we need to prepare the tls module config
+		ambassadorAnnotations = map[string]interface{}{
+			"apiVersion": "ambassador/v1",
+			"kind":       "Module",
+			"name":       "tls",
+			"config": map[string]interface{}{
+				"server": map[string]interface{}{
+					"enabled": "True",
+					"secret":  tlsSecretName}}}
+
+		yamlAnnotation, err = yaml.Marshal(&ambassadorAnnotations)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(joinedAnnotations, "---\n")
+		fmt.Fprintf(joinedAnnotations, "%s", string(yamlAnnotation))
+	}
+	clone.Annotations["getambassador.io/config"] = joinedAnnotations.String()
+	return nil
+}
+
+// applyResources creates or updates the Mapping and Host resources of the service
+// and deletes the ones it doesn't use anymore
+func (s *AmbassadorStrategy) applyResources(svc *v1.Service, name, hostName, path string, servicePort int, tlsSecretName string) error {
+	hostField := "host"
+	if s.apiVersion == AmbassadorAPIVersionV3Alpha1 {
+		hostField = "hostname"
+	}
+	objects := []*unstructured.Unstructured{
+		newOwnedResource(svc, s.apiVersion, "Mapping", name, map[string]interface{}{
+			"spec": map[string]interface{}{
+				hostField: hostName,
+				"prefix":  path,
+				"service": fmt.Sprintf("%s.%s:%d", svc.Name, svc.Namespace, servicePort),
+			},
+		}),
+	}
+	if tlsSecretName != "" {
+		spec := map[string]interface{}{
+			"hostname": hostName,
+			"tlsSecret": map[string]interface{}{
+				"name": tlsSecretName,
+			},
+		}
+		if !s.tlsAcme {
+			spec["acmeProvider"] = map[string]interface{}{
+				"authority": "none",
+			}
+		}
+		objects = append(objects, newOwnedResource(svc, s.apiVersion, "Host", name, map[string]interface{}{
+			"spec": spec,
+		}))
+	}
+
+	keep := map[ambassadorResource]bool{}
+	for _, object := range objects {
+		keep[ambassadorResource{object.GetKind(), object.GetName()}] = true
+	}
+	s.cleanResources(svc, keep)
+	svcKey := fmt.Sprintf("%s/%s", svc.Namespace, svc.Name)
+	for _, object := range objects {
+		s.existing[svcKey] = append(s.existing[svcKey], ambassadorResource{object.GetKind(), object.GetName()})
+		err := applyResource(s.resources(object.GetKind(), svc.Namespace), svc, object)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cleanResources deletes the resources of the service that should not be kept
+func (s *AmbassadorStrategy) cleanResources(svc *v1.Service, keep map[ambassadorResource]bool) {
+	svcKey := fmt.Sprintf("%s/%s", svc.Namespace, svc.Name)
+	for _, resource := range s.existing[svcKey] {
+		if keep[resource] {
+			continue
+		}
+		existing, err := s.resources(resource.Kind, svc.Namespace).Get(resource.Name, metav1.GetOptions{})
+		if err == nil {
+			exKey, del := getOwnerService(existing)
+			if del || exKey == svcKey {
+				deleteResource(s.resources(resource.Kind, svc.Namespace), existing)
+			}
+		} else if !apierrors.IsNotFound(err) {
+			klog.Errorf("error when getting %s %s/%s: %s",
+				resource.Kind, svc.Namespace, resource.Name, err)
+		}
+	}
+	delete(s.existing, svcKey)
+}
+
+func (s *AmbassadorStrategy) resources(kind, namespace string) dynamic.ResourceInterface {
+	gv, _ := schema.ParseGroupVersion(s.apiVersion)
+	resource := gv.WithResource(strings.ToLower(kind) + "s")
+	return s.dynamic.Resource(resource).Namespace(namespace)
+}
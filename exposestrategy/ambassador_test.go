@@ -4,10 +4,16 @@ import (
 	"testing"
 
 	"k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
 	"k8s.io/client-go/kubernetes/fake"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestAmbassadorStrategy_Add(t *testing.T) {
@@ -218,3 +224,191 @@ func TestAmbassadorStrategy_Clean(t *testing.T) {
 		assert.Equal(t, expected, svc, example.name)
 	}
 }
+
+func newAmbassadorResource(kind, name string, labels, annotations map[string]string, owner string) *unstructured.Unstructured {
+	object := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"spec": map[string]interface{}{},
+		},
+	}
+	object.SetAPIVersion(AmbassadorAPIVersionV2)
+	object.SetKind(kind)
+	object.SetNamespace("main")
+	object.SetName(name)
+	object.SetLabels(labels)
+	object.SetAnnotations(annotations)
+	if owner != "" {
+		object.SetOwnerReferences([]metav1.OwnerReference{{
+			APIVersion: "v1",
+			Kind:       "Service",
+			Name:       owner,
+		}})
+	}
+	return object
+}
+
+func TestAmbassadorStrategy_CRDSync(t *testing.T) {
+	labels := map[string]string{"provider": "fabric8"}
+	annotations := map[string]string{"fabric8.io/generated-by": "exposecontroller"}
+	dynamicClient := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(),
+		newAmbassadorResource("Mapping", "mapping1", labels, annotations, "svc1"),
+		newAmbassadorResource("Mapping", "mapping2", labels, annotations, ""),
+		newAmbassadorResource("Mapping", "mapping3", labels, nil, ""),
+		newAmbassadorResource("Host", "host1", labels, annotations, "svc1"),
+		newAmbassadorResource("Host", "host2", labels, annotations, "svc2"),
+	)
+	strategy, err := NewAmbassadorStrategy(fake.NewSimpleClientset(), &Config{
+		Domain:         "my-domain.com",
+		Namespace:      "main",
+		AmbassadorMode: AmbassadorModeCRD,
+		DynamicClient:  dynamicClient,
+	})
+	require.NoError(t, err)
+	err = strategy.Sync()
+	require.NoError(t, err)
+
+	expected := map[string][]ambassadorResource{
+		"main/svc1": []ambassadorResource{{"Mapping", "mapping1"}, {"Host", "host1"}},
+		"main/svc2": []ambassadorResource{{"Host", "host2"}},
+	}
+	assert.Equal(t, expected, strategy.(*AmbassadorStrategy).existing)
+
+	_, err = dynamicClient.Resource(ambassadorMappings).Namespace("main").Get("mapping2", metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err), "orphan mapping deleted")
+	_, err = dynamicClient.Resource(ambassadorMappings).Namespace("main").Get("mapping3", metav1.GetOptions{})
+	assert.NoError(t, err, "unmanaged mapping kept")
+}
+
+func TestAmbassadorStrategy_CRDAdd(t *testing.T) {
+	svc := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "main",
+			Name:      "svc",
+			UID:       "svc-uid",
+			Annotations: map[string]string{
+				"getambassador.io/config": "anything",
+			},
+		},
+		Spec: v1.ServiceSpec{
+			Ports: []v1.ServicePort{{
+				Port: 123,
+			}},
+		},
+	}
+	client := fake.NewSimpleClientset(svc.DeepCopy())
+	dynamicClient := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme())
+	strategy, err := NewAmbassadorStrategy(client, &Config{
+		Domain:         "my-domain.com",
+		Namespace:      "main",
+		TLSAcme:        true,
+		AmbassadorMode: AmbassadorModeCRD,
+		DynamicClient:  dynamicClient,
+	})
+	require.NoError(t, err)
+	require.NoError(t, strategy.Sync())
+
+	err = strategy.Add(svc.DeepCopy())
+	require.NoError(t, err)
+
+	mapping, err := dynamicClient.Resource(ambassadorMappings).Namespace("main").Get("svc", metav1.GetOptions{})
+	if assert.NoError(t, err, "mapping") {
+		assert.Equal(t, map[string]interface{}{
+			"host":    "svc.main.my-domain.com",
+			"prefix":  "/",
+			"service": "svc.main:123",
+		}, mapping.Object["spec"])
+		owner, del := getOwnerService(mapping)
+		assert.Equal(t, "main/svc", owner)
+		assert.False(t, del)
+	}
+	host, err := dynamicClient.Resource(ambassadorHosts).Namespace("main").Get("svc", metav1.GetOptions{})
+	if assert.NoError(t, err, "host") {
+		assert.Equal(t, map[string]interface{}{
+			"hostname": "svc.main.my-domain.com",
+			"tlsSecret": map[string]interface{}{
+				"name": "tls-svc",
+			},
+		}, host.Object["spec"])
+	}
+	patched, err := client.CoreV1().Services("main").Get("svc", metav1.GetOptions{})
+	if assert.NoError(t, err, "service") {
+		assert.Equal(t, map[string]string{
+			ExposeAnnotationKey: "https://svc.main.my-domain.com/",
+		}, patched.Annotations)
+	}
+
+	// without TLS, the host is not needed anymore
+	strategy.(*AmbassadorStrategy).tlsAcme = false
+	err = strategy.Add(patched)
+	require.NoError(t, err)
+	_, err = dynamicClient.Resource(ambassadorHosts).Namespace("main").Get("svc", metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err), "host deleted")
+	_, err = dynamicClient.Resource(ambassadorMappings).Namespace("main").Get("svc", metav1.GetOptions{})
+	assert.NoError(t, err, "mapping kept")
+
+	patched, err = client.CoreV1().Services("main").Get("svc", metav1.GetOptions{})
+	require.NoError(t, err)
+	err = strategy.Clean(patched)
+	require.NoError(t, err)
+	_, err = dynamicClient.Resource(ambassadorMappings).Namespace("main").Get("svc", metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err), "mapping deleted")
+	patched, err = client.CoreV1().Services("main").Get("svc", metav1.GetOptions{})
+	if assert.NoError(t, err, "service") {
+		assert.Empty(t, patched.Annotations)
+	}
+}
+
+func TestAmbassadorStrategy_CRDv3alpha1(t *testing.T) {
+	svc := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "main",
+			Name:      "svc",
+		},
+		Spec: v1.ServiceSpec{
+			Ports: []v1.ServicePort{{
+				Port: 123,
+			}},
+		},
+	}
+	client := fake.NewSimpleClientset(svc.DeepCopy())
+	dynamicClient := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme())
+	strategy, err := NewAmbassadorStrategy(client, &Config{
+		Domain:               "my-domain.com",
+		AmbassadorMode:       AmbassadorModeCRD,
+		AmbassadorAPIVersion: AmbassadorAPIVersionV3Alpha1,
+		DynamicClient:        dynamicClient,
+	})
+	require.NoError(t, err)
+	require.NoError(t, strategy.Sync())
+
+	err = strategy.Add(svc.DeepCopy())
+	require.NoError(t, err)
+	mapping, err := dynamicClient.Resource(schema.GroupVersionResource{
+		Group:    "getambassador.io",
+		Version:  "v3alpha1",
+		Resource: "mappings",
+	}).Namespace("main").Get("svc", metav1.GetOptions{})
+	if assert.NoError(t, err, "mapping") {
+		assert.Equal(t, "svc.main.my-domain.com", mapping.Object["spec"].(map[string]interface{})["hostname"])
+	}
+
+	err = strategy.Delete(svc.DeepCopy())
+	require.NoError(t, err)
+	assert.Empty(t, strategy.(*AmbassadorStrategy).existing)
+
+	_, err = NewAmbassadorStrategy(client, &Config{
+		Domain:         "my-domain.com",
+		AmbassadorMode: AmbassadorModeCRD,
+	})
+	assert.Error(t, err, "no dynamic client")
+	_, err = NewAmbassadorStrategy(client, &Config{
+		Domain:         "my-domain.com",
+		AmbassadorMode: "other",
+	})
+	assert.Error(t, err, "unknown mode")
+}
+
+var (
+	ambassadorMappings = schema.GroupVersionResource{Group: "getambassador.io", Version: "v2", Resource: "mappings"}
+	ambassadorHosts    = schema.GroupVersionResource{Group: "getambassador.io", Version: "v2", Resource: "hosts"}
+)
@@ -1,6 +1,8 @@
 package exposestrategy
 
 import (
+	"fmt"
+	"net"
 	"strings"
 
 	"github.com/pkg/errors"
@@ -8,31 +10,72 @@ import (
 
 	"k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 )
 
 const (
-	ingress            = "ingress"
-	loadBalancer       = "loadbalancer"
-	nodePort           = "nodeport"
-	domainExt          = ".nip.io"
+	ingress      = "ingress"
+	loadBalancer = "loadbalancer"
+	nodePort     = "nodeport"
+	// DefaultWildcardDNS is the wildcard DNS provider used to build the automatic domains
+	DefaultWildcardDNS = "nip.io"
 	stackpointNS       = "stackpoint-system"
 	stackpointHAProxy  = "spc-balancer"
 	stackpointIPEnvVar = "BALANCER_IP"
+	gatewayAPIGroup    = "gateway.networking.k8s.io"
 )
 
+// ingressController is an ingress controller the auto strategy can detect
+type ingressController struct {
+	// the exact names of the deployments and services, or of their app.kubernetes.io/name and app labels
+	names []string
+	// the spec.controller values of the ingress classes
+	controllers []string
+	exposer     string
+}
+
+// knownIngressControllers are the ingress controllers detected by the auto strategy, by order of preference
+var knownIngressControllers = []ingressController{
+	{[]string{"ingress-nginx", "nginx-ingress", "nginx-ingress-microk8s"}, []string{"k8s.io/ingress-nginx", "nginx.org/ingress-controller"}, ingress},
+	{[]string{"traefik"}, []string{"traefik.io/ingress-controller"}, ingress},
+	{[]string{"contour"}, []string{"projectcontour.io/contour", "projectcontour.io/ingress-controller"}, ingress},
+	{[]string{"ambassador", "emissary-ingress"}, []string{"getambassador.io/ingress-controller"}, "ambassador"},
+	{[]string{"kong", "ingress-kong"}, []string{"ingress-controllers.konghq.com/kong", "konghq.com/ingress-controller"}, "kong"},
+}
+
+var ingressClassesResources = []schema.GroupVersionResource{
+	{Group: "networking.k8s.io", Version: "v1", Resource: "ingressclasses"},
+	{Group: "networking.k8s.io", Version: "v1beta1", Resource: "ingressclasses"},
+}
+
 // NewAutoStrategy creates a new strategy, choose automatically
 func NewAutoStrategy(client kubernetes.Interface, config *Config) (ExposeStrategy, error) {
 	var err error
-	config.Exposer, err = getAutoDefaultExposeRule(client)
+	var reason string
+	config.Exposer, reason, err = getAutoDefaultExposeRule(client, config.DynamicClient)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to automatically get exposer rule.  consider setting 'exposer' type in config.yml")
 	}
-	klog.Infof("Using exposer strategy: %s", config.Exposer)
+	klog.Infof("Using exposer strategy: %s, %s", config.Exposer, reason)
+
+	// the services of the local clusters are reached on a single address, unless the nodes are configured
+	if config.Exposer == nodePort && config.NodeIP == "" && config.NodeSelector == "" {
+		nodes, err := client.CoreV1().Nodes().List(metav1.ListOptions{})
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to list nodes")
+		}
+		if ip, flavour := getLocalNodeIP(nodes.Items); ip != "" {
+			klog.Infof("Using node IP %s of the %s cluster", ip, flavour.name)
+			config.NodeIP = ip
+		}
+	}
 
 	// only try to get domain if we need wildcard dns and one wasn't given to us
 	if config.Domain == "" && (strings.EqualFold(ingress, config.Exposer)) {
-		config.Domain, err = getAutoDefaultDomain(client)
+		config.Domain, err = getAutoDefaultDomain(client, config)
 		if err != nil {
 			return nil, errors.Wrap(err, "failed to get a domain")
 		}
@@ -42,44 +85,305 @@ func NewAutoStrategy(client kubernetes.Interface, config *Config) (ExposeStrateg
 	return New(client, config)
 }
 
-func getAutoDefaultExposeRule(c kubernetes.Interface) (string, error) {
-	// lets default to Ingress on kubernetes for now
-	/*
-		nodes, err := c.CoreV1().Nodes().List(metav1.ListOptions{})
-		if err != nil {
-			return "", errors.Wrap(err, "failed to find any nodes")
+// getAutoDefaultExposeRule inspects the cluster to choose the exposer
+// returns the exposer and the reason it was chosen
+func getAutoDefaultExposeRule(c kubernetes.Interface, dynamicClient dynamic.Interface) (string, string, error) {
+	var found []string
+	exposers := map[string]bool{}
+	detect := func(controller *ingressController, where string) {
+		if controller != nil {
+			found = append(found, fmt.Sprintf("%s found in %s", controller.names[0], where))
+			exposers[controller.exposer] = true
 		}
-		if len(nodes.Items) == 1 {
-			node := nodes.Items[0]
-			if node.Name == "minishift" || node.Name == "minikube" {
-				return nodePort, nil
+	}
+
+	for _, class := range listIngressClasses(c, dynamicClient) {
+		detect(findClassController(&class), "ingress class "+class.GetName())
+	}
+	deployments, err := c.AppsV1().Deployments(metav1.NamespaceAll).List(metav1.ListOptions{})
+	if err != nil {
+		klog.V(2).Infof("failed to list deployments to detect the ingress controllers: %v", err)
+	} else {
+		for _, deployment := range deployments.Items {
+			detect(findIngressController(&deployment.ObjectMeta),
+				fmt.Sprintf("deployment %s/%s", deployment.Namespace, deployment.Name))
+		}
+	}
+	services, err := c.CoreV1().Services(metav1.NamespaceAll).List(metav1.ListOptions{})
+	if err != nil {
+		klog.V(2).Infof("failed to list services to detect the ingress controllers: %v", err)
+	} else {
+		for _, svc := range services.Items {
+			if svc.Spec.Type == v1.ServiceTypeLoadBalancer || svc.Spec.Type == v1.ServiceTypeNodePort {
+				detect(findIngressController(&svc.ObjectMeta),
+					fmt.Sprintf("service %s/%s", svc.Namespace, svc.Name))
 			}
 		}
-	*/
-	return ingress, nil
-}
+	}
+
+	// no exposer supports the Gateway API yet, its presence is only reported
+	gatewayAPI := hasAPIGroup(c, gatewayAPIGroup)
+	if gatewayAPI {
+		klog.Infof("the Gateway API is installed, but no exposer supports it yet")
+	}
+	if len(exposers) > 0 {
+		reason := "detected ingress controllers: " + strings.Join(found, ", ")
+		if gatewayAPI {
+			reason += ", and the Gateway API"
+		}
+		// prefer plain ingresses, unless only a dedicated controller is installed
+		if exposers[ingress] || len(exposers) > 1 {
+			return ingress, reason, nil
+		}
+		for exposer := range exposers {
+			return exposer, reason, nil
+		}
+	}
 
-func getAutoDefaultDomain(c kubernetes.Interface) (string, error) {
 	nodes, err := c.CoreV1().Nodes().List(metav1.ListOptions{})
 	if err != nil {
-		return "", errors.Wrap(err, "failed to find any nodes")
+		klog.V(2).Infof("failed to list nodes to detect a local cluster: %v", err)
+	} else if flavour := getClusterFlavour(nodes.Items); flavour != nil {
+		if gatewayAPI {
+			return flavour.exposer, fmt.Sprintf("no ingress controller detected on %s cluster, only the Gateway API", flavour.name), nil
+		}
+		return flavour.exposer, fmt.Sprintf("no ingress controller detected on %s cluster", flavour.name), nil
+	}
+	if gatewayAPI {
+		return ingress, "no ingress controller detected, only the Gateway API, defaulting to ingress", nil
 	}
+	return ingress, "no ingress controller detected, defaulting to ingress", nil
+}
 
-	// if we're mini* then there's only one node, any router / ingress controller deployed has to be on this one
-	if len(nodes.Items) == 1 {
-		node := nodes.Items[0]
-		if node.Name == "minishift" || node.Name == "minikube" {
-			ip, err := getExternalIP(node)
-			if err != nil {
-				return "", err
+// findIngressController returns the known ingress controller of the deployment or service, nil if none
+// The name or the name labels of the object must be exactly the name of the controller
+func findIngressController(object *metav1.ObjectMeta) *ingressController {
+	identities := []string{
+		object.Labels["app.kubernetes.io/name"],
+		object.Labels["app"],
+		object.Name,
+	}
+	for i := range knownIngressControllers {
+		for _, name := range knownIngressControllers[i].names {
+			for _, identity := range identities {
+				if strings.EqualFold(identity, name) {
+					return &knownIngressControllers[i]
+				}
 			}
-			return ip + domainExt, nil
 		}
 	}
+	return nil
+}
+
+// findClassController returns the known ingress controller of the ingress class, from its spec.controller, nil if none
+func findClassController(class *unstructured.Unstructured) *ingressController {
+	value, _, _ := unstructured.NestedString(class.Object, "spec", "controller")
+	for i := range knownIngressControllers {
+		for _, controller := range knownIngressControllers[i].controllers {
+			if value == controller {
+				return &knownIngressControllers[i]
+			}
+		}
+	}
+	return nil
+}
+
+// listIngressClasses returns the ingress classes of the cluster, from the first API version available
+func listIngressClasses(c kubernetes.Interface, dynamicClient dynamic.Interface) []unstructured.Unstructured {
+	if dynamicClient == nil {
+		return nil
+	}
+	for _, resource := range ingressClassesResources {
+		if !hasAPIResource(c, resource) {
+			continue
+		}
+		list, err := dynamicClient.Resource(resource).List(metav1.ListOptions{})
+		if err != nil {
+			klog.V(2).Infof("failed to list the ingress classes: %v", err)
+			return nil
+		}
+		return list.Items
+	}
+	return nil
+}
+
+// hasAPIGroup tells if the API group is served by the cluster
+func hasAPIGroup(c kubernetes.Interface, group string) bool {
+	groups, err := c.Discovery().ServerGroups()
+	if err != nil {
+		klog.V(2).Infof("failed to discover the API groups: %v", err)
+		return false
+	}
+	for _, g := range groups.Groups {
+		if g.Name == group {
+			return true
+		}
+	}
+	return false
+}
+
+// hasAPIResource tells if the API resource is served by the cluster
+func hasAPIResource(c kubernetes.Interface, resource schema.GroupVersionResource) bool {
+	resources, err := c.Discovery().ServerResourcesForGroupVersion(resource.GroupVersion().String())
+	if err != nil {
+		return false
+	}
+	for _, r := range resources.APIResources {
+		if r.Name == resource.Resource {
+			return true
+		}
+	}
+	return false
+}
+
+// localhostIP is the address of the local clusters mapping their ports to the host
+const localhostIP = "127.0.0.1"
+
+// clusterFlavour is a kind of local cluster, with its defaults
+type clusterFlavour struct {
+	name string
+	// detect tells if the node belongs to a cluster of this flavour
+	detect func(node *v1.Node) bool
+	// exposer is the default exposer, when no ingress controller is detected
+	exposer string
+	// localhost is true when the ports of the cluster are mapped to the host
+	// the services are then exposed on localhost instead of the node IP
+	localhost bool
+}
+
+// clusterFlavours are the local clusters the auto strategy can detect, by order of precedence
+var clusterFlavours = []clusterFlavour{{
+	name: "minikube",
+	detect: func(node *v1.Node) bool {
+		return node.Name == "minikube" || node.Labels["minikube.k8s.io/name"] != ""
+	},
+	exposer: nodePort,
+}, {
+	name: "minishift",
+	detect: func(node *v1.Node) bool {
+		return node.Name == "minishift"
+	},
+	exposer: nodePort,
+}, {
+	name: "kind",
+	detect: func(node *v1.Node) bool {
+		return strings.HasPrefix(node.Spec.ProviderID, "kind://") || node.Name == "kind-control-plane"
+	},
+	exposer:   nodePort,
+	localhost: true,
+}, {
+	name: "k3d",
+	detect: func(node *v1.Node) bool {
+		return strings.HasPrefix(node.Name, "k3d-") || node.Labels["k3d.io/cluster"] != ""
+	},
+	exposer:   loadBalancer,
+	localhost: true,
+}, {
+	name: "rancher-desktop",
+	detect: func(node *v1.Node) bool {
+		return strings.Contains(node.Name, "rancher-desktop")
+	},
+	exposer:   loadBalancer,
+	localhost: true,
+}, {
+	name: "docker-desktop",
+	detect: func(node *v1.Node) bool {
+		return node.Name == "docker-desktop"
+	},
+	exposer:   loadBalancer,
+	localhost: true,
+}, {
+	name: "microk8s",
+	detect: func(node *v1.Node) bool {
+		return node.Labels["microk8s.io/cluster"] != ""
+	},
+	exposer: nodePort,
+}, {
+	name: "k3s",
+	detect: func(node *v1.Node) bool {
+		return strings.HasPrefix(node.Spec.ProviderID, "k3s://") || node.Labels["node.kubernetes.io/instance-type"] == "k3s"
+	},
+	exposer: loadBalancer,
+}}
+
+// getClusterFlavour returns the flavour of the local cluster the nodes belong to, nil if not a local cluster
+func getClusterFlavour(nodes []v1.Node) *clusterFlavour {
+	if len(nodes) == 0 {
+		return nil
+	}
+	for i := range clusterFlavours {
+		if clusterFlavours[i].detect(&nodes[0]) {
+			return &clusterFlavours[i]
+		}
+	}
+	return nil
+}
+
+// getLocalNodeIP returns the IP to reach the services of a local cluster on, empty if not a local cluster
+// the address of the host for the clusters mapping their ports, or the one of the first node
+func getLocalNodeIP(nodes []v1.Node) (string, *clusterFlavour) {
+	flavour := getClusterFlavour(nodes)
+	if flavour == nil {
+		return "", nil
+	}
+	if flavour.localhost {
+		return localhostIP, flavour
+	}
+	ip, err := getExternalIP(nodes[0])
+	if err != nil {
+		klog.Warningf("cannot discover the IP of the %s node %s: %v", flavour.name, nodes[0].Name, err)
+		return "", flavour
+	}
+	return ip, flavour
+}
+
+// lookupIP resolves the host names of the load balancers, replaced in tests
+var lookupIP = net.LookupIP
+
+// getAutoDefaultDomain builds a wildcard DNS domain from the external address of the cluster
+// The address of the ingress controller's load balancer is preferred
+func getAutoDefaultDomain(c kubernetes.Interface, config *Config) (string, error) {
+	wildcardDNS := strings.Trim(config.WildcardDNS, ".")
+	if wildcardDNS == "" {
+		wildcardDNS = DefaultWildcardDNS
+	}
+	domainExt := "." + wildcardDNS
+
+	nodes, err := c.CoreV1().Nodes().List(metav1.ListOptions{})
+	if err != nil {
+		return "", errors.Wrap(err, "failed to find any nodes")
+	}
+	// the local clusters mapping their ports are only reachable on the host
+	localIP, flavour := getLocalNodeIP(nodes.Items)
+	if flavour != nil && flavour.localhost {
+		klog.Infof("Using the host IP of the %s cluster for the domain", flavour.name)
+		return localIP + domainExt, nil
+	}
+
+	ip, where, err := getIngressControllerIP(c, config)
+	if err != nil {
+		return "", err
+	}
+	if ip != "" {
+		klog.Infof("Using the external IP %s of %s for the domain", ip, where)
+		return wildcardDNSHost(ip) + domainExt, nil
+	}
+
+	// on local clusters, any router / ingress controller deployed has to be on the nodes
+	if localIP != "" {
+		klog.Infof("Using the node IP of the %s cluster for the domain", flavour.name)
+		return localIP + domainExt, nil
+	}
 
 	// check for a gofabric8 ingress labelled node
 	selector, err := metav1.LabelSelectorAsSelector(&metav1.LabelSelector{MatchLabels: map[string]string{"fabric8.io/externalIP": "true"}})
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create the node selector")
+	}
 	nodes, err = c.CoreV1().Nodes().List(metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return "", errors.Wrap(err, "failed to find the labelled nodes")
+	}
 	if len(nodes.Items) == 1 {
 		node := nodes.Items[0]
 		ip, err := getExternalIP(node)
@@ -103,7 +407,87 @@ func getAutoDefaultDomain(c kubernetes.Interface) (string, error) {
 			}
 		}
 	}
-	return "", errors.New("no known automatic ways to get an external ip to use with nip.  Please configure exposecontroller configmap manually see https://github.com/olli-ai/exposecontroller#configuration")
+	return "", errors.Errorf("no known automatic ways to get an external ip to use with %s.  Please configure exposecontroller configmap manually see https://github.com/olli-ai/exposecontroller#configuration", wildcardDNS)
+}
+
+// getIngressControllerIP returns the external IP of the ingress controller's load balancer, and where it was found
+// The controller of the configured ingress class is preferred, then the ones handled by the configured exposer
+// returns an empty IP if no load balancer is found
+func getIngressControllerIP(c kubernetes.Interface, config *Config) (string, string, error) {
+	var classController *ingressController
+	if config.IngressClass != "" {
+		for _, class := range listIngressClasses(c, config.DynamicClient) {
+			if class.GetName() == config.IngressClass {
+				classController = findClassController(&class)
+			}
+		}
+	}
+
+	services, err := c.CoreV1().Services(metav1.NamespaceAll).List(metav1.ListOptions{})
+	if err != nil {
+		klog.V(2).Infof("failed to list services to find the ingress controllers: %v", err)
+		return "", "", nil
+	}
+	bestScore, bestIP, bestWhere := 0, "", ""
+	for i := range services.Items {
+		svc := &services.Items[i]
+		if svc.Spec.Type != v1.ServiceTypeLoadBalancer {
+			continue
+		}
+		controller := findIngressController(&svc.ObjectMeta)
+		if controller == nil {
+			continue
+		}
+		score := 1
+		if controller == classController {
+			score = 3
+		} else if controller.exposer == config.Exposer {
+			score = 2
+		}
+		if score <= bestScore {
+			continue
+		}
+		where := fmt.Sprintf("service %s/%s", svc.Namespace, svc.Name)
+		ip, err := getLoadBalancerIP(svc)
+		if err != nil {
+			klog.Warningf("failed to get the external IP of %s: %v", where, err)
+		} else if ip != "" {
+			bestScore, bestIP, bestWhere = score, ip, where
+		}
+	}
+	return bestIP, bestWhere, nil
+}
+
+// getLoadBalancerIP returns the IP of the provisioned load balancer, resolving its host name if needed
+// returns an empty IP if the load balancer is not provisioned yet
+func getLoadBalancerIP(svc *v1.Service) (string, error) {
+	for _, ingress := range svc.Status.LoadBalancer.Ingress {
+		if ingress.IP != "" {
+			return ingress.IP, nil
+		}
+		if ingress.Hostname != "" {
+			ips, err := lookupIP(ingress.Hostname)
+			if err != nil {
+				return "", errors.Wrapf(err, "failed to resolve %s", ingress.Hostname)
+			}
+			for _, ip := range ips {
+				if ip.To4() != nil {
+					klog.Warningf("the IP %s of %s may change, consider configuring the domain", ip, ingress.Hostname)
+					return ip.String(), nil
+				}
+			}
+		}
+	}
+	return "", nil
+}
+
+// wildcardDNSHost returns the host name of the IP for a wildcard DNS provider
+// IPv6 addresses use dashes instead of colons
+func wildcardDNSHost(ip string) string {
+	if strings.Contains(ip, ":") {
+		return strings.Replace(ip, ":", "-", -1)
+	}
+	return ip
 }
 
 // copied from k8s.io/kubernetes/pkg/master/master.go
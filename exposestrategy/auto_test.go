@@ -0,0 +1,291 @@
+package exposestrategy
+
+import (
+	"net"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetAutoDefaultExposeRule(t *testing.T) {
+	deployment := func(namespace, name string, labels map[string]string) runtime.Object {
+		return &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      name,
+			Labels:    labels,
+		}}
+	}
+	service := func(namespace, name string, serviceType v1.ServiceType) runtime.Object {
+		return &v1.Service{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+			Spec:       v1.ServiceSpec{Type: serviceType},
+		}
+	}
+	node := func(name, providerID string) runtime.Object {
+		return &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       v1.NodeSpec{ProviderID: providerID},
+		}
+	}
+	examples := []struct {
+		name     string
+		objects  []runtime.Object
+		groups   []string
+		expected string
+		reason   string
+	}{{
+		name:     "empty cluster",
+		expected: ingress,
+		reason:   "no ingress controller detected, defaulting to ingress",
+	}, {
+		name: "ingress-nginx deployment",
+		objects: []runtime.Object{
+			deployment("ingress-nginx", "controller", map[string]string{"app.kubernetes.io/name": "ingress-nginx"}),
+			node("minikube", ""),
+		},
+		expected: ingress,
+		reason:   "detected ingress controllers: ingress-nginx found in deployment ingress-nginx/controller",
+	}, {
+		name: "ambassador only",
+		objects: []runtime.Object{
+			service("ambassador", "ambassador", v1.ServiceTypeLoadBalancer),
+			service("ambassador", "ambassador-admin", v1.ServiceTypeClusterIP),
+		},
+		expected: "ambassador",
+		reason:   "detected ingress controllers: ambassador found in service ambassador/ambassador",
+	}, {
+		name: "kong and traefik",
+		objects: []runtime.Object{
+			deployment("kube-system", "traefik", nil),
+			deployment("kong", "kong", map[string]string{"app": "kong"}),
+		},
+		expected: ingress,
+	}, {
+		name:     "kind",
+		objects:  []runtime.Object{node("kind-control-plane", "kind://docker/kind/kind-control-plane")},
+		expected: nodePort,
+		reason:   "no ingress controller detected on kind cluster",
+	}, {
+		name:     "k3d",
+		objects:  []runtime.Object{node("k3d-dev-server-0", "k3s://k3d-dev-server-0")},
+		expected: loadBalancer,
+		reason:   "no ingress controller detected on k3d cluster",
+	}, {
+		name:     "not a local cluster",
+		objects:  []runtime.Object{node("node-1", "gce://project/zone/node-1"), node("node-2", "gce://project/zone/node-2")},
+		expected: ingress,
+	}, {
+		name: "names only containing a controller name",
+		objects: []runtime.Object{
+			deployment("default", "kong-client", map[string]string{"app.kubernetes.io/name": "my-traefik-dashboard"}),
+			service("default", "ambassador-proxy", v1.ServiceTypeLoadBalancer),
+		},
+		expected: ingress,
+		reason:   "no ingress controller detected, defaulting to ingress",
+	}, {
+		name:     "gateway API",
+		groups:   []string{gatewayAPIGroup + "/v1beta1"},
+		expected: ingress,
+		reason:   "no ingress controller detected, only the Gateway API, defaulting to ingress",
+	}, {
+		name:     "gateway API on kind",
+		objects:  []runtime.Object{node("kind-control-plane", "kind://docker/kind/kind-control-plane")},
+		groups:   []string{gatewayAPIGroup + "/v1"},
+		expected: nodePort,
+		reason:   "no ingress controller detected on kind cluster, only the Gateway API",
+	}, {
+		name:     "gateway API and an ingress controller",
+		objects:  []runtime.Object{deployment("kube-system", "traefik", nil)},
+		groups:   []string{gatewayAPIGroup + "/v1"},
+		expected: ingress,
+		reason:   "detected ingress controllers: traefik found in deployment kube-system/traefik, and the Gateway API",
+	}}
+	for _, example := range examples {
+		client := fake.NewSimpleClientset(example.objects...)
+		for _, group := range example.groups {
+			discovery := client.Discovery().(*fakediscovery.FakeDiscovery)
+			discovery.Resources = append(discovery.Resources, &metav1.APIResourceList{GroupVersion: group})
+		}
+		exposer, reason, err := getAutoDefaultExposeRule(client, nil)
+		require.NoError(t, err, example.name)
+		assert.Equal(t, example.expected, exposer, example.name)
+		if example.reason != "" {
+			assert.Equal(t, example.reason, reason, example.name)
+		}
+	}
+}
+
+func TestGetClusterFlavour(t *testing.T) {
+	examples := []struct {
+		node      v1.Node
+		flavour   string
+		localhost bool
+	}{{
+		node:    v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "dev", Labels: map[string]string{"minikube.k8s.io/name": "dev"}}},
+		flavour: "minikube",
+	}, {
+		node:      v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "dev-worker"}, Spec: v1.NodeSpec{ProviderID: "kind://docker/dev/dev-worker"}},
+		flavour:   "kind",
+		localhost: true,
+	}, {
+		node:      v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "k3d-dev-agent-1"}, Spec: v1.NodeSpec{ProviderID: "k3s://k3d-dev-agent-1"}},
+		flavour:   "k3d",
+		localhost: true,
+	}, {
+		node:    v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "raspberry"}, Spec: v1.NodeSpec{ProviderID: "k3s://raspberry"}},
+		flavour: "k3s",
+	}, {
+		node:    v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "laptop", Labels: map[string]string{"microk8s.io/cluster": "true"}}},
+		flavour: "microk8s",
+	}, {
+		node:      v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "docker-desktop"}},
+		flavour:   "docker-desktop",
+		localhost: true,
+	}, {
+		node:      v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "lima-rancher-desktop"}, Spec: v1.NodeSpec{ProviderID: "k3s://lima-rancher-desktop"}},
+		flavour:   "rancher-desktop",
+		localhost: true,
+	}, {
+		node: v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}},
+	}}
+	for _, example := range examples {
+		flavour := getClusterFlavour([]v1.Node{example.node})
+		if example.flavour == "" {
+			assert.Nil(t, flavour, example.node.Name)
+		} else if assert.NotNil(t, flavour, example.node.Name) {
+			assert.Equal(t, example.flavour, flavour.name, example.node.Name)
+			assert.Equal(t, example.localhost, flavour.localhost, example.node.Name)
+		}
+	}
+}
+
+func TestGetAutoDefaultExposeRule_IngressClass(t *testing.T) {
+	class := &unstructured.Unstructured{}
+	class.SetAPIVersion("networking.k8s.io/v1")
+	class.SetKind("IngressClass")
+	class.SetName("public")
+	unstructured.SetNestedField(class.Object, "ingress-controllers.konghq.com/kong", "spec", "controller")
+	// the name of the class does not matter, only its controller
+	other := &unstructured.Unstructured{}
+	other.SetAPIVersion("networking.k8s.io/v1")
+	other.SetKind("IngressClass")
+	other.SetName("traefik")
+	unstructured.SetNestedField(other.Object, "example.com/ingress-controller", "spec", "controller")
+	dynamicClient := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), class, other)
+	client := fake.NewSimpleClientset()
+	client.Discovery().(*fakediscovery.FakeDiscovery).Resources = []*metav1.APIResourceList{{
+		GroupVersion: "networking.k8s.io/v1",
+		APIResources: []metav1.APIResource{{Name: "ingressclasses"}},
+	}}
+
+	exposer, reason, err := getAutoDefaultExposeRule(client, dynamicClient)
+	require.NoError(t, err)
+	assert.Equal(t, "kong", exposer)
+	assert.Equal(t, "detected ingress controllers: kong found in ingress class public", reason)
+}
+
+func TestGetAutoDefaultDomain(t *testing.T) {
+	lookupIP = func(host string) ([]net.IP, error) {
+		if host == "elb.amazonaws.com" {
+			return []net.IP{net.ParseIP("2001:db8::1"), net.ParseIP("52.1.2.3")}, nil
+		}
+		return nil, errors.New("not found")
+	}
+	defer func() { lookupIP = net.LookupIP }()
+	loadBalancer := func(namespace, name, app, ip, hostname string) runtime.Object {
+		labels := map[string]string{}
+		if app != "" {
+			labels["app.kubernetes.io/name"] = app
+		}
+		return &v1.Service{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, Labels: labels},
+			Spec:       v1.ServiceSpec{Type: v1.ServiceTypeLoadBalancer},
+			Status: v1.ServiceStatus{LoadBalancer: v1.LoadBalancerStatus{
+				Ingress: []v1.LoadBalancerIngress{{IP: ip, Hostname: hostname}},
+			}},
+		}
+	}
+	examples := []struct {
+		name     string
+		objects  []runtime.Object
+		config   Config
+		expected string
+	}{{
+		name: "ingress-nginx",
+		objects: []runtime.Object{
+			loadBalancer("ingress-nginx", "ingress-nginx-controller", "ingress-nginx", "35.1.2.3", ""),
+			loadBalancer("default", "my-app", "", "35.9.9.9", ""),
+		},
+		expected: "35.1.2.3.nip.io",
+	}, {
+		name: "preferred exposer and custom wildcard DNS",
+		objects: []runtime.Object{
+			loadBalancer("kube-system", "traefik", "", "35.1.2.3", ""),
+			loadBalancer("ambassador", "ambassador", "", "35.4.5.6", ""),
+			loadBalancer("kong", "kong-proxy", "kong", "", ""),
+		},
+		config:   Config{Exposer: "ambassador", WildcardDNS: ".sslip.io"},
+		expected: "35.4.5.6.sslip.io",
+	}, {
+		name:     "host name",
+		objects:  []runtime.Object{loadBalancer("ingress-nginx", "ingress-nginx-controller", "ingress-nginx", "", "elb.amazonaws.com")},
+		expected: "52.1.2.3.nip.io",
+	}, {
+		name:     "IPv6",
+		objects:  []runtime.Object{loadBalancer("kube-system", "traefik", "", "2001:db8::2", "")},
+		config:   Config{WildcardDNS: "sslip.io"},
+		expected: "2001-db8--2.sslip.io",
+	}, {
+		name: "minikube",
+		objects: []runtime.Object{&v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "minikube"},
+			Status: v1.NodeStatus{Addresses: []v1.NodeAddress{{
+				Type:    v1.NodeInternalIP,
+				Address: "192.168.99.100",
+			}}},
+		}},
+		config:   Config{WildcardDNS: "example.com"},
+		expected: "192.168.99.100.example.com",
+	}, {
+		name: "kind",
+		objects: []runtime.Object{
+			&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "kind-control-plane"}},
+			loadBalancer("ingress-nginx", "ingress-nginx-controller", "ingress-nginx", "172.18.0.2", ""),
+		},
+		expected: "127.0.0.1.nip.io",
+	}, {
+		name: "microk8s",
+		objects: []runtime.Object{
+			&v1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "laptop", Labels: map[string]string{"microk8s.io/cluster": "true"}},
+				Status: v1.NodeStatus{Addresses: []v1.NodeAddress{{
+					Type:    v1.NodeInternalIP,
+					Address: "10.1.1.1",
+				}}},
+			},
+			loadBalancer("ingress", "nginx-ingress-microk8s-controller", "nginx-ingress-microk8s", "10.1.1.100", ""),
+		},
+		expected: "10.1.1.100.nip.io",
+	}}
+	for _, example := range examples {
+		client := fake.NewSimpleClientset(example.objects...)
+		domain, err := getAutoDefaultDomain(client, &example.config)
+		require.NoError(t, err, example.name)
+		assert.Equal(t, example.expected, domain, example.name)
+	}
+
+	_, err := getAutoDefaultDomain(fake.NewSimpleClientset(), &Config{})
+	assert.Error(t, err, "nothing found")
+}
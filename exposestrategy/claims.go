@@ -0,0 +1,200 @@
+package exposestrategy
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"k8s.io/klog"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	// HostConflictReason is the reason of the events of the services in conflict
+	HostConflictReason = "HostConflict"
+	// hostConflictStatus prefixes the expose status of the services in conflict
+	hostConflictStatus = "Error: "
+)
+
+// HostClaims indexes the hosts and paths claimed by the exposed services, across the namespaces
+// The oldest service claiming a host and path wins, the other ones are in conflict
+// It is shared by the strategies
+type HostClaims struct {
+	lock sync.Mutex
+	// the services claiming each host and path, by host and path, then by service key
+	claims map[string]map[string]*hostClaimant
+	// the hosts and paths claimed by each service, by service key, then by kind
+	services map[string]map[string]string
+	// onChange is called, in its own goroutine, with the services whose claims changed winner
+	onChange func(namespace, name string)
+}
+
+type hostClaimant struct {
+	namespace string
+	name      string
+	created   metav1.Time
+	// the kinds of resources claiming the host and path, like "ingress"
+	kinds map[string]bool
+}
+
+func (c *hostClaimant) before(other *hostClaimant) bool {
+	if !c.created.Equal(&other.created) {
+		return c.created.Before(&other.created)
+	}
+	return c.namespace+"/"+c.name < other.namespace+"/"+other.name
+}
+
+// NewHostClaims creates an empty index
+func NewHostClaims() *HostClaims {
+	return &HostClaims{
+		claims:   map[string]map[string]*hostClaimant{},
+		services: map[string]map[string]string{},
+	}
+}
+
+// OnChange sets the function called with the services whose claims changed winner
+// so that they are exposed or unexposed again
+func (c *HostClaims) OnChange(onChange func(namespace, name string)) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.onChange = onChange
+}
+
+// Claim records the host and path claimed by the kind of resources of the service, replacing its previous claim
+// returns an error if an older service claims the same host and path
+func (c *HostClaims) Claim(svc *v1.Service, kind, host, path string) error {
+	if c == nil {
+		return nil
+	}
+	if path == "" {
+		path = "/"
+	}
+	key := host + path
+	svcKey := svc.Namespace + "/" + svc.Name
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if previous, ok := c.services[svcKey][kind]; ok && previous != key {
+		c.release(svcKey, kind, previous)
+	}
+	if c.services[svcKey] == nil {
+		c.services[svcKey] = map[string]string{}
+	}
+	c.services[svcKey][kind] = key
+
+	claimants := c.claims[key]
+	if claimants == nil {
+		claimants = map[string]*hostClaimant{}
+		c.claims[key] = claimants
+	}
+	winner := c.winner(key)
+	claimant := claimants[svcKey]
+	if claimant == nil {
+		claimant = &hostClaimant{namespace: svc.Namespace, name: svc.Name, kinds: map[string]bool{}}
+		claimants[svcKey] = claimant
+	}
+	claimant.created = svc.CreationTimestamp
+	claimant.kinds[kind] = true
+
+	if winner != nil && winner != claimant {
+		if winner.before(claimant) {
+			return errors.Errorf("host %s is already claimed by service %s/%s", key, winner.namespace, winner.name)
+		}
+		// an older service takes the host and path back
+		c.notify(winner)
+	}
+	return nil
+}
+
+// Release forgets the host and path claimed by the kind of resources of the service
+func (c *HostClaims) Release(svc *v1.Service, kind string) {
+	if c == nil {
+		return
+	}
+	svcKey := svc.Namespace + "/" + svc.Name
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if key, ok := c.services[svcKey][kind]; ok {
+		c.release(svcKey, kind, key)
+		delete(c.services[svcKey], kind)
+		if len(c.services[svcKey]) == 0 {
+			delete(c.services, svcKey)
+		}
+	}
+}
+
+func (c *HostClaims) release(svcKey, kind, key string) {
+	claimants := c.claims[key]
+	claimant := claimants[svcKey]
+	if claimant == nil {
+		return
+	}
+	delete(claimant.kinds, kind)
+	if len(claimant.kinds) > 0 {
+		return
+	}
+	winner := c.winner(key)
+	delete(claimants, svcKey)
+	if len(claimants) == 0 {
+		delete(c.claims, key)
+	} else if winner == claimant {
+		// the next service takes the host and path
+		c.notify(c.winner(key))
+	}
+}
+
+func (c *HostClaims) winner(key string) *hostClaimant {
+	var winner *hostClaimant
+	for _, claimant := range c.claims[key] {
+		if winner == nil || claimant.before(winner) {
+			winner = claimant
+		}
+	}
+	return winner
+}
+
+func (c *HostClaims) notify(claimant *hostClaimant) {
+	if c.onChange != nil {
+		go c.onChange(claimant.namespace, claimant.name)
+	}
+}
+
+// reportHostConflict unexposes the service, with an Error status and a Warning event
+// also removes the given annotations of the strategy
+// returns the conflict error
+func reportHostConflict(client kubernetes.Interface, recorder record.EventRecorder, svc *v1.Service, conflict error, annotations ...string) error {
+	klog.Warningf("not exposing service %s/%s: %v", svc.Namespace, svc.Name, conflict)
+	if recorder != nil {
+		recorder.Event(svc, v1.EventTypeWarning, HostConflictReason, conflict.Error())
+	}
+	clone := svc.DeepCopy()
+	removeServiceAnnotation(clone)
+	for _, key := range annotations {
+		delete(clone.Annotations, key)
+	}
+	if clone.Annotations == nil {
+		clone.Annotations = map[string]string{}
+	}
+	clone.Annotations[ExposeStatusAnnotationKey] = hostConflictStatus + conflict.Error()
+	patch, err := createServicePatch(svc, clone)
+	if err != nil {
+		return errors.Wrapf(err, "failed to create patch for service %s/%s", svc.Namespace, svc.Name)
+	}
+	if patch != nil {
+		_, err = client.CoreV1().Services(svc.Namespace).Patch(svc.Name, patchType, patch)
+		if err != nil {
+			return errors.Wrapf(err, "failed to send patch %s/%s", svc.Namespace, svc.Name)
+		}
+	}
+	return errors.Wrapf(conflict, "failed to expose service %s/%s", svc.Namespace, svc.Name)
+}
+
+// clearHostConflict removes the Error status of a previous conflict from the clone of the service
+func clearHostConflict(clone *v1.Service) {
+	if strings.HasPrefix(clone.Annotations[ExposeStatusAnnotationKey], hostConflictStatus+"host ") {
+		delete(clone.Annotations, ExposeStatusAnnotationKey)
+	}
+}
@@ -0,0 +1,132 @@
+package exposestrategy
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func claimingService(namespace, name string, created int64) *v1.Service {
+	return &v1.Service{ObjectMeta: metav1.ObjectMeta{
+		Namespace:         namespace,
+		Name:              name,
+		CreationTimestamp: metav1.Unix(created, 0),
+	}}
+}
+
+func TestHostClaims(t *testing.T) {
+	changed := make(chan string, 10)
+	claims := NewHostClaims()
+	claims.OnChange(func(namespace, name string) {
+		changed <- namespace + "/" + name
+	})
+	expectChanged := func(expected string) {
+		select {
+		case key := <-changed:
+			assert.Equal(t, expected, key)
+		case <-time.After(time.Second):
+			t.Errorf("%s not notified", expected)
+		}
+	}
+
+	first := claimingService("a", "first", 100)
+	second := claimingService("b", "second", 200)
+	older := claimingService("c", "older", 50)
+	assert.NoError(t, claims.Claim(first, "ingress", "my-host.example.com", ""))
+	assert.NoError(t, claims.Claim(first, "ambassador", "my-host.example.com", "/"))
+	assert.NoError(t, claims.Claim(second, "ingress", "my-host.example.com", "/other"))
+	assert.EqualError(t, claims.Claim(second, "ingress", "my-host.example.com", "/"),
+		"host my-host.example.com/ is already claimed by service a/first")
+
+	// the older service takes the host back
+	assert.NoError(t, claims.Claim(older, "ingress", "my-host.example.com", "/"))
+	expectChanged("a/first")
+	assert.Error(t, claims.Claim(first, "ingress", "my-host.example.com", "/"))
+
+	// the next service wins only once all the kinds of the winner are released
+	claims.Release(older, "ingress")
+	expectChanged("a/first")
+	claims.Release(first, "ingress")
+	assert.Error(t, claims.Claim(second, "ingress", "my-host.example.com", "/"))
+	claims.Release(first, "ambassador")
+	expectChanged("b/second")
+	assert.NoError(t, claims.Claim(second, "ingress", "my-host.example.com", "/"))
+
+	// moving to another host releases the previous one
+	newer := claimingService("d", "newer", 300)
+	assert.Error(t, claims.Claim(newer, "ingress", "my-host.example.com", "/"))
+	assert.NoError(t, claims.Claim(second, "ingress", "other-host.example.com", "/"))
+	expectChanged("d/newer")
+	assert.NoError(t, claims.Claim(newer, "ingress", "my-host.example.com", "/"))
+
+	var nilClaims *HostClaims
+	assert.NoError(t, nilClaims.Claim(first, "ingress", "my-host.example.com", "/"))
+	nilClaims.Release(first, "ingress")
+}
+
+func TestIngressStrategy_HostConflict(t *testing.T) {
+	exposed := func(namespace string, created int64) *v1.Service {
+		svc := claimingService(namespace, "my-service", created)
+		svc.Annotations = map[string]string{
+			ExposeAnnotation.Key:   ExposeAnnotation.Value,
+			"fabric8.io/host.name": "shared",
+		}
+		svc.Spec.Ports = []v1.ServicePort{{Port: 80}}
+		return svc
+	}
+	client := fake.NewSimpleClientset(exposed("first", 100), exposed("second", 200))
+	recorder := record.NewFakeRecorder(10)
+	claims := NewHostClaims()
+	strategy, err := NewIngressStrategy(client, &Config{
+		Exposer:     "ingress",
+		Domain:      "example.com",
+		URLTemplate: "{{.Service}}.{{.Domain}}",
+		HTTP:        true,
+		Claims:      claims,
+		Recorder:    recorder,
+	})
+	require.NoError(t, err)
+	require.NoError(t, strategy.Sync())
+	for _, namespace := range []string{"first", "second"} {
+		svc, err := client.CoreV1().Services(namespace).Get("my-service", metav1.GetOptions{})
+		require.NoError(t, err)
+		err = strategy.Add(svc)
+		if namespace == "first" {
+			require.NoError(t, err)
+		} else {
+			assert.Error(t, err)
+		}
+	}
+
+	svc, err := client.CoreV1().Services("second").Get("my-service", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, svc.Annotations[ExposeAnnotationKey])
+	assert.Equal(t, "Error: host shared.example.com/ is already claimed by service first/my-service",
+		svc.Annotations[ExposeStatusAnnotationKey])
+	_, err = client.ExtensionsV1beta1().Ingresses("second").Get("my-service", metav1.GetOptions{})
+	assert.Error(t, err)
+	if assert.Len(t, recorder.Events, 1) {
+		event := <-recorder.Events
+		assert.True(t, strings.HasPrefix(event, "Warning HostConflict "), event)
+	}
+
+	// the winner goes away
+	first, err := client.CoreV1().Services("first").Get("my-service", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.NoError(t, strategy.Delete(first))
+	require.NoError(t, strategy.Add(svc))
+	svc, err = client.CoreV1().Services("second").Get("my-service", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "http://shared.example.com", svc.Annotations[ExposeAnnotationKey])
+	assert.Empty(t, svc.Annotations[ExposeStatusAnnotationKey])
+	_, err = client.ExtensionsV1beta1().Ingresses("second").Get("my-service", metav1.GetOptions{})
+	assert.NoError(t, err)
+}
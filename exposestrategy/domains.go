@@ -0,0 +1,119 @@
+package exposestrategy
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// DomainAnnotationKey annotation selects the named domain of the service
+const DomainAnnotationKey = "fabric8.io/domain"
+
+// DomainProfile is a named domain, with its own settings
+// The empty settings keep the ones of the strategy
+type DomainProfile struct {
+	Domain        string `yaml:"domain" json:"domain"`
+	TLSSecretName string `yaml:"tls-secret-name,omitempty" json:"tls_secret_name"`
+	IngressClass  string `yaml:"ingress-class,omitempty" json:"ingress_class"`
+	URLTemplate   string `yaml:"urltemplate,omitempty" json:"url_template"`
+}
+
+// DomainRule selects the named domain of the services matching its selectors
+// The selectors are in label selector format, empty for all
+type DomainRule struct {
+	Domain            string `yaml:"domain" json:"domain"`
+	NamespaceSelector string `yaml:"namespace-selector,omitempty" json:"namespace_selector"`
+	ServiceSelector   string `yaml:"service-selector,omitempty" json:"service_selector"`
+}
+
+// domainProfile is a checked domain profile, with its url template
+type domainProfile struct {
+	DomainProfile
+	// urlTemplate is nil to keep the one of the strategy
+	urlTemplate *urlTemplate
+}
+
+type domainRule struct {
+	domain            string
+	namespaceSelector labels.Selector
+	serviceSelector   labels.Selector
+}
+
+// domainProfiles chooses the named domain of the services
+type domainProfiles struct {
+	profiles map[string]*domainProfile
+	rules    []domainRule
+}
+
+// getDomainProfiles checks the named domains and the rules of the config
+func getDomainProfiles(config *Config) (*domainProfiles, error) {
+	d := &domainProfiles{profiles: map[string]*domainProfile{}}
+	names := make([]string, 0, len(config.Domains))
+	for name := range config.Domains {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		profile := config.Domains[name]
+		if profile.Domain == "" {
+			return nil, errors.Errorf("named domain \"%s\" requires a domain", name)
+		}
+		if err := checkURLTemplate(profile.URLTemplate, profile.Domain); err != nil {
+			return nil, errors.Wrapf(err, "invalid named domain \"%s\"", name)
+		}
+		var urlTemplate *urlTemplate
+		if profile.URLTemplate != "" {
+			urlTemplate, _ = parseURLTemplate(profile.URLTemplate)
+		}
+		d.profiles[name] = &domainProfile{profile, urlTemplate}
+	}
+	for i, rule := range config.DomainRules {
+		if _, ok := d.profiles[rule.Domain]; !ok {
+			return nil, errors.Errorf("domain rule %d selects the unknown named domain \"%s\"", i+1, rule.Domain)
+		}
+		namespaceSelector, err := labels.Parse(rule.NamespaceSelector)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse the namespace selector of domain rule %d", i+1)
+		}
+		serviceSelector, err := labels.Parse(rule.ServiceSelector)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse the service selector of domain rule %d", i+1)
+		}
+		d.rules = append(d.rules, domainRule{rule.Domain, namespaceSelector, serviceSelector})
+	}
+	return d, nil
+}
+
+// choose returns the named domain of the service, nil for the default domain
+// The annotation comes first, then the internal domain annotation, then the first matching rule
+func (d *domainProfiles) choose(svc *v1.Service, namespaceLabels labels.Set) (*domainProfile, error) {
+	if d == nil {
+		d = &domainProfiles{}
+	}
+	if name := svc.Annotations[DomainAnnotationKey]; name != "" {
+		profile, ok := d.profiles[name]
+		if !ok {
+			names := make([]string, 0, len(d.profiles))
+			for n := range d.profiles {
+				names = append(names, n)
+			}
+			sort.Strings(names)
+			return nil, errors.Errorf("unknown named domain \"%s\" in service %s/%s, must be one of \"%s\"",
+				name, svc.Namespace, svc.Name, strings.Join(names, "\", \""))
+		}
+		return profile, nil
+	}
+	if svc.Annotations["fabric8.io/use.internal.domain"] == "true" {
+		return nil, nil
+	}
+	for _, rule := range d.rules {
+		if rule.namespaceSelector.Matches(namespaceLabels) && rule.serviceSelector.Matches(labels.Set(svc.Labels)) {
+			return d.profiles[rule.domain], nil
+		}
+	}
+	return nil, nil
+}
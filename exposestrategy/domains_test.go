@@ -0,0 +1,144 @@
+package exposestrategy
+
+import (
+	"testing"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetDomainProfiles(t *testing.T) {
+	domains := map[string]DomainProfile{
+		"public":   {Domain: "example.com"},
+		"internal": {Domain: "internal.example.com", TLSSecretName: "internal-tls", URLTemplate: "{{.Service}}.{{.Domain}}"},
+	}
+	d, err := getDomainProfiles(&Config{
+		Domains: domains,
+		DomainRules: []DomainRule{
+			{Domain: "internal", ServiceSelector: "visibility=internal"},
+			{Domain: "public", NamespaceSelector: "env in (production, staging)"},
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "{{.Service}}.{{.Domain}}", d.profiles["internal"].urlTemplate.source())
+	assert.Nil(t, d.profiles["public"].urlTemplate)
+
+	service := func(annotations, serviceLabels map[string]string) *v1.Service {
+		return &v1.Service{ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "main",
+			Name:        "my-service",
+			Annotations: annotations,
+			Labels:      serviceLabels,
+		}}
+	}
+	for _, test := range []struct {
+		name            string
+		svc             *v1.Service
+		namespaceLabels labels.Set
+		expected        string
+	}{
+		{"no match", service(nil, nil), labels.Set{"env": "dev"}, ""},
+		{"namespace rule", service(nil, nil), labels.Set{"env": "production"}, "example.com"},
+		{"service rule first", service(nil, map[string]string{"visibility": "internal"}), labels.Set{"env": "production"}, "internal.example.com"},
+		{"annotation", service(map[string]string{DomainAnnotationKey: "internal"}, nil), nil, "internal.example.com"},
+		{"internal domain", service(map[string]string{"fabric8.io/use.internal.domain": "true"}, nil), labels.Set{"env": "production"}, ""},
+	} {
+		profile, err := d.choose(test.svc, test.namespaceLabels)
+		require.NoError(t, err, test.name)
+		if test.expected == "" {
+			assert.Nil(t, profile, test.name)
+		} else if assert.NotNil(t, profile, test.name) {
+			assert.Equal(t, test.expected, profile.Domain, test.name)
+		}
+	}
+	_, err = d.choose(service(map[string]string{DomainAnnotationKey: "unknown"}, nil), nil)
+	assert.EqualError(t, err, "unknown named domain \"unknown\" in service main/my-service, must be one of \"internal\", \"public\"")
+
+	for _, config := range []*Config{
+		{Domains: map[string]DomainProfile{"empty": {}}},
+		{Domains: map[string]DomainProfile{"invalid": {Domain: "example.com", URLTemplate: "{{.Unknown}}"}}},
+		{Domains: domains, DomainRules: []DomainRule{{Domain: "unknown"}}},
+		{Domains: domains, DomainRules: []DomainRule{{Domain: "public", ServiceSelector: "a in b"}}},
+	} {
+		_, err = getDomainProfiles(config)
+		assert.Error(t, err, "%v", config)
+	}
+}
+
+func TestIngressStrategy_Domains(t *testing.T) {
+	client := fake.NewSimpleClientset(&v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "main",
+			Name:      "my-service",
+			Annotations: map[string]string{
+				ExposeAnnotation.Key: ExposeAnnotation.Value,
+				DomainAnnotationKey:  "internal",
+			},
+		},
+		Spec: v1.ServiceSpec{Ports: []v1.ServicePort{{Port: 80}}},
+	})
+	strategy, err := NewIngressStrategy(client, &Config{
+		Exposer:       "ingress",
+		Domain:        "example.com",
+		TLSSecretName: "tls",
+		IngressClass:  "public",
+		Domains: map[string]DomainProfile{"internal": {
+			Domain:       "internal.example.com",
+			IngressClass: "internal",
+			URLTemplate:  "{{.Service}}.{{.Domain}}",
+		}},
+	})
+	require.NoError(t, err)
+	require.NoError(t, strategy.Sync())
+	service, err := client.CoreV1().Services("main").Get("my-service", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.NoError(t, strategy.Add(service))
+
+	service, err = client.CoreV1().Services("main").Get("my-service", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "https://my-service.internal.example.com", service.Annotations[ExposeAnnotationKey])
+	ingress, err := client.ExtensionsV1beta1().Ingresses("main").Get("my-service", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "internal", ingress.Annotations["kubernetes.io/ingress.class"])
+	if assert.Len(t, ingress.Spec.TLS, 1) {
+		assert.Equal(t, "tls", ingress.Spec.TLS[0].SecretName)
+	}
+
+	service.Annotations[DomainAnnotationKey] = "unknown"
+	assert.Error(t, strategy.Add(service))
+}
+
+func TestAmbassadorStrategy_Domains(t *testing.T) {
+	client := fake.NewSimpleClientset(&v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "main",
+			Name:        "my-service",
+			Labels:      map[string]string{"visibility": "internal"},
+			Annotations: map[string]string{ExposeAnnotation.Key: ExposeAnnotation.Value},
+		},
+		Spec: v1.ServiceSpec{Ports: []v1.ServicePort{{Port: 80}}},
+	})
+	strategy, err := NewAmbassadorStrategy(client, &Config{
+		Exposer: "ambassador",
+		Domain:  "example.com",
+		HTTP:    true,
+		Domains: map[string]DomainProfile{"internal": {
+			Domain:        "internal.example.com",
+			TLSSecretName: "internal-tls",
+		}},
+		DomainRules: []DomainRule{{Domain: "internal", ServiceSelector: "visibility=internal"}},
+	})
+	require.NoError(t, err)
+	service, err := client.CoreV1().Services("main").Get("my-service", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.NoError(t, strategy.Add(service))
+
+	service, err = client.CoreV1().Services("main").Get("my-service", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "http://my-service.main.internal.example.com/", service.Annotations[ExposeAnnotationKey])
+}
@@ -0,0 +1,241 @@
+package exposestrategy
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"k8s.io/klog"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// ExposeExternalIPAnnotationKey annotation will be created with the external IP allocated to the service
+const ExposeExternalIPAnnotationKey = "fabric8.io/exposeExternalIP"
+
+// ExternalIPStrategy is a strategy that assigns external IPs from a pool to the services
+type ExternalIPStrategy struct {
+	client    kubernetes.Interface
+	namespace string
+	pool      []string
+	// the ports booked on each IP, with the key of the service using them
+	bookings map[string]map[int32]string
+	// the IP allocated to each service
+	allocated map[string]string
+}
+
+// NewExternalIPStrategy creates a new ExternalIPStrategy
+func NewExternalIPStrategy(client kubernetes.Interface, config *Config) (ExposeStrategy, error) {
+	if len(config.ExternalIPs) == 0 {
+		return nil, errors.New("external ip strategy requires a pool of external IPs")
+	}
+	err := checkIPs("external", config.ExternalIPs)
+	if err != nil {
+		return nil, err
+	}
+	klog.Infof("Using external IPs: %v", config.ExternalIPs)
+
+	return &ExternalIPStrategy{
+		client:    client,
+		namespace: config.Namespace,
+		pool:      config.ExternalIPs,
+		bookings:  map[string]map[int32]string{},
+		allocated: map[string]string{},
+	}, nil
+}
+
+// Sync is called before starting / resyncing
+// Gets the ports already booked on the IPs of the pool
+func (s *ExternalIPStrategy) Sync() error {
+	list, err := s.client.CoreV1().Services(s.namespace).List(metav1.ListOptions{})
+	if err != nil {
+		return errors.Wrap(err, "failed to list services")
+	}
+	s.bookings = map[string]map[int32]string{}
+	s.allocated = map[string]string{}
+	for _, ip := range s.pool {
+		s.bookings[ip] = map[int32]string{}
+	}
+	for index := range list.Items {
+		svc := &list.Items[index]
+		svcKey := fmt.Sprintf("%s/%s", svc.Namespace, svc.Name)
+		for _, ip := range svc.Spec.ExternalIPs {
+			if _, ok := s.bookings[ip]; ok {
+				// also book the ports of services not managed by the controller
+				s.book(ip, svcKey, svc)
+			}
+		}
+		if ip := svc.Annotations[ExposeExternalIPAnnotationKey]; ip != "" {
+			if _, ok := s.bookings[ip]; ok {
+				s.allocated[svcKey] = ip
+			}
+		}
+	}
+	return nil
+}
+
+// HasSynced tells if the strategy is complete
+// Nothing to do
+func (s *ExternalIPStrategy) HasSynced() bool {
+	return true
+}
+
+// Add is called when an exposed service is created or updated
+// Allocates an external IP and updates various annotations
+func (s *ExternalIPStrategy) Add(svc *v1.Service) error {
+	svcKey := fmt.Sprintf("%s/%s", svc.Namespace, svc.Name)
+	if len(svc.Spec.Ports) == 0 {
+		return errors.Errorf("service %s has no ports specified. External IP strategy requires a port", svcKey)
+	}
+	port, err := getExposePort(svc)
+	if err != nil {
+		return err
+	}
+
+	// keep the current IP if its ports are still free
+	ip := s.allocated[svcKey]
+	if ip == "" {
+		ip = svc.Annotations[ExposeExternalIPAnnotationKey]
+	}
+	if _, ok := s.bookings[ip]; !ok || !s.isFree(ip, svcKey, svc) {
+		ip = ""
+	}
+	s.release(svcKey)
+	if ip == "" {
+		for _, candidate := range s.pool {
+			if s.isFree(candidate, svcKey, svc) {
+				ip = candidate
+				break
+			}
+		}
+	}
+	if ip == "" {
+		return errors.Errorf("no external IP available in the pool for the ports of service %s", svcKey)
+	}
+	s.book(ip, svcKey, svc)
+	s.allocated[svcKey] = ip
+
+	clone := svc.DeepCopy()
+	err = recordOriginalSpec(svc, clone, "externalIPs")
+	if err != nil {
+		return err
+	}
+	previous := svc.Annotations[ExposeExternalIPAnnotationKey]
+	externalIPs := []string{}
+	for _, existing := range clone.Spec.ExternalIPs {
+		if existing != previous && existing != ip {
+			externalIPs = append(externalIPs, existing)
+		}
+	}
+	clone.Spec.ExternalIPs = append(externalIPs, ip)
+	if clone.Annotations == nil {
+		clone.Annotations = map[string]string{}
+	}
+	clone.Annotations[ExposeExternalIPAnnotationKey] = ip
+	hostName := net.JoinHostPort(ip, strconv.Itoa(int(port.Port)))
+	err = addServiceAnnotation(clone, hostName)
+	if err != nil {
+		return errors.Wrap(err, "failed to add service annotation")
+	}
+
+	patch, err := createServicePatch(svc, clone)
+	if err != nil {
+		return errors.Wrap(err, "failed to create patch")
+	}
+	if patch != nil {
+		_, err = s.client.CoreV1().Services(svc.Namespace).
+			Patch(svc.Name, patchType, patch)
+		if err != nil {
+			return errors.Wrap(err, "failed to send patch")
+		}
+	}
+	return nil
+}
+
+// Clean is called when an exposed service is unexposed
+// Releases the external IP and cleans various annotations
+func (s *ExternalIPStrategy) Clean(svc *v1.Service) error {
+	svcKey := fmt.Sprintf("%s/%s", svc.Namespace, svc.Name)
+	s.release(svcKey)
+
+	clone := svc.DeepCopy()
+	removeServiceAnnotation(clone)
+	restored, err := restoreOriginalSpec(clone, "externalIPs")
+	if err != nil {
+		return err
+	}
+	delete(clone.Annotations, ExposeExternalIPAnnotationKey)
+	if ip := svc.Annotations[ExposeExternalIPAnnotationKey]; ip != "" && !restored {
+		externalIPs := []string{}
+		for _, existing := range clone.Spec.ExternalIPs {
+			if existing != ip {
+				externalIPs = append(externalIPs, existing)
+			}
+		}
+		if len(externalIPs) == 0 {
+			externalIPs = nil
+		}
+		clone.Spec.ExternalIPs = externalIPs
+	}
+
+	patch, err := createServicePatch(svc, clone)
+	if err != nil {
+		return errors.Wrap(err, "failed to create patch")
+	}
+	if patch != nil {
+		_, err = s.client.CoreV1().Services(clone.Namespace).
+			Patch(clone.Name, patchType, patch)
+		if err != nil {
+			return errors.Wrap(err, "failed to send patch")
+		}
+	}
+	return nil
+}
+
+// Delete is called when an exposed service is deleted
+// Releases the external IP
+func (s *ExternalIPStrategy) Delete(svc *v1.Service) error {
+	s.release(fmt.Sprintf("%s/%s", svc.Namespace, svc.Name))
+	return nil
+}
+
+// checkIPs checks all the IPs of a pool are valid
+func checkIPs(kind string, ips []string) error {
+	for _, ip := range ips {
+		if net.ParseIP(ip) == nil {
+			return errors.Errorf("invalid %s IP \"%s\"", kind, ip)
+		}
+	}
+	return nil
+}
+
+// isFree tells if all the ports of the service are free on the IP
+func (s *ExternalIPStrategy) isFree(ip, svcKey string, svc *v1.Service) bool {
+	for _, port := range svc.Spec.Ports {
+		if owner, ok := s.bookings[ip][port.Port]; ok && owner != svcKey {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *ExternalIPStrategy) book(ip, svcKey string, svc *v1.Service) {
+	for _, port := range svc.Spec.Ports {
+		s.bookings[ip][port.Port] = svcKey
+	}
+}
+
+// release frees all the ports booked by the service
+func (s *ExternalIPStrategy) release(svcKey string) {
+	for _, ports := range s.bookings {
+		for port, owner := range ports {
+			if owner == svcKey {
+				delete(ports, port)
+			}
+		}
+	}
+	delete(s.allocated, svcKey)
+}
@@ -0,0 +1,130 @@
+package exposestrategy
+
+import (
+	"testing"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newExternalIPService(namespace, name string, ports ...int32) *v1.Service {
+	svc := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   namespace,
+			Name:        name,
+			Annotations: map[string]string{},
+		},
+	}
+	for _, port := range ports {
+		svc.Spec.Ports = append(svc.Spec.Ports, v1.ServicePort{Port: port})
+	}
+	return svc
+}
+
+func TestExternalIPStrategy_New(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	_, err := NewExternalIPStrategy(client, &Config{})
+	assert.Error(t, err, "empty pool")
+	_, err = NewExternalIPStrategy(client, &Config{
+		ExternalIPs: []string{"192.168.1.10", "not-an-ip"},
+	})
+	assert.Error(t, err, "invalid ip")
+	_, err = NewExternalIPStrategy(client, &Config{
+		ExternalIPs: []string{"192.168.1.10", "192.168.1.11"},
+	})
+	assert.NoError(t, err, "valid pool")
+}
+
+func TestExternalIPStrategy_Add(t *testing.T) {
+	// a service not managed by the controller already uses the port 80 of the first IP
+	unmanaged := newExternalIPService("other", "unmanaged", 80)
+	unmanaged.Spec.ExternalIPs = []string{"192.168.1.10"}
+	svc1 := newExternalIPService("ns", "svc1", 80)
+	svc2 := newExternalIPService("ns", "svc2", 8080, 443)
+	svc2.Annotations[ExposePortAnnotationKey] = "443"
+	svc3 := newExternalIPService("ns", "svc3", 80)
+	client := fake.NewSimpleClientset(unmanaged, svc1.DeepCopy(), svc2.DeepCopy(), svc3.DeepCopy())
+
+	strategy, err := NewExternalIPStrategy(client, &Config{
+		ExternalIPs: []string{"192.168.1.10", "192.168.1.11"},
+	})
+	require.NoError(t, err)
+	require.NoError(t, strategy.Sync())
+
+	err = strategy.Add(svc1.DeepCopy())
+	assert.NoError(t, err)
+	svc, err := client.CoreV1().Services("ns").Get("svc1", metav1.GetOptions{})
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"192.168.1.11"}, svc.Spec.ExternalIPs)
+		assert.Equal(t, map[string]string{
+			ExposeExternalIPAnnotationKey: "192.168.1.11",
+			ExposeAnnotationKey:           "http://192.168.1.11:80",
+			OriginalSpecAnnotationKey:     `{"externalIPs":null}`,
+		}, svc.Annotations)
+	}
+
+	err = strategy.Add(svc2.DeepCopy())
+	assert.NoError(t, err)
+	svc, err = client.CoreV1().Services("ns").Get("svc2", metav1.GetOptions{})
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"192.168.1.10"}, svc.Spec.ExternalIPs)
+		assert.Equal(t, "https://192.168.1.10:443", svc.Annotations[ExposeAnnotationKey])
+	}
+
+	// the port 80 is booked on all the IPs
+	err = strategy.Add(svc3.DeepCopy())
+	assert.Error(t, err)
+
+	// adding again keeps the same IP
+	svc, err = client.CoreV1().Services("ns").Get("svc1", metav1.GetOptions{})
+	require.NoError(t, err)
+	err = strategy.Add(svc)
+	assert.NoError(t, err)
+	assert.Equal(t, "192.168.1.11", strategy.(*ExternalIPStrategy).allocated["ns/svc1"])
+
+	// once released, the IP can be used by another service
+	err = strategy.Clean(svc)
+	assert.NoError(t, err)
+	svc, err = client.CoreV1().Services("ns").Get("svc1", metav1.GetOptions{})
+	if assert.NoError(t, err) {
+		assert.Empty(t, svc.Spec.ExternalIPs)
+		assert.Empty(t, svc.Annotations)
+	}
+	err = strategy.Add(svc3.DeepCopy())
+	assert.NoError(t, err)
+	svc, err = client.CoreV1().Services("ns").Get("svc3", metav1.GetOptions{})
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"192.168.1.11"}, svc.Spec.ExternalIPs)
+	}
+}
+
+func TestExternalIPStrategy_Sync(t *testing.T) {
+	svc1 := newExternalIPService("ns", "svc1", 80)
+	svc1.Spec.ExternalIPs = []string{"192.168.1.10"}
+	svc1.Annotations[ExposeExternalIPAnnotationKey] = "192.168.1.10"
+	svc2 := newExternalIPService("ns", "svc2", 80)
+	svc2.Spec.ExternalIPs = []string{"10.0.0.1"}
+	client := fake.NewSimpleClientset(svc1, svc2)
+
+	strategy, err := NewExternalIPStrategy(client, &Config{
+		ExternalIPs: []string{"192.168.1.10", "192.168.1.11"},
+	})
+	require.NoError(t, err)
+	require.NoError(t, strategy.Sync())
+	assert.Equal(t, map[string]map[int32]string{
+		"192.168.1.10": map[int32]string{80: "ns/svc1"},
+		"192.168.1.11": map[int32]string{},
+	}, strategy.(*ExternalIPStrategy).bookings)
+	assert.Equal(t, map[string]string{
+		"ns/svc1": "192.168.1.10",
+	}, strategy.(*ExternalIPStrategy).allocated)
+
+	err = strategy.Delete(svc1)
+	assert.NoError(t, err)
+	assert.Empty(t, strategy.(*ExternalIPStrategy).bookings["192.168.1.10"])
+	assert.Empty(t, strategy.(*ExternalIPStrategy).allocated)
+}
@@ -16,10 +16,11 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
 )
 
 const (
-	// PathModeUsePath path mode tells to use "/<namespace>/name" as path
+	// PathModeUsePath path mode tells to use the domain as host, and the path template as path
 	PathModeUsePath = "path"
 	ServiceAPIVersion = "v1"
 	ServiceKind = "Service"
@@ -36,10 +37,21 @@ type IngressStrategy struct {
 	tlsUseWildcard bool
 	http           bool
 	tlsAcme        bool
-	urltemplate    string
+	urltemplate    *urlTemplate
+	clusterName    string
 	pathMode       string
+	pathTemplate   *urlTemplate
 	ingressClass   string
+	allowedHosts   []string
+	domains        *domainProfiles
 	existing       map[string][]string
+	namespaces     *NamespaceOverrides
+	claims         *HostClaims
+	recorder       record.EventRecorder
+	// annotate is called to add extra annotations to the ingress of a service
+	annotate       func(svc *v1.Service, ingressName string, annotations map[string]string) error
+	// unexpose is called to delete the extra resources of a service in conflict
+	unexpose       func(svc *v1.Service)
 }
 
 // NewIngressStrategy creates a new NewIngressStrategy
@@ -47,19 +59,27 @@ func NewIngressStrategy(client kubernetes.Interface, config *Config) (ExposeStra
 
 	var err error
 	if config.Domain == "" {
-		config.Domain, err = getAutoDefaultDomain(client)
+		config.Domain, err = getAutoDefaultDomain(client, config)
 		if err != nil {
 			return nil, errors.Wrap(err, "failed to get a domain")
 		}
 	}
 	klog.Infof("Using domain: %s", config.Domain)
 
-	var urlformat string
-	urlformat, err = getURLFormat(config.URLTemplate)
+	urltemplate, err := parseURLTemplate(config.URLTemplate)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to get a url format")
+		return nil, err
+	}
+	pathtemplate, err := parsePathTemplate(config.PathTemplate)
+	if err != nil {
+		return nil, err
+	}
+	klog.Infof("Using url template [%s]", urltemplate.source())
+
+	domains, err := getDomainProfiles(config)
+	if err != nil {
+		return nil, err
 	}
-	klog.Infof("Using url template [%s] format [%s]", config.URLTemplate, urlformat)
 
 	return &IngressStrategy{
 		client:         client,
@@ -71,12 +91,70 @@ func NewIngressStrategy(client kubernetes.Interface, config *Config) (ExposeStra
 		tlsAcme:        config.TLSAcme,
 		tlsSecretName:  config.TLSSecretName,
 		tlsUseWildcard: config.TLSUseWildcard,
-		urltemplate:    urlformat,
+		urltemplate:    urltemplate,
+		clusterName:    config.ClusterName,
 		pathMode:       config.PathMode,
+		pathTemplate:   pathtemplate,
 		ingressClass:   config.IngressClass,
+		allowedHosts:   config.AllowedHosts,
+		domains:        domains,
+		namespaces:     config.Namespaces,
+		claims:         config.Claims,
+		recorder:       config.Recorder,
 	}, nil
 }
 
+// forService returns the strategy with the settings overridden for the service
+func (s *IngressStrategy) forService(svc *v1.Service) *IngressStrategy {
+	settings, ok := s.namespaces.Apply(svc, NamespaceSettings{
+		Domain:         s.domain,
+		InternalDomain: s.internalDomain,
+		TLSSecretName:  s.tlsSecretName,
+		TLSUseWildcard: s.tlsUseWildcard,
+		TLSAcme:        s.tlsAcme,
+		HTTP:           s.http,
+		URLTemplate:    s.urltemplate.source(),
+		PathMode:       s.pathMode,
+		IngressClass:   s.ingressClass,
+		AllowedHosts:   s.allowedHosts,
+	})
+	if !ok {
+		return s
+	}
+	clone := *s
+	clone.domain = settings.Domain
+	clone.internalDomain = settings.InternalDomain
+	clone.tlsSecretName = settings.TLSSecretName
+	clone.tlsUseWildcard = settings.TLSUseWildcard
+	clone.tlsAcme = settings.TLSAcme
+	clone.http = settings.HTTP
+	clone.urltemplate = s.urltemplate.reparse(settings.URLTemplate)
+	clone.pathMode = settings.PathMode
+	clone.ingressClass = settings.IngressClass
+	clone.allowedHosts = settings.AllowedHosts
+	return &clone
+}
+
+// forDomain returns the strategy with the settings of the named domain of the service, if any
+func (s *IngressStrategy) forDomain(svc *v1.Service) (*IngressStrategy, error) {
+	profile, err := s.domains.choose(svc, s.namespaces.Labels(svc.Namespace))
+	if err != nil || profile == nil {
+		return s, err
+	}
+	clone := *s
+	clone.domain = profile.Domain
+	if profile.TLSSecretName != "" {
+		clone.tlsSecretName = profile.TLSSecretName
+	}
+	if profile.IngressClass != "" {
+		clone.ingressClass = profile.IngressClass
+	}
+	if profile.urlTemplate != nil {
+		clone.urltemplate = profile.urlTemplate
+	}
+	return &clone, nil
+}
+
 // CleanIngressStrategy deletes all the ingresses created by the controller
 func CleanIngressStrategy(client kubernetes.Interface, namespace string) error {
 	// list all existing ingresses
@@ -146,15 +224,12 @@ func (s *IngressStrategy) HasSynced() bool {
 // Creates or updates the related ingress, and deletes the others
 // Updates various service annotations
 func (s *IngressStrategy) Add(svc *v1.Service) error {
-	// choose the name of the ingress
-	appName := svc.Annotations["fabric8.io/ingress.name"]
-	if appName == "" {
-		if svc.Labels["release"] != "" {
-			appName = strings.TrimPrefix(svc.Name, svc.Labels["release"]+"-")
-		} else {
-			appName = svc.Name
-		}
+	s, err := s.forService(svc).forDomain(svc)
+	if err != nil {
+		return err
 	}
+	// choose the name of the ingress
+	appName := getAppName(svc)
 	ingressName := appName
 	if s.namePrefix != "" {
 		if strings.HasSuffix(s.namePrefix, "-") || strings.HasSuffix(s.namePrefix, ".") {
@@ -164,32 +239,29 @@ func (s *IngressStrategy) Add(svc *v1.Service) error {
 		}
 	}
 	// choose the hostname and path of the ingress
-	hostName := svc.Annotations["fabric8.io/host.name"]
-	if hostName == "" {
-		hostName = appName
-	}
 	domain := s.domain
-	if svc.Annotations["fabric8.io/use.internal.domain"] == "true" {
+	if svc.Annotations["fabric8.io/use.internal.domain"] == "true" && svc.Annotations[DomainAnnotationKey] == "" {
 		domain = s.internalDomain
 	}
-	hostName = fmt.Sprintf(s.urltemplate, hostName, svc.Namespace, domain)
+	hostName, path, err := getHostAndPath(svc, appName, s.urltemplate, s.pathTemplate, domain, s.clusterName, s.pathMode)
+	if err != nil {
+		return err
+	}
 	tlsHostName := hostName
 	if s.tlsUseWildcard {
 		tlsHostName = "*." + domain
 	}
-	path := svc.Annotations["fabric8.io/ingress.path"]
-	pathMode := svc.Annotations["fabric8.io/path.mode"]
-	if pathMode == "" {
-		pathMode = s.pathMode
+	pathMode := getPathMode(svc, s.pathMode)
+	if err := checkAllowedHost(hostName, s.allowedHosts); err != nil {
+		return errors.Wrapf(err, "failed to expose service %s/%s", svc.Namespace, svc.Name)
 	}
-	if pathMode == PathModeUsePath {
-		if path == "" {
-			path = "/"
+	if err := s.claims.Claim(svc, "ingress", hostName, path); err != nil {
+		// keeps the claim, to be exposed once the winner goes away
+		s.deleteIngresses(svc)
+		if s.unexpose != nil {
+			s.unexpose(svc)
 		}
-		path = URLJoin("/", svc.Namespace, appName, path)
-		hostName = domain
-	} else if path != "" && path[0] != '/' {
-		path = "/" + path
+		return reportHostConflict(s.client, s.recorder, svc, err)
 	}
 	// choose the target port
 	exposePort := svc.Annotations[ExposePortAnnotationKey]
@@ -261,6 +333,12 @@ func (s *IngressStrategy) Add(svc *v1.Service) error {
 				svc.Namespace, svc.Name)
 		}
 	}
+	if s.annotate != nil {
+		err := s.annotate(svc, ingressName, ingressAnnotations)
+		if err != nil {
+			return err
+		}
+	}
 	// that annotations is important and cannot be overridden
 	ingressAnnotations["fabric8.io/generated-by"] = "exposecontroller"
 	// build the ingress
@@ -351,6 +429,7 @@ func (s *IngressStrategy) Add(svc *v1.Service) error {
 	}
 	// build the patch for the service annotations
 	clone := svc.DeepCopy()
+	clearHostConflict(clone)
 	if !s.http && tlsSecretName != "" {
 		err = addServiceAnnotationWithProtocol(clone, hostName, path, "https")
 	} else {
@@ -383,20 +462,8 @@ func (s *IngressStrategy) Add(svc *v1.Service) error {
 // Deletes the related ingress
 // Cleans various ingress annotations
 func (s *IngressStrategy) Clean(svc *v1.Service) error {
-	svcKey := fmt.Sprintf("%s/%s", svc.Namespace, svc.Name)
-	for _, name := range s.existing[svcKey] {
-		existing, err := s.client.ExtensionsV1beta1().Ingresses(svc.Namespace).Get(name, metav1.GetOptions{})
-		if err == nil {
-			exKey, del := getIngressService(existing)
-			if del || exKey == svcKey {
-				deleteIngress(s.client, existing)
-			}
-		} else if !apierrors.IsNotFound(err) {
-			klog.Errorf("error when getting ingress %s/%s: %s",
-				svc.Namespace, name, err)
-		}
-	}
-	delete(s.existing, svcKey)
+	s.deleteIngresses(svc)
+	s.claims.Release(svc, "ingress")
 
 	clone := svc.DeepCopy()
 	if !removeServiceAnnotation(clone) {
@@ -423,6 +490,13 @@ func (s *IngressStrategy) Clean(svc *v1.Service) error {
 // Delete is called when an exposed service is deleted
 // Delete the related ingresses
 func (s *IngressStrategy) Delete(svc *v1.Service) error {
+	s.deleteIngresses(svc)
+	s.claims.Release(svc, "ingress")
+	return nil
+}
+
+// deleteIngresses deletes the ingresses of the service
+func (s *IngressStrategy) deleteIngresses(svc *v1.Service) {
 	svcKey := fmt.Sprintf("%s/%s", svc.Namespace, svc.Name)
 	for _, name := range s.existing[svcKey] {
 		existing, err := s.client.ExtensionsV1beta1().Ingresses(svc.Namespace).Get(name, metav1.GetOptions{})
@@ -437,8 +511,6 @@ func (s *IngressStrategy) Delete(svc *v1.Service) error {
 		}
 	}
 	delete(s.existing, svcKey)
-
-	return nil
 }
 
 func deleteIngress(client kubernetes.Interface, ingress *v1beta1.Ingress) {
@@ -456,13 +528,5 @@ func deleteIngress(client kubernetes.Interface, ingress *v1beta1.Ingress) {
 }
 
 func getIngressService(ingress *v1beta1.Ingress) (string, bool) {
-	if ingress.Labels["provider"] != "fabric8" || ingress.Annotations["fabric8.io/generated-by"] != "exposecontroller" {
-		return "", false
-	} else if len(ingress.OwnerReferences) != 1 {
-		return "", true
-	} else if owner := ingress.OwnerReferences[0]; owner.Kind != ServiceKind || owner.APIVersion != ServiceAPIVersion {
-		return "", true
-	} else {
-		return fmt.Sprintf("%s/%s", ingress.Namespace, owner.Name), false
-	}
+	return getOwnerService(ingress)
 }
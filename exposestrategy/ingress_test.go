@@ -386,7 +386,6 @@ func TestIngressStrategy_Add(t *testing.T) {
 		client:         client,
 		namespace:      "main",
 		domain:         "my-domain.com",
-		urltemplate:    "%[1]s.%[2]s.%[3]s",
 		existing: map[string][]string{
 			"main/source": []string{
 				"ingress1",
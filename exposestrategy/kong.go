@@ -0,0 +1,200 @@
+package exposestrategy
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+	"k8s.io/klog"
+
+	"k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// KongPluginsAnnotationKey annotation holds the kong plugins to attach to the service, YAML format
+	KongPluginsAnnotationKey = "fabric8.io/kong.plugins"
+	// KongIngressPluginsAnnotationKey annotation tells kong which plugins to use for an ingress
+	KongIngressPluginsAnnotationKey = "konghq.com/plugins"
+	// KongPluginAPIVersion is the API version of the KongPlugin resources
+	KongPluginAPIVersion = "configuration.konghq.com/v1"
+	// KongPluginKind is the kind of the KongPlugin resources
+	KongPluginKind = "KongPlugin"
+)
+
+var kongPlugins = schema.GroupVersionResource{
+	Group:    "configuration.konghq.com",
+	Version:  "v1",
+	Resource: "kongplugins",
+}
+
+// KongStrategy is a strategy that creates ingresses for the kong ingress controller
+// and the KongPlugin resources attached to them
+type KongStrategy struct {
+	*IngressStrategy
+	dynamic dynamic.Interface
+	// the plugins created for each service
+	plugins map[string][]string
+}
+
+// NewKongStrategy creates a new KongStrategy
+func NewKongStrategy(client kubernetes.Interface, config *Config) (ExposeStrategy, error) {
+	if config.DynamicClient == nil {
+		return nil, errors.New("kong strategy requires a dynamic client")
+	}
+	ingressConfig := *config
+	if ingressConfig.IngressClass == "" {
+		ingressConfig.IngressClass = "kong"
+	}
+	strategy, err := NewIngressStrategy(client, &ingressConfig)
+	if err != nil {
+		return nil, err
+	}
+	s := &KongStrategy{
+		IngressStrategy: strategy.(*IngressStrategy),
+		dynamic:         config.DynamicClient,
+		plugins:         map[string][]string{},
+	}
+	s.IngressStrategy.annotate = s.applyPlugins
+	s.IngressStrategy.unexpose = func(svc *v1.Service) {
+		s.cleanPlugins(svc, nil)
+	}
+	return s, nil
+}
+
+// Sync is called before starting / resyncing
+// Gets the current list of all ingresses and plugins created by the controller
+func (s *KongStrategy) Sync() error {
+	err := s.IngressStrategy.Sync()
+	if err != nil {
+		return err
+	}
+	selector, err := metav1.LabelSelectorAsSelector(&metav1.LabelSelector{
+		MatchLabels: map[string]string{"provider": "fabric8"},
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to build selector")
+	}
+	listOptions := metav1.ListOptions{
+		LabelSelector: selector.String(),
+	}
+	list, err := s.dynamic.Resource(kongPlugins).Namespace(s.namespace).List(listOptions)
+	if err != nil {
+		return errors.Wrap(err, "failed to list kong plugins")
+	}
+	// check which service is referencing each plugin
+	plugins := map[string][]string{}
+	for index := range list.Items {
+		plugin := &list.Items[index]
+		svc, del := getOwnerService(plugin)
+		if del {
+			deleteResource(s.dynamic.Resource(kongPlugins).Namespace(plugin.GetNamespace()), plugin)
+		} else if svc != "" {
+			plugins[svc] = append(plugins[svc], plugin.GetName())
+		}
+	}
+	s.plugins = plugins
+	return nil
+}
+
+// Clean is called when an exposed service is unexposed
+// Deletes the related ingress and plugins
+// Cleans various ingress annotations
+func (s *KongStrategy) Clean(svc *v1.Service) error {
+	s.cleanPlugins(svc, nil)
+	return s.IngressStrategy.Clean(svc)
+}
+
+// Delete is called when an exposed service is deleted
+// Delete the related ingresses and plugins
+func (s *KongStrategy) Delete(svc *v1.Service) error {
+	s.cleanPlugins(svc, nil)
+	return s.IngressStrategy.Delete(svc)
+}
+
+// applyPlugins creates or updates the plugins of the service
+// and references them in the annotations of the ingress
+func (s *KongStrategy) applyPlugins(svc *v1.Service, ingressName string, annotations map[string]string) error {
+	configs := map[string]interface{}{}
+	pluginsString := svc.Annotations[KongPluginsAnnotationKey]
+	if pluginsString != "" {
+		err := yaml.Unmarshal([]byte(pluginsString), &configs)
+		if err != nil {
+			return errors.Wrapf(err, "failed to parse annotation \"%s\" in service %s/%s",
+				KongPluginsAnnotationKey, svc.Namespace, svc.Name)
+		}
+	}
+
+	objects := make([]*unstructured.Unstructured, 0, len(configs))
+	for plugin, config := range configs {
+		content := map[string]interface{}{
+			"plugin": plugin,
+		}
+		if config != nil {
+			config = normalizeYAMLValue(config)
+			if _, ok := config.(map[string]interface{}); !ok {
+				return errors.Errorf("the config of the kong plugin \"%s\" in annotation \"%s\" of service %s/%s is not an object",
+					plugin, KongPluginsAnnotationKey, svc.Namespace, svc.Name)
+			}
+			content["config"] = config
+		}
+		objects = append(objects, newOwnedResource(svc, KongPluginAPIVersion, KongPluginKind, ingressName+"-"+plugin, content))
+	}
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].GetName() < objects[j].GetName()
+	})
+
+	keep := map[string]bool{}
+	for _, object := range objects {
+		keep[object.GetName()] = true
+	}
+	s.cleanPlugins(svc, keep)
+	svcKey := fmt.Sprintf("%s/%s", svc.Namespace, svc.Name)
+	names := make([]string, 0, len(objects))
+	for _, object := range objects {
+		s.plugins[svcKey] = append(s.plugins[svcKey], object.GetName())
+		err := applyResource(s.dynamic.Resource(kongPlugins).Namespace(svc.Namespace), svc, object)
+		if err != nil {
+			return err
+		}
+		names = append(names, object.GetName())
+	}
+
+	if len(names) > 0 {
+		// keep the plugins configured through fabric8.io/ingress.annotations
+		if existing := annotations[KongIngressPluginsAnnotationKey]; existing != "" {
+			names = append([]string{existing}, names...)
+		}
+		annotations[KongIngressPluginsAnnotationKey] = strings.Join(names, ",")
+	}
+	return nil
+}
+
+// cleanPlugins deletes the plugins of the service that should not be kept
+func (s *KongStrategy) cleanPlugins(svc *v1.Service, keep map[string]bool) {
+	svcKey := fmt.Sprintf("%s/%s", svc.Namespace, svc.Name)
+	client := s.dynamic.Resource(kongPlugins).Namespace(svc.Namespace)
+	for _, name := range s.plugins[svcKey] {
+		if keep[name] {
+			continue
+		}
+		existing, err := client.Get(name, metav1.GetOptions{})
+		if err == nil {
+			exKey, del := getOwnerService(existing)
+			if del || exKey == svcKey {
+				deleteResource(client, existing)
+			}
+		} else if !apierrors.IsNotFound(err) {
+			klog.Errorf("error when getting kong plugin %s/%s: %s",
+				svc.Namespace, name, err)
+		}
+	}
+	delete(s.plugins, svcKey)
+}
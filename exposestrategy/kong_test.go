@@ -0,0 +1,192 @@
+package exposestrategy
+
+import (
+	"testing"
+
+	"k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKongStrategy_Sync(t *testing.T) {
+	newPlugin := func(name, owner string, generated bool) runtime.Object {
+		object := &unstructured.Unstructured{
+			Object: map[string]interface{}{
+				"plugin": "cors",
+			},
+		}
+		object.SetAPIVersion(KongPluginAPIVersion)
+		object.SetKind(KongPluginKind)
+		object.SetNamespace("main")
+		object.SetName(name)
+		object.SetLabels(map[string]string{"provider": "fabric8"})
+		if generated {
+			object.SetAnnotations(map[string]string{"fabric8.io/generated-by": "exposecontroller"})
+		}
+		if owner != "" {
+			object.SetOwnerReferences([]metav1.OwnerReference{{
+				APIVersion: "v1",
+				Kind:       "Service",
+				Name:       owner,
+			}})
+		}
+		return object
+	}
+	dynamicClient := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(),
+		newPlugin("plugin1", "svc1", true),
+		newPlugin("plugin2", "", true),
+		newPlugin("plugin3", "", false),
+	)
+	strategy, err := NewKongStrategy(fake.NewSimpleClientset(), &Config{
+		Domain:        "my-domain.com",
+		Namespace:     "main",
+		DynamicClient: dynamicClient,
+	})
+	require.NoError(t, err)
+	err = strategy.Sync()
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string][]string{
+		"main/svc1": []string{"plugin1"},
+	}, strategy.(*KongStrategy).plugins)
+	_, err = dynamicClient.Resource(kongPlugins).Namespace("main").Get("plugin2", metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err), "orphan plugin deleted")
+	_, err = dynamicClient.Resource(kongPlugins).Namespace("main").Get("plugin3", metav1.GetOptions{})
+	assert.NoError(t, err, "unmanaged plugin kept")
+}
+
+func TestKongStrategy_Add(t *testing.T) {
+	svc := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "main",
+			Name:      "svc",
+			UID:       "svc-uid",
+			Annotations: map[string]string{
+				"fabric8.io/ingress.annotations": "konghq.com/plugins: global-auth",
+				KongPluginsAnnotationKey: `
+rate-limiting:
+  minute: 5
+  policy: local
+key-auth: {}
+cors:
+  origins: ["https://my-domain.com"]
+`,
+			},
+		},
+		Spec: v1.ServiceSpec{
+			Ports: []v1.ServicePort{{
+				Port: 80,
+			}},
+		},
+	}
+	client := fake.NewSimpleClientset(svc.DeepCopy())
+	dynamicClient := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme())
+	strategy, err := NewKongStrategy(client, &Config{
+		Domain:        "my-domain.com",
+		Namespace:     "main",
+		DynamicClient: dynamicClient,
+	})
+	require.NoError(t, err)
+	require.NoError(t, strategy.Sync())
+
+	err = strategy.Add(svc.DeepCopy())
+	require.NoError(t, err)
+
+	ingress, err := client.ExtensionsV1beta1().Ingresses("main").Get("svc", metav1.GetOptions{})
+	if assert.NoError(t, err, "ingress") {
+		assert.Equal(t, "kong", ingress.Annotations["kubernetes.io/ingress.class"])
+		assert.Equal(t, "global-auth,svc-cors,svc-key-auth,svc-rate-limiting",
+			ingress.Annotations[KongIngressPluginsAnnotationKey])
+	}
+	plugin, err := dynamicClient.Resource(kongPlugins).Namespace("main").Get("svc-rate-limiting", metav1.GetOptions{})
+	if assert.NoError(t, err, "plugin") {
+		assert.Equal(t, "rate-limiting", plugin.Object["plugin"])
+		assert.Equal(t, map[string]interface{}{
+			"minute": int64(5),
+			"policy": "local",
+		}, plugin.Object["config"])
+		owner, del := getOwnerService(plugin)
+		assert.Equal(t, "main/svc", owner)
+		assert.False(t, del)
+	}
+	plugin, err = dynamicClient.Resource(kongPlugins).Namespace("main").Get("svc-cors", metav1.GetOptions{})
+	if assert.NoError(t, err, "plugin") {
+		assert.Equal(t, map[string]interface{}{
+			"origins": []interface{}{"https://my-domain.com"},
+		}, plugin.Object["config"])
+	}
+
+	// the fake client doesn't set the resource version
+	ingress.ResourceVersion = "1"
+	_, err = client.ExtensionsV1beta1().Ingresses("main").Update(ingress)
+	require.NoError(t, err)
+
+	// removed plugins are deleted
+	updated := svc.DeepCopy()
+	updated.Annotations[KongPluginsAnnotationKey] = "key-auth: {}"
+	err = strategy.Add(updated)
+	require.NoError(t, err)
+	list, err := dynamicClient.Resource(kongPlugins).Namespace("main").List(metav1.ListOptions{})
+	if assert.NoError(t, err, "plugins") {
+		names := []string{}
+		for _, item := range list.Items {
+			names = append(names, item.GetName())
+		}
+		assert.Equal(t, []string{"svc-key-auth"}, names)
+	}
+
+	err = strategy.Clean(updated)
+	require.NoError(t, err)
+	list, err = dynamicClient.Resource(kongPlugins).Namespace("main").List(metav1.ListOptions{})
+	if assert.NoError(t, err, "plugins") {
+		assert.Empty(t, list.Items)
+	}
+	_, err = client.ExtensionsV1beta1().Ingresses("main").Get("svc", metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err), "ingress deleted")
+
+	updated.Annotations[KongPluginsAnnotationKey] = "cors: [invalid]"
+	err = strategy.Add(updated)
+	assert.Error(t, err, "invalid config")
+}
+
+func TestKongStrategy_HostConflict(t *testing.T) {
+	svc := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:         "main",
+			Name:              "svc",
+			UID:               "svc-uid",
+			CreationTimestamp: metav1.Unix(100, 0),
+			Annotations:       map[string]string{KongPluginsAnnotationKey: "key-auth: {}"},
+		},
+		Spec: v1.ServiceSpec{Ports: []v1.ServicePort{{Port: 80}}},
+	}
+	client := fake.NewSimpleClientset(svc.DeepCopy())
+	dynamicClient := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme())
+	claims := NewHostClaims()
+	strategy, err := NewKongStrategy(client, &Config{
+		Domain:        "my-domain.com",
+		Namespace:     "main",
+		DynamicClient: dynamicClient,
+		Claims:        claims,
+	})
+	require.NoError(t, err)
+	require.NoError(t, strategy.Sync())
+	require.NoError(t, strategy.Add(svc.DeepCopy()))
+	_, err = dynamicClient.Resource(kongPlugins).Namespace("main").Get("svc-key-auth", metav1.GetOptions{})
+	require.NoError(t, err)
+
+	// an older service takes the host
+	require.NoError(t, claims.Claim(claimingService("first", "svc", 50), "ingress", "svc.main.my-domain.com", "/"))
+	assert.Error(t, strategy.Add(svc.DeepCopy()))
+	_, err = client.ExtensionsV1beta1().Ingresses("main").Get("svc", metav1.GetOptions{})
+	assert.Error(t, err, "ingress")
+	_, err = dynamicClient.Resource(kongPlugins).Namespace("main").Get("svc-key-auth", metav1.GetOptions{})
+	assert.Error(t, err, "plugin")
+}
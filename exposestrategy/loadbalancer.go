@@ -2,50 +2,123 @@ package exposestrategy
 
 import (
 	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/pkg/errors"
+	"k8s.io/klog"
 
 	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 )
 
 // LoadBalancerStrategy is a strategy that changes the type of services to LoadBalancer
 type LoadBalancerStrategy struct {
-	client kubernetes.Interface
-	// The services to wait for their load balancer IP
-	todo   map[string]bool
+	client  kubernetes.Interface
+	// The time to wait for a load balancer to be provisioned, 0 to wait forever
+	timeout time.Duration
+	lock    sync.Mutex
+	// The services to wait for their load balancer address, with the time they started waiting
+	todo    map[string]time.Time
+	// The default profile and the available profiles
+	profile  string
+	profiles map[string]LoadBalancerProfile
+	// The pool of IPs to allocate to the services, nil if not configured
+	pool *loadBalancerIPPool
 }
 
 // NewLoadBalancerStrategy a new LoadBalancerStrategy
 func NewLoadBalancerStrategy(client kubernetes.Interface, config *Config) (ExposeStrategy, error) {
+	profiles, err := getLoadBalancerProfiles(config)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := newLoadBalancerIPPool(config)
+	if err != nil {
+		return nil, err
+	}
 	return &LoadBalancerStrategy{
-		client:  client,
+		client:   client,
+		timeout:  config.LoadBalancerTimeout,
+		todo:     map[string]time.Time{},
+		profile:  config.LoadBalancerProfile,
+		profiles: profiles,
+		pool:     pool,
 	}, nil
 }
 
 // Sync is called before starting / resyncing
-// init the todo map
+// init the todo map, loads the IPs allocated from the pool and releases the ones of the deleted services
 func (s *LoadBalancerStrategy) Sync() error {
-	s.todo = map[string]bool{}
-	return nil
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.todo = map[string]time.Time{}
+	if s.pool == nil {
+		return nil
+	}
+	err := s.pool.load(s)
+	if err != nil {
+		return err
+	}
+	return s.pool.prune(s)
 }
 
 // HasSynced tells if the strategy is complete
-// Complete when todo is empty
+// Complete when todo is empty, or only contains services which timed out
 func (s *LoadBalancerStrategy) HasSynced() bool {
-	return len(s.todo) == 0
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, started := range s.todo {
+		if s.timeout <= 0 || time.Since(started) < s.timeout {
+			return false
+		}
+	}
+	return true
 }
 
 // Add is called when an exposed service is created or updated
-// Changes the service type and updates various annotations
-// Adds the service to the todo list if the load balancer IP is unknown
+// Changes the service type, applies the load balancer options and updates various annotations
+// Adds the service to the todo list if the load balancer address is unknown
 func (s *LoadBalancerStrategy) Add(svc *v1.Service) error {
-	delete(s.todo, fmt.Sprintf("%s/%s", svc.Namespace, svc.Name))
+	svcKey := fmt.Sprintf("%s/%s", svc.Namespace, svc.Name)
+	options, err := s.getLoadBalancerOptions(svc)
+	if err != nil {
+		return err
+	}
 
-	var err error
 	clone := svc.DeepCopy()
+	err = recordOriginalSpec(svc, clone, "type")
+	if err != nil {
+		return err
+	}
 	clone.Spec.Type = v1.ServiceTypeLoadBalancer
-	err = addServiceAnnotation(clone, clone.Spec.LoadBalancerIP)
+	err = applyLoadBalancerOptions(svc, clone, options)
+	if err != nil {
+		return err
+	}
+	if s.pool != nil && s.usesPool(svcKey, svc) {
+		s.lock.Lock()
+		ip, err := s.pool.allocate(s, svcKey, svc)
+		s.lock.Unlock()
+		if err != nil {
+			return err
+		}
+		err = recordOriginalSpec(svc, clone, "loadBalancerIP")
+		if err != nil {
+			return err
+		}
+		clone.Spec.LoadBalancerIP = ip
+	}
+	hostName, protocol := getLoadBalancerHostName(svc)
+	if hostName != "" {
+		err = addServiceAnnotationWithProtocol(clone, hostName, "", protocol)
+		delete(clone.Annotations, ExposeStatusAnnotationKey)
+	} else {
+		err = addServiceAnnotation(clone, "")
+	}
 	if err != nil {
 		return errors.Wrap(err, "failed to add service annotation")
 	}
@@ -62,22 +135,120 @@ func (s *LoadBalancerStrategy) Add(svc *v1.Service) error {
 		}
 	}
 
-	if clone.Spec.LoadBalancerIP == "" {
-		s.todo[fmt.Sprintf("%s/%s", svc.Namespace, svc.Name)] = true
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if hostName != "" {
+		delete(s.todo, svcKey)
+	} else if _, ok := s.todo[svcKey]; !ok {
+		s.todo[svcKey] = time.Now()
+		if s.timeout > 0 {
+			namespace, name := svc.Namespace, svc.Name
+			time.AfterFunc(s.timeout, func() {
+				s.expire(namespace, name)
+			})
+		}
 	}
 	return nil
 }
 
+// getLoadBalancerHostName returns the host of the provisioned load balancer, with the port if not the default one,
+// and the protocol to use
+// returns an empty host if the load balancer is not provisioned yet
+func getLoadBalancerHostName(svc *v1.Service) (string, string) {
+	address := ""
+	for _, ingress := range svc.Status.LoadBalancer.Ingress {
+		if ingress.IP != "" {
+			address = ingress.IP
+		} else {
+			address = ingress.Hostname
+		}
+		if address != "" {
+			break
+		}
+	}
+	if address == "" {
+		return "", ""
+	}
+	port, err := getExposePort(svc)
+	if err != nil {
+		return address, findHTTPProtocol(svc, address)
+	}
+	hostName := net.JoinHostPort(address, strconv.Itoa(int(port.Port)))
+	protocol := findHTTPProtocol(svc, hostName)
+	if port.Name == "https" || port.Name == "http" {
+		protocol = port.Name
+	}
+	if (protocol == "http" && port.Port == 80) || (protocol == "https" && port.Port == 443) {
+		hostName = address
+	}
+	return hostName, protocol
+}
+
+// expire reports on the service that its load balancer wasn't provisioned in time
+// The update of the service triggers a new sync check
+func (s *LoadBalancerStrategy) expire(namespace, name string) {
+	svcKey := fmt.Sprintf("%s/%s", namespace, name)
+	s.lock.Lock()
+	started, ok := s.todo[svcKey]
+	s.lock.Unlock()
+	if !ok || time.Since(started) < s.timeout {
+		return
+	}
+	klog.Warningf("the load balancer of service %s was not provisioned after %s", svcKey, s.timeout)
+
+	svc, err := s.client.CoreV1().Services(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		klog.Errorf("failed to get service %s: %v", svcKey, err)
+		return
+	}
+	clone := svc.DeepCopy()
+	if clone.Annotations == nil {
+		clone.Annotations = map[string]string{}
+	}
+	clone.Annotations[ExposeStatusAnnotationKey] = fmt.Sprintf("Timeout: load balancer not provisioned after %s", s.timeout)
+	patch, err := createServicePatch(svc, clone)
+	if err == nil && patch != nil {
+		_, err = s.client.CoreV1().Services(namespace).
+			Patch(name, patchType, patch)
+	}
+	if err != nil {
+		klog.Errorf("failed to report the status of service %s: %v", svcKey, err)
+	}
+}
+
 // Clean is called when an exposed service is unexposed
-// Restores the service type and cleans various annotations
-// Clears the service form the todo list
+// Restores the service spec, removes the load balancer options and cleans various annotations
+// Clears the service form the todo list and returns its IP to the pool
 func (s *LoadBalancerStrategy) Clean(svc *v1.Service) error {
-	delete(s.todo, fmt.Sprintf("%s/%s", svc.Namespace, svc.Name))
+	svcKey := fmt.Sprintf("%s/%s", svc.Namespace, svc.Name)
+	s.lock.Lock()
+	delete(s.todo, svcKey)
+	var err error
+	if s.pool != nil {
+		err = s.pool.release(s, svcKey)
+	}
+	s.lock.Unlock()
+	if err != nil {
+		return err
+	}
 	clone := svc.DeepCopy()
-	if !removeServiceAnnotation(clone) {
+	removed := removeServiceAnnotation(clone)
+	delete(clone.Annotations, ExposeStatusAnnotationKey)
+	removeLoadBalancerOptions(clone)
+	typeRestored, err := restoreOriginalSpec(clone, "type")
+	if err != nil {
+		return err
+	}
+	restored, err := restoreOriginalSpec(clone, "loadBalancerSourceRanges", "externalTrafficPolicy", "loadBalancerIP")
+	if err != nil {
+		return err
+	}
+	if !removed && !typeRestored && !restored {
 		return nil
 	}
-	clone.Spec.Type = v1.ServiceTypeClusterIP
+	if !typeRestored {
+		clone.Spec.Type = v1.ServiceTypeClusterIP
+	}
 
 	patch, err := createServicePatch(svc, clone)
 	if err != nil {
@@ -95,9 +266,34 @@ func (s *LoadBalancerStrategy) Clean(svc *v1.Service) error {
 }
 
 // Delete is called when an exposed service is deleted
-// Clears the service form the todo list
+// Clears the service form the todo list and returns its IP to the pool
 func (s *LoadBalancerStrategy) Delete(svc *v1.Service) error {
-	delete(s.todo, fmt.Sprintf("%s/%s", svc.Namespace, svc.Name))
+	svcKey := fmt.Sprintf("%s/%s", svc.Namespace, svc.Name)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.todo, svcKey)
+	if s.pool != nil {
+		return s.pool.release(s, svcKey)
+	}
 
 	return nil
 }
+
+// usesPool tells if the service should get an IP from the pool
+// The services requesting their own IP outside of the pool are left alone
+func (s *LoadBalancerStrategy) usesPool(svcKey string, svc *v1.Service) bool {
+	if svc.Spec.LoadBalancerIP == "" {
+		return true
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.pool.allocations[svcKey]; ok {
+		return true
+	}
+	for _, ip := range s.pool.ips {
+		if ip == svc.Spec.LoadBalancerIP {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,279 @@
+package exposestrategy
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"k8s.io/klog"
+
+	"k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// LoadBalancerSharedIPAnnotationKey annotation lets the services with the same key share an IP of the pool, if their ports are disjoint
+	LoadBalancerSharedIPAnnotationKey = "fabric8.io/loadbalancer.sharedIP"
+	// metalLBSharedIPAnnotationKey annotation tells MetalLB that services can share their IP
+	metalLBSharedIPAnnotationKey = "metallb.universe.tf/allow-shared-ip"
+	// DefaultLoadBalancerIPLedger is the name of the ConfigMap holding the IPs allocated from the pool
+	DefaultLoadBalancerIPLedger = "exposecontroller-loadbalancer-ips"
+	// loadBalancerIPLedgerKey is the key of the allocations in the ledger, JSON format
+	loadBalancerIPLedgerKey = "allocations.json"
+)
+
+// loadBalancerIPAllocation is an IP of the pool allocated to a service
+type loadBalancerIPAllocation struct {
+	IP     string  `json:"ip"`
+	Shared string  `json:"shared,omitempty"`
+	Ports  []int32 `json:"ports,omitempty"`
+}
+
+// loadBalancerIPPool allocates the IPs of the pool to the services
+// The allocations are persisted in a ConfigMap, so that they survive restarts
+type loadBalancerIPPool struct {
+	ips       []string
+	namespace string
+	name      string
+	// the namespace of the services, all the namespaces if empty
+	watched string
+	// the allocations by service key
+	allocations map[string]*loadBalancerIPAllocation
+}
+
+// newLoadBalancerIPPool creates the pool of IPs from the config, nil if no IPs are configured
+// The ledger is "[namespace/]name", in the namespace of the controller by default
+func newLoadBalancerIPPool(config *Config) (*loadBalancerIPPool, error) {
+	if len(config.LoadBalancerIPs) == 0 {
+		return nil, nil
+	}
+	err := checkIPs("load balancer", config.LoadBalancerIPs)
+	if err != nil {
+		return nil, err
+	}
+	namespace, name := "", config.LoadBalancerIPLedger
+	if index := strings.Index(name, "/"); index >= 0 {
+		namespace, name = name[:index], name[index+1:]
+	}
+	if name == "" {
+		name = DefaultLoadBalancerIPLedger
+	}
+	if namespace == "" {
+		namespace = config.Namespace
+	}
+	if namespace == "" {
+		namespace = os.Getenv("KUBERNETES_NAMESPACE")
+	}
+	if namespace == "" {
+		namespace = metav1.NamespaceDefault
+	}
+	klog.Infof("Using load balancer IPs: %v, recorded in ConfigMap %s/%s", config.LoadBalancerIPs, namespace, name)
+	return &loadBalancerIPPool{
+		ips:         config.LoadBalancerIPs,
+		namespace:   namespace,
+		name:        name,
+		watched:     config.Namespace,
+		allocations: map[string]*loadBalancerIPAllocation{},
+	}, nil
+}
+
+// load reads the allocations from the ledger
+func (p *loadBalancerIPPool) load(s *LoadBalancerStrategy) error {
+	p.allocations = map[string]*loadBalancerIPAllocation{}
+	cm, err := s.client.CoreV1().ConfigMaps(p.namespace).Get(p.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return errors.Wrapf(err, "failed to get ConfigMap %s/%s", p.namespace, p.name)
+	}
+	if data := cm.Data[loadBalancerIPLedgerKey]; data != "" {
+		err = json.Unmarshal([]byte(data), &p.allocations)
+		if err != nil {
+			return errors.Wrapf(err, "failed to parse ConfigMap %s/%s", p.namespace, p.name)
+		}
+	}
+	return nil
+}
+
+// prune releases the IPs allocated to the services which don't exist anymore
+// The allocations of the services outside of the watched namespace are kept
+func (p *loadBalancerIPPool) prune(s *LoadBalancerStrategy) error {
+	list, err := s.client.CoreV1().Services(p.watched).List(metav1.ListOptions{})
+	if err != nil {
+		return errors.Wrap(err, "failed to list services")
+	}
+	existing := map[string]bool{}
+	for _, svc := range list.Items {
+		existing[svc.Namespace+"/"+svc.Name] = true
+	}
+	pruned := map[string]*loadBalancerIPAllocation{}
+	for svcKey, allocation := range p.allocations {
+		if existing[svcKey] || (p.watched != "" && !strings.HasPrefix(svcKey, p.watched+"/")) {
+			continue
+		}
+		klog.Infof("Releasing load balancer IP %s of deleted service %s", allocation.IP, svcKey)
+		pruned[svcKey] = allocation
+		delete(p.allocations, svcKey)
+	}
+	if len(pruned) == 0 {
+		return nil
+	}
+	err = p.save(s)
+	if err != nil {
+		for svcKey, allocation := range pruned {
+			p.allocations[svcKey] = allocation
+		}
+		return err
+	}
+	return nil
+}
+
+// save writes the allocations to the ledger, creating it if needed
+func (p *loadBalancerIPPool) save(s *LoadBalancerStrategy) error {
+	data, err := json.Marshal(p.allocations)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode the load balancer IP allocations")
+	}
+	cm, err := s.client.CoreV1().ConfigMaps(p.namespace).Get(p.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		cm = &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: p.namespace,
+				Name:      p.name,
+				Labels: map[string]string{
+					"provider": "fabric8",
+				},
+				Annotations: map[string]string{
+					"fabric8.io/generated-by": "exposecontroller",
+				},
+			},
+			Data: map[string]string{loadBalancerIPLedgerKey: string(data)},
+		}
+		_, err = s.client.CoreV1().ConfigMaps(p.namespace).Create(cm)
+		if err != nil {
+			return errors.Wrapf(err, "failed to create ConfigMap %s/%s", p.namespace, p.name)
+		}
+		return nil
+	} else if err != nil {
+		return errors.Wrapf(err, "failed to get ConfigMap %s/%s", p.namespace, p.name)
+	}
+	if cm.Data[loadBalancerIPLedgerKey] == string(data) {
+		return nil
+	}
+	if cm.Data == nil {
+		cm.Data = map[string]string{}
+	}
+	cm.Data[loadBalancerIPLedgerKey] = string(data)
+	_, err = s.client.CoreV1().ConfigMaps(p.namespace).Update(cm)
+	if err != nil {
+		return errors.Wrapf(err, "failed to update ConfigMap %s/%s", p.namespace, p.name)
+	}
+	return nil
+}
+
+// allocate returns the IP of the pool allocated to the service, allocating one if needed
+// The IP stays the same as long as it is compatible with the service
+func (p *loadBalancerIPPool) allocate(s *LoadBalancerStrategy, svcKey string, svc *v1.Service) (string, error) {
+	wanted := &loadBalancerIPAllocation{
+		Shared: svc.Annotations[LoadBalancerSharedIPAnnotationKey],
+	}
+	for _, port := range svc.Spec.Ports {
+		wanted.Ports = append(wanted.Ports, port.Port)
+	}
+
+	current := p.allocations[svcKey]
+	if current != nil && p.isFree(current.IP, svcKey, wanted) {
+		wanted.IP = current.IP
+	} else {
+		// prefer the IPs already shared with the same key
+		for _, ip := range p.ips {
+			if wanted.Shared != "" && p.isShared(ip, svcKey, wanted.Shared) && p.isFree(ip, svcKey, wanted) {
+				wanted.IP = ip
+				break
+			}
+		}
+		for _, ip := range p.ips {
+			if wanted.IP == "" && p.isFree(ip, svcKey, wanted) {
+				wanted.IP = ip
+			}
+		}
+	}
+	if wanted.IP == "" {
+		return "", errors.Errorf("no load balancer IP available in the pool for the ports of service %s", svcKey)
+	}
+
+	if current != nil && current.IP == wanted.IP && current.Shared == wanted.Shared && equalPorts(current.Ports, wanted.Ports) {
+		return wanted.IP, nil
+	}
+	p.allocations[svcKey] = wanted
+	err := p.save(s)
+	if err != nil {
+		if current != nil {
+			p.allocations[svcKey] = current
+		} else {
+			delete(p.allocations, svcKey)
+		}
+		return "", err
+	}
+	return wanted.IP, nil
+}
+
+// release returns the IP allocated to the service to the pool
+func (p *loadBalancerIPPool) release(s *LoadBalancerStrategy, svcKey string) error {
+	current, ok := p.allocations[svcKey]
+	if !ok {
+		return nil
+	}
+	delete(p.allocations, svcKey)
+	err := p.save(s)
+	if err != nil {
+		p.allocations[svcKey] = current
+		return err
+	}
+	return nil
+}
+
+// isFree tells if the IP can be allocated to the service
+// An IP can only be used by several services sharing the same key, and with disjoint ports
+func (p *loadBalancerIPPool) isFree(ip, svcKey string, wanted *loadBalancerIPAllocation) bool {
+	for owner, allocation := range p.allocations {
+		if owner == svcKey || allocation.IP != ip {
+			continue
+		}
+		if wanted.Shared == "" || allocation.Shared != wanted.Shared {
+			return false
+		}
+		for _, port := range allocation.Ports {
+			for _, wantedPort := range wanted.Ports {
+				if port == wantedPort {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+// isShared tells if the IP is already used by other services sharing the key
+func (p *loadBalancerIPPool) isShared(ip, svcKey, shared string) bool {
+	for owner, allocation := range p.allocations {
+		if owner != svcKey && allocation.IP == ip && allocation.Shared == shared {
+			return true
+		}
+	}
+	return false
+}
+
+func equalPorts(a, b []int32) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
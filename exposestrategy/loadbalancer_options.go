@@ -0,0 +1,249 @@
+package exposestrategy
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+	"k8s.io/klog"
+
+	"k8s.io/api/core/v1"
+)
+
+const (
+	// LoadBalancerAnnotationsAnnotationKey annotation holds the annotations to set on the load balancer service, YAML format
+	LoadBalancerAnnotationsAnnotationKey = "fabric8.io/loadbalancer.annotations"
+	// LoadBalancerProfileAnnotationKey annotation selects the load balancer profile of the service
+	LoadBalancerProfileAnnotationKey = "fabric8.io/loadbalancer.profile"
+	// LoadBalancerSourceRangesAnnotationKey annotation sets the source ranges of the load balancer, comma separated
+	LoadBalancerSourceRangesAnnotationKey = "fabric8.io/loadbalancer.sourceRanges"
+	// LoadBalancerTrafficPolicyAnnotationKey annotation sets the external traffic policy of the load balancer
+	LoadBalancerTrafficPolicyAnnotationKey = "fabric8.io/loadbalancer.externalTrafficPolicy"
+	// LoadBalancerManagedAnnotationKey annotation will be created with the annotations set by the controller, comma separated
+	LoadBalancerManagedAnnotationKey = "fabric8.io/loadbalancer.managed"
+	// LoadBalancerOriginalAnnotationKey annotation will be created with the values of the user annotations
+	// overwritten by the controller, JSON format
+	LoadBalancerOriginalAnnotationKey = "fabric8.io/loadbalancer.original"
+)
+
+// LoadBalancerProfile holds provider specific settings of load balancer services
+type LoadBalancerProfile struct {
+	Annotations           map[string]string `yaml:"annotations,omitempty" json:"annotations"`
+	SourceRanges          []string          `yaml:"source-ranges,omitempty" json:"source_ranges"`
+	ExternalTrafficPolicy string            `yaml:"external-traffic-policy,omitempty" json:"external_traffic_policy"`
+}
+
+// DefaultLoadBalancerProfiles are the profiles available without configuration
+var DefaultLoadBalancerProfiles = map[string]LoadBalancerProfile{
+	"aws-nlb": {Annotations: map[string]string{
+		"service.beta.kubernetes.io/aws-load-balancer-type": "nlb",
+	}},
+	"aws-internal": {Annotations: map[string]string{
+		"service.beta.kubernetes.io/aws-load-balancer-internal": "true",
+	}},
+	"aws-nlb-internal": {Annotations: map[string]string{
+		"service.beta.kubernetes.io/aws-load-balancer-type":     "nlb",
+		"service.beta.kubernetes.io/aws-load-balancer-internal": "true",
+	}},
+	"gcp-internal": {Annotations: map[string]string{
+		"networking.gke.io/load-balancer-type": "Internal",
+	}},
+	"azure-internal": {Annotations: map[string]string{
+		"service.beta.kubernetes.io/azure-load-balancer-internal": "true",
+	}},
+}
+
+// getLoadBalancerProfiles merges the configured profiles with the default ones
+// and checks the default profile exists
+func getLoadBalancerProfiles(config *Config) (map[string]LoadBalancerProfile, error) {
+	profiles := map[string]LoadBalancerProfile{}
+	for name, profile := range DefaultLoadBalancerProfiles {
+		profiles[name] = profile
+	}
+	for name, profile := range config.LoadBalancerProfiles {
+		err := checkTrafficPolicy(profile.ExternalTrafficPolicy)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid load balancer profile \"%s\"", name)
+		}
+		profiles[name] = profile
+	}
+	if config.LoadBalancerProfile != "" {
+		if _, ok := profiles[config.LoadBalancerProfile]; !ok {
+			return nil, errors.Errorf("unknown load balancer profile \"%s\"", config.LoadBalancerProfile)
+		}
+	}
+	return profiles, nil
+}
+
+func checkTrafficPolicy(policy string) error {
+	switch v1.ServiceExternalTrafficPolicyType(policy) {
+	case "", v1.ServiceExternalTrafficPolicyTypeLocal, v1.ServiceExternalTrafficPolicyTypeCluster:
+		return nil
+	}
+	return errors.Errorf("invalid external traffic policy \"%s\", must be \"%s\" or \"%s\"", policy,
+		v1.ServiceExternalTrafficPolicyTypeLocal, v1.ServiceExternalTrafficPolicyTypeCluster)
+}
+
+// getLoadBalancerOptions returns the options of the service, from its profile overridden by its annotations
+func (s *LoadBalancerStrategy) getLoadBalancerOptions(svc *v1.Service) (*LoadBalancerProfile, error) {
+	name := s.profile
+	if value := svc.Annotations[LoadBalancerProfileAnnotationKey]; value != "" {
+		name = value
+	}
+	options := &LoadBalancerProfile{Annotations: map[string]string{}}
+	if name != "" {
+		profile, ok := s.profiles[name]
+		if !ok {
+			return nil, errors.Errorf("unknown load balancer profile \"%s\" in service %s/%s", name, svc.Namespace, svc.Name)
+		}
+		for key, value := range profile.Annotations {
+			options.Annotations[key] = value
+		}
+		options.SourceRanges = profile.SourceRanges
+		options.ExternalTrafficPolicy = profile.ExternalTrafficPolicy
+	}
+
+	if annotationsString := svc.Annotations[LoadBalancerAnnotationsAnnotationKey]; annotationsString != "" {
+		annotations := map[string]string{}
+		err := yaml.Unmarshal([]byte(annotationsString), annotations)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse annotation \"%s\" in service %s/%s",
+				LoadBalancerAnnotationsAnnotationKey, svc.Namespace, svc.Name)
+		}
+		for key, value := range annotations {
+			options.Annotations[key] = value
+		}
+	}
+	if value := svc.Annotations[LoadBalancerSourceRangesAnnotationKey]; value != "" {
+		options.SourceRanges = nil
+		for _, sourceRange := range strings.Split(value, ",") {
+			if sourceRange = strings.TrimSpace(sourceRange); sourceRange != "" {
+				options.SourceRanges = append(options.SourceRanges, sourceRange)
+			}
+		}
+	}
+	if value := svc.Annotations[LoadBalancerTrafficPolicyAnnotationKey]; value != "" {
+		err := checkTrafficPolicy(value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid annotation \"%s\" in service %s/%s",
+				LoadBalancerTrafficPolicyAnnotationKey, svc.Namespace, svc.Name)
+		}
+		options.ExternalTrafficPolicy = value
+	}
+	if value := svc.Annotations[LoadBalancerSharedIPAnnotationKey]; value != "" && s.pool != nil {
+		options.Annotations[metalLBSharedIPAnnotationKey] = value
+	}
+	return options, nil
+}
+
+// applyLoadBalancerOptions sets the options on the clone of the service
+// and removes the annotations and spec fields the controller doesn't manage anymore
+// The user annotations overwritten are recorded, to be restored once not managed anymore
+func applyLoadBalancerOptions(svc, clone *v1.Service, options *LoadBalancerProfile) error {
+	if clone.Annotations == nil {
+		clone.Annotations = map[string]string{}
+	}
+	original, err := getOriginalAnnotations(svc)
+	if err != nil {
+		return err
+	}
+	previous := map[string]bool{}
+	for _, key := range getManagedAnnotations(svc) {
+		previous[key] = true
+		if _, ok := options.Annotations[key]; !ok {
+			restoreAnnotation(clone, original, key)
+		}
+	}
+	managed := make([]string, 0, len(options.Annotations))
+	for key, value := range options.Annotations {
+		if existing, ok := clone.Annotations[key]; ok && !previous[key] {
+			original[key] = existing
+		}
+		clone.Annotations[key] = value
+		managed = append(managed, key)
+	}
+	if len(managed) > 0 {
+		sort.Strings(managed)
+		clone.Annotations[LoadBalancerManagedAnnotationKey] = strings.Join(managed, ",")
+	} else {
+		delete(clone.Annotations, LoadBalancerManagedAnnotationKey)
+	}
+	if len(original) > 0 {
+		data, err := json.Marshal(original)
+		if err != nil {
+			return errors.Wrap(err, "failed to encode the original annotations")
+		}
+		clone.Annotations[LoadBalancerOriginalAnnotationKey] = string(data)
+	} else {
+		delete(clone.Annotations, LoadBalancerOriginalAnnotationKey)
+	}
+
+	if len(options.SourceRanges) > 0 {
+		err := recordOriginalSpec(svc, clone, "loadBalancerSourceRanges")
+		if err != nil {
+			return err
+		}
+		clone.Spec.LoadBalancerSourceRanges = options.SourceRanges
+	} else if _, err := restoreOriginalSpec(clone, "loadBalancerSourceRanges"); err != nil {
+		return err
+	}
+	if options.ExternalTrafficPolicy != "" {
+		err := recordOriginalSpec(svc, clone, "externalTrafficPolicy")
+		if err != nil {
+			return err
+		}
+		clone.Spec.ExternalTrafficPolicy = v1.ServiceExternalTrafficPolicyType(options.ExternalTrafficPolicy)
+	} else if _, err := restoreOriginalSpec(clone, "externalTrafficPolicy"); err != nil {
+		return err
+	}
+	return nil
+}
+
+// removeLoadBalancerOptions removes the annotations managed by the controller from the clone of the service
+// and restores the user annotations they overwrote
+// The spec fields are restored with the original spec
+func removeLoadBalancerOptions(clone *v1.Service) {
+	original, err := getOriginalAnnotations(clone)
+	if err != nil {
+		klog.Warningf("Failed to restore the annotations of service %s/%s: %v", clone.Namespace, clone.Name, err)
+	}
+	for _, key := range getManagedAnnotations(clone) {
+		restoreAnnotation(clone, original, key)
+	}
+	delete(clone.Annotations, LoadBalancerManagedAnnotationKey)
+	delete(clone.Annotations, LoadBalancerOriginalAnnotationKey)
+}
+
+// restoreAnnotation restores the original value of the managed annotation, or removes it
+func restoreAnnotation(clone *v1.Service, original map[string]string, key string) {
+	if value, ok := original[key]; ok {
+		clone.Annotations[key] = value
+		delete(original, key)
+	} else {
+		delete(clone.Annotations, key)
+	}
+}
+
+// getOriginalAnnotations returns the user annotations overwritten by the managed ones
+func getOriginalAnnotations(svc *v1.Service) (map[string]string, error) {
+	original := map[string]string{}
+	value := svc.Annotations[LoadBalancerOriginalAnnotationKey]
+	if value == "" {
+		return original, nil
+	}
+	if err := json.Unmarshal([]byte(value), &original); err != nil {
+		return map[string]string{}, errors.Wrapf(err, "failed to parse annotation \"%s\" in service %s/%s",
+			LoadBalancerOriginalAnnotationKey, svc.Namespace, svc.Name)
+	}
+	return original, nil
+}
+
+func getManagedAnnotations(svc *v1.Service) []string {
+	value := svc.Annotations[LoadBalancerManagedAnnotationKey]
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
@@ -2,6 +2,7 @@ package exposestrategy
 
 import (
 	"testing"
+	"time"
 
 	"k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -43,8 +44,9 @@ func TestLoadBalancerStrategy_Add(t *testing.T) {
 				Namespace:   "ns",
 				Name:        "svc",
 				Annotations: map[string]string{
-					"test":              "test",
-					ExposeAnnotationKey: "",
+					"test":                    "test",
+					ExposeAnnotationKey:       "",
+					OriginalSpecAnnotationKey: `{"type":null}`,
 				},
 			},
 			Spec: v1.ServiceSpec{
@@ -62,6 +64,7 @@ func TestLoadBalancerStrategy_Add(t *testing.T) {
 			Annotations: map[string]string{
 				"test": "test",
 				ExposeAnnotationKey: "",
+				OriginalSpecAnnotationKey: `{"type":null}`,
 			},
 		},
 		Spec: v1.ServiceSpec{
@@ -69,6 +72,11 @@ func TestLoadBalancerStrategy_Add(t *testing.T) {
 			ClusterIP:      "my-cluster-ip",
 			LoadBalancerIP: "my-lb-ip",
 		},
+		Status: v1.ServiceStatus{
+			LoadBalancer: v1.LoadBalancerStatus{
+				Ingress: []v1.LoadBalancerIngress{{IP: "my-lb-ip"}},
+			},
+		},
 	})
 	require.NoError(t, err)
 	err = strategy.Add(&v1.Service{
@@ -76,13 +84,19 @@ func TestLoadBalancerStrategy_Add(t *testing.T) {
 			Namespace:   "ns",
 			Name:        "svc",
 			Annotations: map[string]string{
-				ExposeAnnotationKey: "",
+				ExposeAnnotationKey:       "",
+				OriginalSpecAnnotationKey: `{"type":null}`,
 			},
 		},
 		Spec: v1.ServiceSpec{
 			Type:           v1.ServiceTypeLoadBalancer,
 			LoadBalancerIP: "my-lb-ip",
 		},
+		Status: v1.ServiceStatus{
+			LoadBalancer: v1.LoadBalancerStatus{
+				Ingress: []v1.LoadBalancerIngress{{IP: "my-lb-ip"}},
+			},
+		},
 	})
 	assert.NoError(t, err)
 	svc, err = client.CoreV1().Services("ns").Get("svc", metav1.GetOptions{})
@@ -92,8 +106,9 @@ func TestLoadBalancerStrategy_Add(t *testing.T) {
 				Namespace:   "ns",
 				Name:        "svc",
 				Annotations: map[string]string{
-					"test":              "test",
-					ExposeAnnotationKey: "http://my-lb-ip",
+					"test":                    "test",
+					ExposeAnnotationKey:       "http://my-lb-ip",
+					OriginalSpecAnnotationKey: `{"type":null}`,
 				},
 			},
 			Spec: v1.ServiceSpec{
@@ -101,6 +116,11 @@ func TestLoadBalancerStrategy_Add(t *testing.T) {
 				ClusterIP:      "my-cluster-ip",
 				LoadBalancerIP: "my-lb-ip",
 			},
+			Status: v1.ServiceStatus{
+				LoadBalancer: v1.LoadBalancerStatus{
+					Ingress: []v1.LoadBalancerIngress{{IP: "my-lb-ip"}},
+				},
+			},
 		}
 		assert.Equal(t, expected, svc)
 	}
@@ -115,6 +135,7 @@ func TestLoadBalancerStrategy_Clean(t *testing.T) {
 			Annotations: map[string]string{
 				"test": "test",
 				ExposeAnnotationKey: "",
+				OriginalSpecAnnotationKey: `{"type":"ClusterIP"}`,
 			},
 		},
 		Spec: v1.ServiceSpec{
@@ -151,7 +172,8 @@ func TestLoadBalancerStrategy_Clean(t *testing.T) {
 			Namespace:   "ns1",
 			Name:        "svc1",
 			Annotations: map[string]string{
-				ExposeAnnotationKey: "",
+				ExposeAnnotationKey:       "",
+				OriginalSpecAnnotationKey: `{"type":"ClusterIP"}`,
 			},
 		},
 		Spec: v1.ServiceSpec{
@@ -193,7 +215,8 @@ func TestLoadBalancerStrategy_Delete(t *testing.T) {
 			Namespace:   "ns",
 			Name:        "svc",
 			Annotations: map[string]string{
-				ExposeAnnotationKey: "",
+				ExposeAnnotationKey:       "",
+				OriginalSpecAnnotationKey: `{"type":"ClusterIP"}`,
 			},
 		},
 		Spec: v1.ServiceSpec{
@@ -220,3 +243,304 @@ func TestLoadBalancerStrategy_Delete(t *testing.T) {
 	require.NoError(t, err)
 	assert.True(t, strategy.HasSynced(), "usynced")
 }
+
+func TestLoadBalancerStrategy_HostName(t *testing.T) {
+	tests := []struct {
+		name     string
+		ports    []v1.ServicePort
+		ingress  v1.LoadBalancerIngress
+		expected string
+	}{{
+		name:     "ip",
+		ports:    []v1.ServicePort{{Port: 80}},
+		ingress:  v1.LoadBalancerIngress{IP: "1.2.3.4"},
+		expected: "http://1.2.3.4",
+	}, {
+		name:     "hostname",
+		ports:    []v1.ServicePort{{Port: 80}},
+		ingress:  v1.LoadBalancerIngress{Hostname: "abc.elb.amazonaws.com"},
+		expected: "http://abc.elb.amazonaws.com",
+	}, {
+		name:     "other port",
+		ports:    []v1.ServicePort{{Port: 8080}},
+		ingress:  v1.LoadBalancerIngress{IP: "1.2.3.4"},
+		expected: "http://1.2.3.4:8080",
+	}, {
+		name:     "https port",
+		ports:    []v1.ServicePort{{Port: 443}},
+		ingress:  v1.LoadBalancerIngress{IP: "1.2.3.4"},
+		expected: "https://1.2.3.4",
+	}, {
+		name:     "https port name",
+		ports:    []v1.ServicePort{{Name: "https", Port: 8000}},
+		ingress:  v1.LoadBalancerIngress{IP: "1.2.3.4"},
+		expected: "https://1.2.3.4:8000",
+	}}
+	for _, test := range tests {
+		svc := &v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "ns",
+				Name:      "svc",
+			},
+			Spec: v1.ServiceSpec{
+				Type:  v1.ServiceTypeLoadBalancer,
+				Ports: test.ports,
+			},
+			Status: v1.ServiceStatus{
+				LoadBalancer: v1.LoadBalancerStatus{
+					Ingress: []v1.LoadBalancerIngress{test.ingress},
+				},
+			},
+		}
+		client := fake.NewSimpleClientset(svc.DeepCopy())
+		strategy, err := NewLoadBalancerStrategy(client, &Config{})
+		require.NoError(t, err)
+		require.NoError(t, strategy.Sync())
+		err = strategy.Add(svc)
+		assert.NoError(t, err, test.name)
+		svc, err = client.CoreV1().Services("ns").Get("svc", metav1.GetOptions{})
+		if assert.NoError(t, err, test.name) {
+			assert.Equal(t, test.expected, svc.Annotations[ExposeAnnotationKey], test.name)
+		}
+		assert.True(t, strategy.HasSynced(), test.name)
+	}
+}
+
+func TestLoadBalancerStrategy_Timeout(t *testing.T) {
+	svc := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "ns",
+			Name:      "svc",
+		},
+		Spec: v1.ServiceSpec{
+			Type:  v1.ServiceTypeLoadBalancer,
+			Ports: []v1.ServicePort{{Port: 80}},
+		},
+	}
+	client := fake.NewSimpleClientset(svc.DeepCopy())
+	strategy, err := NewLoadBalancerStrategy(client, &Config{
+		LoadBalancerTimeout: 50 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	require.NoError(t, strategy.Sync())
+
+	err = strategy.Add(svc.DeepCopy())
+	require.NoError(t, err)
+	assert.False(t, strategy.HasSynced(), "provisioning")
+	// adding again doesn't reset the timeout
+	time.Sleep(30 * time.Millisecond)
+	err = strategy.Add(svc.DeepCopy())
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		svc, err := client.CoreV1().Services("ns").Get("svc", metav1.GetOptions{})
+		return err == nil && svc.Annotations[ExposeStatusAnnotationKey] != ""
+	}, time.Second, 10*time.Millisecond)
+	assert.True(t, strategy.HasSynced(), "timed out")
+
+	svc, err = client.CoreV1().Services("ns").Get("svc", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "Timeout: load balancer not provisioned after 50ms", svc.Annotations[ExposeStatusAnnotationKey])
+	svc.Status.LoadBalancer.Ingress = []v1.LoadBalancerIngress{{IP: "1.2.3.4"}}
+	err = strategy.Add(svc.DeepCopy())
+	require.NoError(t, err)
+	svc, err = client.CoreV1().Services("ns").Get("svc", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "http://1.2.3.4", svc.Annotations[ExposeAnnotationKey])
+	assert.NotContains(t, svc.Annotations, ExposeStatusAnnotationKey)
+}
+
+func TestLoadBalancerStrategy_Options(t *testing.T) {
+	original := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "ns",
+			Name:      "svc",
+			Annotations: map[string]string{
+				LoadBalancerAnnotationsAnnotationKey: "service.beta.kubernetes.io/aws-load-balancer-internal: \"false\"\nmy-annotation: value",
+				LoadBalancerSourceRangesAnnotationKey: "10.0.0.0/8, 192.168.0.0/16",
+				"service.beta.kubernetes.io/aws-load-balancer-type": "elb",
+			},
+		},
+		Spec: v1.ServiceSpec{
+			Type:                  v1.ServiceTypeClusterIP,
+			ExternalTrafficPolicy: v1.ServiceExternalTrafficPolicyTypeCluster,
+		},
+	}
+	client := fake.NewSimpleClientset(original.DeepCopy())
+	_, err := NewLoadBalancerStrategy(client, &Config{LoadBalancerProfile: "unknown"})
+	assert.Error(t, err, "unknown profile")
+	_, err = NewLoadBalancerStrategy(client, &Config{LoadBalancerProfiles: map[string]LoadBalancerProfile{
+		"invalid": {ExternalTrafficPolicy: "Nowhere"},
+	}})
+	assert.Error(t, err, "invalid traffic policy")
+	strategy, err := NewLoadBalancerStrategy(client, &Config{
+		LoadBalancerProfile: "internal",
+		LoadBalancerProfiles: map[string]LoadBalancerProfile{
+			"internal": {
+				Annotations: map[string]string{
+					"service.beta.kubernetes.io/aws-load-balancer-type":     "nlb",
+					"service.beta.kubernetes.io/aws-load-balancer-internal": "true",
+				},
+				ExternalTrafficPolicy: "Local",
+			},
+		},
+	})
+	require.NoError(t, err)
+	require.NoError(t, strategy.Sync())
+
+	// the annotations of the service override the profile
+	err = strategy.Add(original.DeepCopy())
+	require.NoError(t, err)
+	svc, err := client.CoreV1().Services("ns").Get("svc", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "nlb", svc.Annotations["service.beta.kubernetes.io/aws-load-balancer-type"])
+	assert.Equal(t, "false", svc.Annotations["service.beta.kubernetes.io/aws-load-balancer-internal"])
+	assert.Equal(t, "value", svc.Annotations["my-annotation"])
+	assert.Equal(t, "my-annotation,service.beta.kubernetes.io/aws-load-balancer-internal,service.beta.kubernetes.io/aws-load-balancer-type",
+		svc.Annotations[LoadBalancerManagedAnnotationKey])
+	assert.Equal(t, `{"service.beta.kubernetes.io/aws-load-balancer-type":"elb"}`, svc.Annotations[LoadBalancerOriginalAnnotationKey])
+	assert.Equal(t, []string{"10.0.0.0/8", "192.168.0.0/16"}, svc.Spec.LoadBalancerSourceRanges)
+	assert.Equal(t, v1.ServiceExternalTrafficPolicyTypeLocal, svc.Spec.ExternalTrafficPolicy)
+	assert.Equal(t, `{"externalTrafficPolicy":"Cluster","loadBalancerSourceRanges":null,"type":"ClusterIP"}`,
+		svc.Annotations[OriginalSpecAnnotationKey])
+
+	// the options not wanted anymore are removed
+	svc.Annotations[LoadBalancerProfileAnnotationKey] = "aws-nlb"
+	delete(svc.Annotations, LoadBalancerAnnotationsAnnotationKey)
+	delete(svc.Annotations, LoadBalancerSourceRangesAnnotationKey)
+	_, err = client.CoreV1().Services("ns").Update(svc)
+	require.NoError(t, err)
+	err = strategy.Add(svc.DeepCopy())
+	require.NoError(t, err)
+	svc, err = client.CoreV1().Services("ns").Get("svc", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "nlb", svc.Annotations["service.beta.kubernetes.io/aws-load-balancer-type"])
+	assert.NotContains(t, svc.Annotations, "service.beta.kubernetes.io/aws-load-balancer-internal")
+	assert.NotContains(t, svc.Annotations, "my-annotation")
+	assert.Equal(t, "service.beta.kubernetes.io/aws-load-balancer-type", svc.Annotations[LoadBalancerManagedAnnotationKey])
+	assert.Equal(t, `{"service.beta.kubernetes.io/aws-load-balancer-type":"elb"}`, svc.Annotations[LoadBalancerOriginalAnnotationKey])
+	assert.Empty(t, svc.Spec.LoadBalancerSourceRanges)
+	assert.Equal(t, v1.ServiceExternalTrafficPolicyTypeCluster, svc.Spec.ExternalTrafficPolicy)
+	assert.Equal(t, `{"type":"ClusterIP"}`, svc.Annotations[OriginalSpecAnnotationKey])
+
+	// the annotations of the user are kept on clean, and the overwritten ones restored
+	svc.Annotations["user-annotation"] = "value"
+	_, err = client.CoreV1().Services("ns").Update(svc)
+	require.NoError(t, err)
+	err = strategy.Clean(svc.DeepCopy())
+	require.NoError(t, err)
+	svc, err = client.CoreV1().Services("ns").Get("svc", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		LoadBalancerProfileAnnotationKey:                    "aws-nlb",
+		"user-annotation":                                   "value",
+		"service.beta.kubernetes.io/aws-load-balancer-type": "elb",
+	}, svc.Annotations)
+	assert.Equal(t, v1.ServiceTypeClusterIP, svc.Spec.Type)
+
+	// invalid annotations
+	svc.Annotations[LoadBalancerTrafficPolicyAnnotationKey] = "Nowhere"
+	assert.Error(t, strategy.Add(svc.DeepCopy()))
+	svc.Annotations[LoadBalancerTrafficPolicyAnnotationKey] = "Local"
+	svc.Annotations[LoadBalancerAnnotationsAnnotationKey] = "- not a map"
+	assert.Error(t, strategy.Add(svc.DeepCopy()))
+	delete(svc.Annotations, LoadBalancerAnnotationsAnnotationKey)
+	svc.Annotations[LoadBalancerProfileAnnotationKey] = "unknown"
+	assert.Error(t, strategy.Add(svc.DeepCopy()))
+}
+
+func TestLoadBalancerStrategy_IPPool(t *testing.T) {
+	newService := func(name, shared string, ports ...int32) *v1.Service {
+		svc := &v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace:   "ns",
+				Name:        name,
+				Annotations: map[string]string{},
+			},
+		}
+		if shared != "" {
+			svc.Annotations[LoadBalancerSharedIPAnnotationKey] = shared
+		}
+		for _, port := range ports {
+			svc.Spec.Ports = append(svc.Spec.Ports, v1.ServicePort{Port: port})
+		}
+		return svc
+	}
+	services := []*v1.Service{
+		newService("svc1", "", 80),
+		newService("svc2", "dns", 53),
+		newService("svc3", "dns", 5353),
+		newService("svc4", "dns", 53),
+		newService("svc5", "", 80),
+	}
+	client := fake.NewSimpleClientset()
+	for _, svc := range services {
+		_, err := client.CoreV1().Services("ns").Create(svc.DeepCopy())
+		require.NoError(t, err)
+	}
+	config := &Config{
+		Namespace:       "ns",
+		LoadBalancerIPs: []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"},
+	}
+	_, err := NewLoadBalancerStrategy(client, &Config{LoadBalancerIPs: []string{"not-an-ip"}})
+	assert.Error(t, err, "invalid IP")
+	strategy, err := NewLoadBalancerStrategy(client, config)
+	require.NoError(t, err)
+	require.NoError(t, strategy.Sync())
+
+	getIP := func(name string) string {
+		svc, err := client.CoreV1().Services("ns").Get(name, metav1.GetOptions{})
+		require.NoError(t, err)
+		return svc.Spec.LoadBalancerIP
+	}
+	for _, svc := range services[:4] {
+		require.NoError(t, strategy.Add(svc.DeepCopy()))
+	}
+	assert.Equal(t, "10.0.0.1", getIP("svc1"))
+	assert.Equal(t, "10.0.0.2", getIP("svc2"))
+	assert.Equal(t, "10.0.0.2", getIP("svc3"), "shared with disjoint ports")
+	assert.Equal(t, "10.0.0.3", getIP("svc4"), "shared but same port")
+	svc, err := client.CoreV1().Services("ns").Get("svc3", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "dns", svc.Annotations["metallb.universe.tf/allow-shared-ip"])
+	assert.Error(t, strategy.Add(services[4].DeepCopy()), "pool exhausted")
+
+	// the allocations survive a restart
+	strategy, err = NewLoadBalancerStrategy(client, config)
+	require.NoError(t, err)
+	require.NoError(t, strategy.Sync())
+	svc, err = client.CoreV1().Services("ns").Get("svc4", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.NoError(t, strategy.Add(svc.DeepCopy()))
+	assert.Equal(t, "10.0.0.3", getIP("svc4"))
+
+	// the IP is returned to the pool on clean
+	svc, err = client.CoreV1().Services("ns").Get("svc1", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.NoError(t, strategy.Clean(svc))
+	assert.Equal(t, "", getIP("svc1"))
+	require.NoError(t, strategy.Add(services[4].DeepCopy()))
+	assert.Equal(t, "10.0.0.1", getIP("svc5"))
+
+	// the IP is returned to the pool on delete
+	require.NoError(t, strategy.Delete(services[4]))
+	require.NoError(t, strategy.Add(services[0].DeepCopy()))
+	assert.Equal(t, "10.0.0.1", getIP("svc1"))
+
+	cm, err := client.CoreV1().ConfigMaps("ns").Get(DefaultLoadBalancerIPLedger, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, `{"ns/svc1":{"ip":"10.0.0.1","ports":[80]},`+
+		`"ns/svc2":{"ip":"10.0.0.2","shared":"dns","ports":[53]},`+
+		`"ns/svc3":{"ip":"10.0.0.2","shared":"dns","ports":[5353]},`+
+		`"ns/svc4":{"ip":"10.0.0.3","shared":"dns","ports":[53]}}`, cm.Data["allocations.json"])
+
+	// the IPs of the services deleted while not watched are released on sync
+	require.NoError(t, client.CoreV1().Services("ns").Delete("svc4", &metav1.DeleteOptions{}))
+	strategy, err = NewLoadBalancerStrategy(client, config)
+	require.NoError(t, err)
+	require.NoError(t, strategy.Sync())
+	cm, err = client.CoreV1().ConfigMaps("ns").Get(DefaultLoadBalancerIPLedger, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, `{"ns/svc1":{"ip":"10.0.0.1","ports":[80]},`+
+		`"ns/svc2":{"ip":"10.0.0.2","shared":"dns","ports":[53]},`+
+		`"ns/svc3":{"ip":"10.0.0.2","shared":"dns","ports":[5353]}}`, cm.Data["allocations.json"])
+}
@@ -0,0 +1,396 @@
+package exposestrategy
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"k8s.io/klog"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// NamespaceAnnotationPrefix is the prefix of the namespace annotations overriding the config for its services
+const NamespaceAnnotationPrefix = "exposecontroller.fabric8.io/"
+
+// NamespaceSettings are the settings of the strategies which a namespace or a policy can override
+type NamespaceSettings struct {
+	Exposer        string
+	Domain         string
+	InternalDomain string
+	TLSSecretName  string
+	TLSUseWildcard bool
+	TLSAcme        bool
+	HTTP           bool
+	URLTemplate    string
+	PathMode       string
+	IngressClass   string
+	// AllowedHosts are the host patterns the services can be exposed on, any if empty
+	AllowedHosts []string
+}
+
+type namespaceOverrideFunc = func(settings *NamespaceSettings, value string) error
+
+// namespaceOverrideFuncs override the settings from the annotations, by annotation suffix
+var namespaceOverrideFuncs = map[string]namespaceOverrideFunc{
+	"exposer": func(settings *NamespaceSettings, value string) error {
+		settings.Exposer = strings.ToLower(value)
+		return checkExposer(value)
+	},
+	"domain": func(settings *NamespaceSettings, value string) error {
+		settings.Domain = value
+		return nil
+	},
+	"internal-domain": func(settings *NamespaceSettings, value string) error {
+		settings.InternalDomain = value
+		return nil
+	},
+	"tls-secret-name": func(settings *NamespaceSettings, value string) error {
+		settings.TLSSecretName = value
+		return nil
+	},
+	"tls-use-wildcard": func(settings *NamespaceSettings, value string) (err error) {
+		settings.TLSUseWildcard, err = strconv.ParseBool(value)
+		return err
+	},
+	"tls-acme": func(settings *NamespaceSettings, value string) (err error) {
+		settings.TLSAcme, err = strconv.ParseBool(value)
+		return err
+	},
+	"http": func(settings *NamespaceSettings, value string) (err error) {
+		settings.HTTP, err = strconv.ParseBool(value)
+		return err
+	},
+	"urltemplate": func(settings *NamespaceSettings, value string) error {
+		_, err := parseURLTemplate(value)
+		settings.URLTemplate = value
+		return err
+	},
+	"path-mode": func(settings *NamespaceSettings, value string) error {
+		if value != "" && value != PathModeUsePath {
+			return errors.Errorf("unknown path mode \"%s\", must be empty or \"%s\"", value, PathModeUsePath)
+		}
+		settings.PathMode = value
+		return nil
+	},
+	"ingress-class": func(settings *NamespaceSettings, value string) error {
+		settings.IngressClass = value
+		return nil
+	},
+	"allowed-hosts": func(settings *NamespaceSettings, value string) error {
+		var patterns []string
+		for _, host := range strings.Split(value, ",") {
+			if host = strings.TrimSpace(host); host != "" {
+				patterns = append(patterns, host)
+			}
+		}
+		if err := checkAllowedHosts(patterns); err != nil {
+			return err
+		}
+		// the overrides can only narrow the allowed hosts
+		settings.AllowedHosts = intersectAllowedHosts(settings.AllowedHosts, patterns)
+		return nil
+	},
+}
+
+// Policy overrides the settings of the services it selects, like the namespace annotations
+type Policy struct {
+	// Name identifies the policy, and orders the policies of the same level
+	Name string
+	// Namespace is the namespace of the services the policy applies to, empty for all namespaces
+	Namespace string
+	// NamespaceSelector selects the namespaces the policy applies to, nil for all
+	NamespaceSelector labels.Selector
+	// ServiceSelector selects the services the policy applies to, nil for all
+	ServiceSelector labels.Selector
+	// Overrides are the settings overridden, by namespace annotation suffix
+	Overrides map[string]string
+}
+
+// Check checks the overrides of the policy
+func (p *Policy) Check() error {
+	suffixes := make([]string, 0, len(p.Overrides))
+	for suffix := range p.Overrides {
+		suffixes = append(suffixes, suffix)
+	}
+	sort.Strings(suffixes)
+	for _, suffix := range suffixes {
+		f, ok := namespaceOverrideFuncs[suffix]
+		if !ok {
+			return errors.Errorf("unknown setting \"%s\"", suffix)
+		}
+		if err := f(&NamespaceSettings{}, p.Overrides[suffix]); err != nil {
+			return errors.Wrapf(err, "invalid %s", suffix)
+		}
+	}
+	return nil
+}
+
+func (p *Policy) equal(other *Policy) bool {
+	selector := func(s labels.Selector) string {
+		if s == nil {
+			return "<nil>"
+		}
+		return s.String()
+	}
+	return p.Name == other.Name && p.Namespace == other.Namespace &&
+		selector(p.NamespaceSelector) == selector(other.NamespaceSelector) &&
+		selector(p.ServiceSelector) == selector(other.ServiceSelector) &&
+		equalOverrides(p.Overrides, other.Overrides)
+}
+
+func (p *Policy) matches(svc *v1.Service, namespaceLabels labels.Set) bool {
+	if p.Namespace != "" && p.Namespace != svc.Namespace {
+		return false
+	}
+	if p.NamespaceSelector != nil && !p.NamespaceSelector.Matches(namespaceLabels) {
+		return false
+	}
+	return p.ServiceSelector == nil || p.ServiceSelector.Matches(labels.Set(svc.Labels))
+}
+
+// NamespaceOverrides holds the settings overridden by the annotations of each namespace, and by the policies
+// It is shared by the strategies, and updated by the controller when the namespaces or the policies change
+// The cluster policies apply first, then the namespace annotations, then the namespaced policies
+type NamespaceOverrides struct {
+	lock sync.RWMutex
+	// the valid override annotations, by namespace then by suffix
+	overrides map[string]map[string]string
+	// the labels of the namespaces, to select the cluster policies
+	labels map[string]labels.Set
+	// the valid policies, by name
+	policies map[string]*Policy
+}
+
+// NewNamespaceOverrides creates empty namespace overrides
+func NewNamespaceOverrides() *NamespaceOverrides {
+	return &NamespaceOverrides{
+		overrides: map[string]map[string]string{},
+		labels:    map[string]labels.Set{},
+		policies:  map[string]*Policy{},
+	}
+}
+
+// Update records the overrides and the labels of the namespace, the invalid overrides are logged and ignored
+// returns true if the settings of its services may have changed
+func (n *NamespaceOverrides) Update(ns *v1.Namespace) bool {
+	overrides := map[string]string{}
+	for key, value := range ns.Annotations {
+		if !strings.HasPrefix(key, NamespaceAnnotationPrefix) {
+			continue
+		}
+		suffix := strings.TrimPrefix(key, NamespaceAnnotationPrefix)
+		f, ok := namespaceOverrideFuncs[suffix]
+		if !ok {
+			continue
+		}
+		if err := f(&NamespaceSettings{}, value); err != nil {
+			klog.Warningf("ignoring annotation %s of namespace %s: %v", key, ns.Name, err)
+			continue
+		}
+		overrides[suffix] = value
+	}
+
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	changed := false
+	if !equalOverrides(n.labels[ns.Name], ns.Labels) {
+		n.labels[ns.Name] = labels.Set(ns.Labels)
+		for _, policy := range n.policies {
+			changed = changed || policy.NamespaceSelector != nil
+		}
+	}
+	if equalOverrides(n.overrides[ns.Name], overrides) {
+		return changed
+	}
+	if len(overrides) == 0 {
+		delete(n.overrides, ns.Name)
+	} else {
+		n.overrides[ns.Name] = overrides
+	}
+	klog.Infof("namespace %s overrides: %v", ns.Name, overrides)
+	return true
+}
+
+// Delete forgets the overrides of the namespace
+// returns true if it had overrides
+func (n *NamespaceOverrides) Delete(namespace string) bool {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	delete(n.labels, namespace)
+	if _, ok := n.overrides[namespace]; !ok {
+		return false
+	}
+	delete(n.overrides, namespace)
+	return true
+}
+
+// Labels returns the labels of the namespace, empty if unknown
+func (n *NamespaceOverrides) Labels(namespace string) labels.Set {
+	if n == nil {
+		return labels.Set{}
+	}
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+	return n.labels[namespace]
+}
+
+// SetPolicy adds or replaces the policy with the same name, which must be valid
+// returns true if the policy changed
+func (n *NamespaceOverrides) SetPolicy(policy *Policy) bool {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	previous := n.policies[policy.Name]
+	n.policies[policy.Name] = policy
+	return previous == nil || !previous.equal(policy)
+}
+
+// DeletePolicy removes the policy
+// returns the removed policy, nil if unknown
+func (n *NamespaceOverrides) DeletePolicy(name string) *Policy {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	policy := n.policies[name]
+	delete(n.policies, name)
+	return policy
+}
+
+// Apply returns the settings overridden for the service
+// returns false if nothing overrides them
+func (n *NamespaceOverrides) Apply(svc *v1.Service, settings NamespaceSettings) (NamespaceSettings, bool) {
+	if n == nil {
+		return settings, false
+	}
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+	cluster := []*Policy{}
+	namespaced := []*Policy{}
+	for _, policy := range n.policies {
+		if !policy.matches(svc, n.labels[svc.Namespace]) {
+			continue
+		} else if policy.Namespace == "" {
+			cluster = append(cluster, policy)
+		} else {
+			namespaced = append(namespaced, policy)
+		}
+	}
+	overrides := n.overrides[svc.Namespace]
+	if len(cluster) == 0 && len(overrides) == 0 && len(namespaced) == 0 {
+		return settings, false
+	}
+	for _, policy := range sortPolicies(cluster) {
+		applyOverrides(&settings, policy.Overrides)
+	}
+	applyOverrides(&settings, overrides)
+	for _, policy := range sortPolicies(namespaced) {
+		applyOverrides(&settings, policy.Overrides)
+	}
+	return settings, true
+}
+
+func sortPolicies(policies []*Policy) []*Policy {
+	sort.Slice(policies, func(i, j int) bool {
+		return policies[i].Name < policies[j].Name
+	})
+	return policies
+}
+
+func applyOverrides(settings *NamespaceSettings, overrides map[string]string) {
+	suffixes := make([]string, 0, len(overrides))
+	for suffix := range overrides {
+		suffixes = append(suffixes, suffix)
+	}
+	sort.Strings(suffixes)
+	for _, suffix := range suffixes {
+		// already checked
+		namespaceOverrideFuncs[suffix](settings, overrides[suffix])
+	}
+}
+
+func equalOverrides(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for key, value := range a {
+		if other, ok := b[key]; !ok || other != value {
+			return false
+		}
+	}
+	return true
+}
+
+// checkAllowedHosts checks the allowed host patterns, either host names or "*.<domain>"
+func checkAllowedHosts(patterns []string) error {
+	for _, pattern := range patterns {
+		host := strings.TrimPrefix(pattern, "*.")
+		if msgs := validation.IsDNS1123Subdomain(host); len(msgs) > 0 {
+			return errors.Errorf("invalid allowed host \"%s\": %s", pattern, strings.Join(msgs, ", "))
+		}
+	}
+	return nil
+}
+
+// noAllowedHost is the only pattern left when the overrides allow no host, it matches none
+const noAllowedHost = "<none>"
+
+// intersectAllowedHosts returns the host patterns allowed by both lists, an empty list allowing any host
+// The patterns outside of the current ones are ignored, no host is allowed if none is left
+func intersectAllowedHosts(current, patterns []string) []string {
+	if len(current) == 0 {
+		return patterns
+	}
+	intersection := []string{}
+	seen := map[string]bool{}
+	add := func(pattern string) {
+		if !seen[pattern] {
+			seen[pattern] = true
+			intersection = append(intersection, pattern)
+		}
+	}
+	for _, pattern := range patterns {
+		for _, allowed := range current {
+			if allowedHostPattern(pattern, allowed) {
+				add(pattern)
+			} else if allowedHostPattern(allowed, pattern) {
+				add(allowed)
+			}
+		}
+	}
+	if len(intersection) == 0 {
+		klog.Warningf("allowed hosts \"%s\" outside of \"%s\", no host is allowed", strings.Join(patterns, "\", \""), strings.Join(current, "\", \""))
+		return []string{noAllowedHost}
+	}
+	return intersection
+}
+
+// allowedHostPattern tells if all the hosts matching the pattern match the allowed pattern
+func allowedHostPattern(pattern, allowed string) bool {
+	if strings.HasPrefix(pattern, "*.") {
+		return strings.HasPrefix(allowed, "*.") && strings.HasSuffix(pattern[1:], allowed[1:])
+	}
+	return checkAllowedHost(pattern, []string{allowed}) == nil
+}
+
+// checkAllowedHost checks the host matches one of the allowed host patterns, if any
+// "*.<domain>" matches any sub domain of the domain
+func checkAllowedHost(host string, patterns []string) error {
+	if len(patterns) == 0 {
+		return nil
+	} else if len(patterns) == 1 && patterns[0] == noAllowedHost {
+		return errors.Errorf("host \"%s\" is not allowed, no host is allowed", host)
+	}
+	for _, pattern := range patterns {
+		if strings.HasPrefix(pattern, "*.") {
+			if strings.HasSuffix(host, pattern[1:]) {
+				return nil
+			}
+		} else if host == pattern {
+			return nil
+		}
+	}
+	return errors.Errorf("host \"%s\" is not allowed, must match one of \"%s\"", host, strings.Join(patterns, "\", \""))
+}
@@ -0,0 +1,230 @@
+package exposestrategy
+
+import (
+	"testing"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNamespaceOverrides(t *testing.T) {
+	namespace := func(annotations map[string]string) *v1.Namespace {
+		return &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "preview", Annotations: annotations}}
+	}
+	service := func(namespace string, labels map[string]string) *v1.Service {
+		return &v1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "my-service", Labels: labels}}
+	}
+	defaults := NamespaceSettings{
+		Domain:        "example.com",
+		TLSSecretName: "tls",
+		URLTemplate:   DefaultURLTemplate,
+	}
+	overrides := NewNamespaceOverrides()
+
+	settings, ok := overrides.Apply(service("preview", nil), defaults)
+	assert.False(t, ok)
+	assert.Equal(t, defaults, settings)
+
+	changed := overrides.Update(namespace(map[string]string{
+		"exposecontroller.fabric8.io/domain":        "preview.example.com",
+		"exposecontroller.fabric8.io/http":          "true",
+		"exposecontroller.fabric8.io/urltemplate":   "{{.Service}}-{{.Namespace}}.{{.Domain}}",
+		"exposecontroller.fabric8.io/ingress-class": "internal",
+		"exposecontroller.fabric8.io/tls-acme":      "maybe",
+		"exposecontroller.fabric8.io/unknown":       "value",
+		"fabric8.io/domain":                         "other.example.com",
+	}))
+	assert.True(t, changed)
+	settings, ok = overrides.Apply(service("preview", nil), defaults)
+	assert.True(t, ok)
+	assert.Equal(t, NamespaceSettings{
+		Domain:        "preview.example.com",
+		TLSSecretName: "tls",
+		HTTP:          true,
+		URLTemplate:   "{{.Service}}-{{.Namespace}}.{{.Domain}}",
+		IngressClass:  "internal",
+	}, settings)
+	_, ok = overrides.Apply(service("production", nil), defaults)
+	assert.False(t, ok, "other namespace")
+
+	changed = overrides.Update(namespace(map[string]string{
+		"exposecontroller.fabric8.io/domain":        "preview.example.com",
+		"exposecontroller.fabric8.io/http":          "true",
+		"exposecontroller.fabric8.io/urltemplate":   "{{.Service}}-{{.Namespace}}.{{.Domain}}",
+		"exposecontroller.fabric8.io/ingress-class": "internal",
+	}))
+	assert.False(t, changed, "same valid overrides")
+
+	changed = overrides.Update(namespace(nil))
+	assert.True(t, changed)
+	_, ok = overrides.Apply(service("preview", nil), defaults)
+	assert.False(t, ok)
+	assert.False(t, overrides.Delete("preview"))
+
+	var none *NamespaceOverrides
+	settings, ok = none.Apply(service("preview", nil), defaults)
+	assert.False(t, ok)
+	assert.Equal(t, defaults, settings)
+}
+
+func TestIngressStrategy_NamespaceOverrides(t *testing.T) {
+	client := fake.NewSimpleClientset(&v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "preview",
+			Name:        "my-service",
+			Annotations: map[string]string{ExposeAnnotation.Key: ExposeAnnotation.Value},
+		},
+		Spec: v1.ServiceSpec{Ports: []v1.ServicePort{{Port: 80}}},
+	})
+	overrides := NewNamespaceOverrides()
+	overrides.Update(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name: "preview",
+		Annotations: map[string]string{
+			"exposecontroller.fabric8.io/domain":          "preview.example.com",
+			"exposecontroller.fabric8.io/tls-secret-name": "preview-tls",
+			"exposecontroller.fabric8.io/ingress-class":   "internal",
+		},
+	}})
+	strategy, err := NewIngressStrategy(client, &Config{
+		Exposer:       "ingress",
+		Domain:        "example.com",
+		TLSSecretName: "tls",
+		IngressClass:  "public",
+		Namespaces:    overrides,
+	})
+	require.NoError(t, err)
+	require.NoError(t, strategy.Sync())
+	service, err := client.CoreV1().Services("preview").Get("my-service", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.NoError(t, strategy.Add(service))
+
+	service, err = client.CoreV1().Services("preview").Get("my-service", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "https://my-service.preview.preview.example.com", service.Annotations[ExposeAnnotationKey])
+	ingress, err := client.ExtensionsV1beta1().Ingresses("preview").Get("my-service", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "internal", ingress.Annotations["kubernetes.io/ingress.class"])
+	if assert.Len(t, ingress.Spec.TLS, 1) {
+		assert.Equal(t, "preview-tls", ingress.Spec.TLS[0].SecretName)
+	}
+	// the strategy itself is unchanged
+	assert.Equal(t, "example.com", strategy.(*IngressStrategy).domain)
+}
+
+func TestNamespaceOverrides_Policies(t *testing.T) {
+	service := func(namespace string, labels map[string]string) *v1.Service {
+		return &v1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "my-service", Labels: labels}}
+	}
+	selector := func(selector string) labels.Selector {
+		s, err := labels.Parse(selector)
+		require.NoError(t, err)
+		return s
+	}
+	overrides := NewNamespaceOverrides()
+	overrides.Update(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:        "preview",
+		Labels:      map[string]string{"env": "preview"},
+		Annotations: map[string]string{NamespaceAnnotationPrefix + "domain": "annotation.example.com"},
+	}})
+	overrides.Update(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:   "production",
+		Labels: map[string]string{"env": "production"},
+	}})
+	policies := []*Policy{{
+		Name:              "ExposeConfig/previews",
+		NamespaceSelector: selector("env=preview"),
+		Overrides:         map[string]string{"domain": "previews.example.com", "http": "true", "exposer": "Kong"},
+	}, {
+		Name:      "ExposeConfig/all",
+		Overrides: map[string]string{"allowed-hosts": "*.example.com, example.org"},
+	}, {
+		Name:            "ExposePolicy/preview/public",
+		Namespace:       "preview",
+		ServiceSelector: selector("public=true"),
+		Overrides:       map[string]string{"domain": "public.example.com"},
+	}}
+	for _, policy := range policies {
+		require.NoError(t, policy.Check(), policy.Name)
+		overrides.SetPolicy(policy)
+	}
+
+	settings, ok := overrides.Apply(service("production", nil), NamespaceSettings{Domain: "example.com"})
+	assert.True(t, ok)
+	assert.Equal(t, NamespaceSettings{Domain: "example.com", AllowedHosts: []string{"*.example.com", "example.org"}}, settings)
+	settings, _ = overrides.Apply(service("preview", nil), NamespaceSettings{Domain: "example.com"})
+	assert.Equal(t, "annotation.example.com", settings.Domain, "annotations override the cluster policies")
+	assert.Equal(t, "kong", settings.Exposer)
+	assert.True(t, settings.HTTP)
+	settings, _ = overrides.Apply(service("preview", map[string]string{"public": "true"}), NamespaceSettings{})
+	assert.Equal(t, "public.example.com", settings.Domain, "namespaced policies override the annotations")
+
+	assert.True(t, overrides.Update(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:   "production",
+		Labels: map[string]string{"env": "preview"},
+	}}), "selected by another policy")
+	settings, _ = overrides.Apply(service("production", nil), NamespaceSettings{})
+	assert.Equal(t, "previews.example.com", settings.Domain)
+
+	assert.NotNil(t, overrides.DeletePolicy("ExposeConfig/previews"))
+	assert.Nil(t, overrides.DeletePolicy("ExposeConfig/previews"))
+	settings, _ = overrides.Apply(service("production", nil), NamespaceSettings{})
+	assert.Equal(t, "", settings.Domain)
+
+	for _, overrides := range []map[string]string{
+		{"http": "yes"},
+		{"exposer": "router"},
+		{"allowed-hosts": "*.Example.com"},
+		{"unknown": "value"},
+	} {
+		assert.Error(t, (&Policy{Name: "invalid", Overrides: overrides}).Check(), "%v", overrides)
+	}
+}
+
+func TestCheckAllowedHost(t *testing.T) {
+	patterns := []string{"*.example.com", "example.org"}
+	assert.NoError(t, checkAllowedHost("anything.example.com", nil))
+	assert.NoError(t, checkAllowedHost("my-service.preview.example.com", patterns))
+	assert.NoError(t, checkAllowedHost("example.org", patterns))
+	assert.Error(t, checkAllowedHost("example.com", patterns))
+	assert.Error(t, checkAllowedHost("my-service.example.org", patterns))
+	assert.Error(t, checkAllowedHost("badexample.com", patterns))
+}
+
+func TestIntersectAllowedHosts(t *testing.T) {
+	cluster := []string{"*.example.com", "example.org"}
+	assert.Equal(t, []string{"*.example.com"}, intersectAllowedHosts(nil, []string{"*.example.com"}), "any host allowed")
+	assert.Equal(t, []string{"*.preview.example.com", "example.org"},
+		intersectAllowedHosts(cluster, []string{"*.preview.example.com", "example.org"}))
+	assert.Equal(t, []string{"my-app.example.com", "*.example.com"},
+		intersectAllowedHosts(cluster, []string{"my-app.example.com", "*.com"}), "the wider patterns are narrowed")
+	none := intersectAllowedHosts(cluster, []string{"*.evil.com", "example.com"})
+	assert.Equal(t, []string{noAllowedHost}, none, "never widened")
+	assert.Error(t, checkAllowedHost("my-app.example.com", none))
+	assert.Equal(t, none, intersectAllowedHosts(none, []string{"*.example.com"}), "stays empty")
+
+	// the annotations of a namespace can't widen the hosts allowed by the cluster policies
+	overrides := NewNamespaceOverrides()
+	overrides.SetPolicy(&Policy{Name: "ExposeConfig/all", Overrides: map[string]string{"allowed-hosts": "*.example.com"}})
+	overrides.Update(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:        "team",
+		Annotations: map[string]string{NamespaceAnnotationPrefix + "allowed-hosts": "*.evil.com,team.example.com"},
+	}})
+	svc := &v1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "team", Name: "my-service"}}
+	settings, _ := overrides.Apply(svc, NamespaceSettings{AllowedHosts: []string{"*.example.com", "*.example.org"}})
+	assert.Equal(t, []string{"team.example.com"}, settings.AllowedHosts)
+}
+
+func TestNamespaceOverrides_NoAllowedHost(t *testing.T) {
+	// a policy narrowing the hosts to other ones allows none of the cluster ones
+	overrides := NewNamespaceOverrides()
+	overrides.SetPolicy(&Policy{Name: "ExposePolicy/team/api", Namespace: "team", Overrides: map[string]string{"allowed-hosts": "api.other.com"}})
+	svc := &v1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "team", Name: "my-service"}}
+	settings, _ := overrides.Apply(svc, NamespaceSettings{AllowedHosts: []string{"*.example.com"}})
+	assert.Error(t, checkAllowedHost("my-service.example.com", settings.AllowedHosts))
+	assert.Error(t, checkAllowedHost("api.other.com", settings.AllowedHosts))
+}
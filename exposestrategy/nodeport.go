@@ -2,56 +2,165 @@ package exposestrategy
 
 import (
 	"fmt"
+	"hash/fnv"
 	"net"
+	"reflect"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/pkg/errors"
+	"k8s.io/klog"
 
 	"k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
 )
 
 // NodePortStrategy is a strategy that changes the type of services to NodePort
 type NodePortStrategy struct {
 	client  kubernetes.Interface
 
-	nodeIP string
+	namespace    string
+	// The IP of the node, if configured
+	nodeIP       string
+	nodeSelector string
+	// Protects the fields updated by the node informer
+	lock         sync.Mutex
+	// The IPs of the eligible nodes, sorted by node name
+	nodeIPs      []string
+	nodes        cache.Store
+	started      bool
+	// Exposes a service again, through the controller
+	refresh      func(namespace, name string)
+	stop         <-chan struct{}
+	// The range of the node ports allocated by hashing the services, if configured
+	portMin      int32
+	portMax      int32
+	// The service using each node port in the watched namespaces
+	ports        map[int32]string
+	// The services exposed, to update when the nodes change
+	services     map[string]bool
 	// The services to wait for their node port
-	todo   map[string]bool
+	todo         map[string]bool
 }
 
-// ExternalIPLabel is the node's label to export the external IP of the cluster
-const ExternalIPLabel = "fabric8.io/externalIP"
+const (
+	// ExternalIPLabel is the node's label to export the external IP of the cluster
+	ExternalIPLabel = "fabric8.io/externalIP"
+	// NodePortAnnotationKey annotation sets the node port to use
+	NodePortAnnotationKey = "fabric8.io/nodePort"
+)
 
 // NewNodePortStrategy creates a new NodePortStrategy
 func NewNodePortStrategy(client kubernetes.Interface, config *Config) (ExposeStrategy, error) {
-	ip := config.NodeIP
-	if len(ip) == 0 {
-		l, err := client.CoreV1().Nodes().List(metav1.ListOptions{})
+	s := &NodePortStrategy{
+		client:       client,
+		namespace:    config.Namespace,
+		nodeIP:       config.NodeIP,
+		nodeSelector: config.NodeSelector,
+		ports:        map[int32]string{},
+		services:     map[string]bool{},
+		todo:         map[string]bool{},
+		refresh:      config.Refresh,
+		stop:         config.Stop,
+	}
+	if config.NodePortRange != "" {
+		var err error
+		s.portMin, s.portMax, err = parseNodePortRange(config.NodePortRange)
 		if err != nil {
-			return nil, errors.Wrap(err, "failed to list nodes")
+			return nil, err
 		}
+		klog.Infof("Allocating node ports in range %d-%d", s.portMin, s.portMax)
+	}
+	if s.nodeIP != "" {
+		s.nodeIPs = []string{s.nodeIP}
+		return s, nil
+	}
 
-		if len(l.Items) != 1 {
-			return nil, errors.Errorf("node port strategy can only be used with single node clusters - found %d nodes", len(l.Items))
-		}
+	selector, err := labels.Parse(config.NodeSelector)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse node selector \"%s\"", config.NodeSelector)
+	}
+	s.nodeSelector = selector.String()
+	l, err := client.CoreV1().Nodes().List(metav1.ListOptions{LabelSelector: s.nodeSelector})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list nodes")
+	}
+	nodes := make([]interface{}, len(l.Items))
+	for i := range l.Items {
+		nodes[i] = &l.Items[i]
+	}
+	s.nodeIPs = getNodeIPs(nodes)
+	if len(s.nodeIPs) == 0 {
+		return nil, errors.Errorf("node port strategy found no ready node with an IP among the %d nodes matching \"%s\"",
+			len(l.Items), s.nodeSelector)
+	}
+	klog.Infof("Using node IPs: %v", s.nodeIPs)
+	return s, nil
+}
+
+// parseNodePortRange parses a range of ports like "30000-30099"
+func parseNodePortRange(value string) (int32, int32, error) {
+	parts := strings.SplitN(value, "-", 2)
+	if len(parts) != 2 {
+		return 0, 0, errors.Errorf("invalid node port range \"%s\", expected <min>-<max>", value)
+	}
+	min, err := strconv.ParseInt(strings.TrimSpace(parts[0]), 10, 32)
+	if err != nil {
+		return 0, 0, errors.Wrapf(err, "invalid node port range \"%s\"", value)
+	}
+	max, err := strconv.ParseInt(strings.TrimSpace(parts[1]), 10, 32)
+	if err != nil {
+		return 0, 0, errors.Wrapf(err, "invalid node port range \"%s\"", value)
+	}
+	if min <= 0 || max < min {
+		return 0, 0, errors.Errorf("invalid node port range \"%s\"", value)
+	}
+	return int32(min), int32(max), nil
+}
 
-		n := l.Items[0]
-		ip = n.ObjectMeta.Labels[ExternalIPLabel]
+// getNodeIPs returns the IPs of the ready nodes, sorted by node name
+func getNodeIPs(objects []interface{}) []string {
+	nodes := make([]*v1.Node, 0, len(objects))
+	for _, object := range objects {
+		if node, ok := object.(*v1.Node); ok && isNodeReady(node) {
+			nodes = append(nodes, node)
+		}
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].Name < nodes[j].Name
+	})
+	ips := []string{}
+	for _, node := range nodes {
+		ip := node.Labels[ExternalIPLabel]
 		if len(ip) == 0 {
-			addr, err := getNodeHostIP(n)
+			addr, err := getNodeHostIP(*node)
 			if err != nil {
-				return nil, errors.Wrap(err, "cannot discover node IP")
+				klog.Warningf("cannot discover the IP of node %s: %v", node.Name, err)
+				continue
 			}
 			ip = addr.String()
 		}
+		ips = append(ips, ip)
 	}
+	return ips
+}
 
-	return &NodePortStrategy{
-		client:  client,
-		nodeIP:  ip,
-	}, nil
+// isNodeReady tells if the node doesn't report a ready condition other than true
+func isNodeReady(node *v1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == v1.NodeReady {
+			return condition.Status == v1.ConditionTrue
+		}
+	}
+	return true
 }
 
 // getNodeHostIP returns the provided node's IP, based on the priority:
@@ -73,15 +182,95 @@ func getNodeHostIP(node v1.Node) (net.IP, error) {
 }
 
 // Sync is called before starting / resyncing
-// init the todo map
+// init the todo map, gets the node ports already used and starts watching the nodes
 func (s *NodePortStrategy) Sync() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
 	s.todo = map[string]bool{}
+	list, err := s.client.CoreV1().Services(s.namespace).List(metav1.ListOptions{})
+	if err != nil {
+		return errors.Wrap(err, "failed to list services")
+	}
+	s.ports = map[int32]string{}
+	for _, svc := range list.Items {
+		for _, port := range svc.Spec.Ports {
+			if port.NodePort > 0 {
+				s.ports[port.NodePort] = fmt.Sprintf("%s/%s", svc.Namespace, svc.Name)
+			}
+		}
+	}
+	if s.nodeIP == "" && !s.started {
+		s.started = true
+		s.startNodeInformer()
+	}
 	return nil
 }
 
+// startNodeInformer watches the eligible nodes and updates the exposed services when their IPs change
+func (s *NodePortStrategy) startNodeInformer() {
+	nodes := s.client.CoreV1().Nodes()
+	handler := func(interface{}) {
+		s.updateNodes()
+	}
+	var controller cache.Controller
+	s.nodes, controller = cache.NewInformer(
+		&cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				options.LabelSelector = s.nodeSelector
+				return nodes.List(options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				options.LabelSelector = s.nodeSelector
+				return nodes.Watch(options)
+			},
+		},
+		&v1.Node{},
+		0,
+		cache.ResourceEventHandlerFuncs{
+			AddFunc:    handler,
+			UpdateFunc: func(_, obj interface{}) { handler(obj) },
+			DeleteFunc: handler,
+		},
+	)
+	stop := s.stop
+	if stop == nil {
+		stop = wait.NeverStop
+	}
+	go controller.Run(stop)
+}
+
+// updateNodes updates the IPs of the nodes
+// Asks the controller to expose again the exposed services if they changed
+func (s *NodePortStrategy) updateNodes() {
+	s.lock.Lock()
+	ips := getNodeIPs(s.nodes.List())
+	if reflect.DeepEqual(ips, s.nodeIPs) {
+		s.lock.Unlock()
+		return
+	}
+	klog.Infof("Node IPs changed from %v to %v", s.nodeIPs, ips)
+	s.nodeIPs = ips
+	services := make([]string, 0, len(s.services))
+	for svcKey := range s.services {
+		services = append(services, svcKey)
+	}
+	s.lock.Unlock()
+
+	if s.refresh == nil {
+		return
+	}
+	sort.Strings(services)
+	for _, svcKey := range services {
+		parts := strings.SplitN(svcKey, "/", 2)
+		s.refresh(parts[0], parts[1])
+	}
+}
+
 // HasSynced tells if the strategy is complete
 // Complete when todo is empty
 func (s *NodePortStrategy) HasSynced() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
 	return len(s.todo) == 0
 }
 
@@ -89,10 +278,17 @@ func (s *NodePortStrategy) HasSynced() bool {
 // Changes the service type and updates various annotations
 // Adds the service to the todo list if the node port is unknown
 func (s *NodePortStrategy) Add(svc *v1.Service) error {
-	delete(s.todo, fmt.Sprintf("%s/%s", svc.Namespace, svc.Name))
+	svcKey := fmt.Sprintf("%s/%s", svc.Namespace, svc.Name)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.todo, svcKey)
+	s.services[svcKey] = true
 
-	var err error
 	clone := svc.DeepCopy()
+	err := recordOriginalSpec(svc, clone, "type", "externalIPs", nodePortsField)
+	if err != nil {
+		return err
+	}
 	clone.Spec.Type = v1.ServiceTypeNodePort
 	clone.Spec.ExternalIPs = nil
 
@@ -110,15 +306,40 @@ func (s *NodePortStrategy) Add(svc *v1.Service) error {
 		)
 	}
 
-	port := svc.Spec.Ports[0]
+	desired, err := s.getDesiredNodePort(svcKey, svc)
+	if err != nil {
+		s.reportStatus(svc, "Conflict: "+err.Error())
+		return err
+	}
+	if desired > 0 {
+		clone.Spec.Ports[0].NodePort = desired
+		if clone.Annotations == nil {
+			clone.Annotations = map[string]string{}
+		}
+		clone.Annotations[ExposeStatusAnnotationKey] = fmt.Sprintf("Allocated node port %d", desired)
+	}
+
+	port := clone.Spec.Ports[0]
 	portInt := int(port.NodePort)
-	if portInt > 0 {
+	if portInt > 0 && len(s.nodeIPs) > 0 {
 		nodePort := strconv.Itoa(portInt)
-		hostName := net.JoinHostPort(s.nodeIP, nodePort)
+		hostName := net.JoinHostPort(s.nodeIPs[0], nodePort)
 		err = addServiceAnnotation(clone, hostName)
+		if s.nodeIP == "" {
+			urls := make([]string, len(s.nodeIPs))
+			for i, ip := range s.nodeIPs {
+				hostName := net.JoinHostPort(ip, nodePort)
+				urls[i] = findHTTPProtocol(svc, hostName) + "://" + hostName
+			}
+			clone.Annotations[ExposeURLsAnnotationKey] = strings.Join(urls, ",")
+		}
 	} else {
-		s.todo[fmt.Sprintf("%s/%s", svc.Namespace, svc.Name)] = true
+		if portInt > 0 {
+			klog.Warningf("no ready node to expose service %s", svcKey)
+		}
+		s.todo[svcKey] = true
 		err = addServiceAnnotation(clone, "")
+		delete(clone.Annotations, ExposeURLsAnnotationKey)
 	}
 	if err != nil {
 		return errors.Wrap(err, "failed to add service annotation")
@@ -131,12 +352,19 @@ func (s *NodePortStrategy) Add(svc *v1.Service) error {
 		_, err = s.client.CoreV1().Services(svc.Namespace).
 			Patch(svc.Name, patchType, patch)
 		if err != nil {
+			if desired > 0 {
+				s.reportStatus(svc, fmt.Sprintf("Conflict: node port %d was rejected: %s", desired, err))
+			}
 			return errors.Wrap(err, fmt.Sprintf("failed to send patch for %s/%s patch %s", svc.Namespace, svc.Name, string(patch)))
 		}
 	}
+	if desired > 0 {
+		s.releasePorts(svcKey)
+		s.ports[desired] = svcKey
+	}
 
 	if portInt <= 0 {
-		s.todo[fmt.Sprintf("%s/%s", svc.Namespace, svc.Name)] = true
+		s.todo[svcKey] = true
 	}
 	return nil
 }
@@ -145,12 +373,30 @@ func (s *NodePortStrategy) Add(svc *v1.Service) error {
 // Restores the service type and cleans various annotations
 // Clears the service form the todo list
 func (s *NodePortStrategy) Clean(svc *v1.Service) error {
-	delete(s.todo, fmt.Sprintf("%s/%s", svc.Namespace, svc.Name))
+	svcKey := fmt.Sprintf("%s/%s", svc.Namespace, svc.Name)
+	s.lock.Lock()
+	delete(s.todo, svcKey)
+	delete(s.services, svcKey)
+	s.releasePorts(svcKey)
+	s.lock.Unlock()
 	clone := svc.DeepCopy()
-	if !removeServiceAnnotation(clone) {
+	removed := removeServiceAnnotation(clone)
+	typeRestored, err := restoreOriginalSpec(clone, "type")
+	if err != nil {
+		return err
+	}
+	restored, err := restoreOriginalSpec(clone, "externalIPs", nodePortsField)
+	if err != nil {
+		return err
+	}
+	if !removed && !typeRestored && !restored {
 		return nil
 	}
-	clone.Spec.Type = v1.ServiceTypeClusterIP
+	delete(clone.Annotations, ExposeURLsAnnotationKey)
+	delete(clone.Annotations, ExposeStatusAnnotationKey)
+	if !typeRestored {
+		clone.Spec.Type = v1.ServiceTypeClusterIP
+	}
 
 	patch, err := createServicePatch(svc, clone)
 	if err != nil {
@@ -170,7 +416,70 @@ func (s *NodePortStrategy) Clean(svc *v1.Service) error {
 // Delete is called when an exposed service is deleted
 // Clears the service form the todo list
 func (s *NodePortStrategy) Delete(svc *v1.Service) error {
-	delete(s.todo, fmt.Sprintf("%s/%s", svc.Namespace, svc.Name))
+	svcKey := fmt.Sprintf("%s/%s", svc.Namespace, svc.Name)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.todo, svcKey)
+	delete(s.services, svcKey)
+	s.releasePorts(svcKey)
 
 	return nil
 }
+
+// getDesiredNodePort returns the node port to set on the service, or 0 to let the apiserver choose
+// Uses the fabric8.io/nodePort annotation, or hashes the service key in the configured range
+func (s *NodePortStrategy) getDesiredNodePort(svcKey string, svc *v1.Service) (int32, error) {
+	if value := svc.Annotations[NodePortAnnotationKey]; value != "" {
+		port, err := strconv.ParseInt(value, 10, 32)
+		if err != nil || port <= 0 {
+			return 0, errors.Errorf("invalid node port \"%s\" in annotation \"%s\" of service %s",
+				value, NodePortAnnotationKey, svcKey)
+		}
+		if owner := s.ports[int32(port)]; owner != "" && owner != svcKey {
+			return 0, errors.Errorf("node port %d of service %s is already used by service %s", port, svcKey, owner)
+		}
+		return int32(port), nil
+	}
+	if s.portMax == 0 {
+		return 0, nil
+	}
+
+	// the same service always gets the same port, unless it is used by another service
+	size := uint32(s.portMax - s.portMin + 1)
+	hash := fnv.New32a()
+	hash.Write([]byte(svcKey))
+	start := hash.Sum32() % size
+	for i := uint32(0); i < size; i++ {
+		port := s.portMin + int32((start+i)%size)
+		if owner := s.ports[port]; owner == "" || owner == svcKey {
+			return port, nil
+		}
+	}
+	return 0, errors.Errorf("no node port available in range %d-%d for service %s", s.portMin, s.portMax, svcKey)
+}
+
+// releasePorts frees the node ports used by the service
+func (s *NodePortStrategy) releasePorts(svcKey string) {
+	for port, owner := range s.ports {
+		if owner == svcKey {
+			delete(s.ports, port)
+		}
+	}
+}
+
+// reportStatus writes the status annotation of the service
+func (s *NodePortStrategy) reportStatus(svc *v1.Service, status string) {
+	clone := svc.DeepCopy()
+	if clone.Annotations == nil {
+		clone.Annotations = map[string]string{}
+	}
+	clone.Annotations[ExposeStatusAnnotationKey] = status
+	patch, err := createServicePatch(svc, clone)
+	if err == nil && patch != nil {
+		_, err = s.client.CoreV1().Services(svc.Namespace).
+			Patch(svc.Name, patchType, patch)
+	}
+	if err != nil {
+		klog.Errorf("failed to report the status of service %s/%s: %v", svc.Namespace, svc.Name, err)
+	}
+}
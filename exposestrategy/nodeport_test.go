@@ -2,6 +2,7 @@ package exposestrategy
 
 import (
 	"testing"
+	"time"
 
 	"k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -22,13 +23,13 @@ func TestNodePortStrategy_NodeIP(t *testing.T) {
 	})
 	strategy, err := NewNodePortStrategy(client, &Config{})
 	if assert.NoError(t, err) {
-		assert.Equal(t, "my-external-ip", strategy.(*NodePortStrategy).nodeIP)
+		assert.Equal(t, []string{"my-external-ip"}, strategy.(*NodePortStrategy).nodeIPs)
 	}
 	strategy, err = NewNodePortStrategy(client, &Config{
 		NodeIP: "my-node-ip",
 	})
 	if assert.NoError(t, err) {
-		assert.Equal(t, "my-node-ip", strategy.(*NodePortStrategy).nodeIP)
+		assert.Equal(t, []string{"my-node-ip"}, strategy.(*NodePortStrategy).nodeIPs)
 	}
 
 	client = fake.NewSimpleClientset(&v1.Node{
@@ -47,13 +48,13 @@ func TestNodePortStrategy_NodeIP(t *testing.T) {
 	})
 	strategy, err = NewNodePortStrategy(client, &Config{})
 	if assert.NoError(t, err) {
-		assert.Equal(t, "192.168.1.200", strategy.(*NodePortStrategy).nodeIP)
+		assert.Equal(t, []string{"192.168.1.200"}, strategy.(*NodePortStrategy).nodeIPs)
 	}
 	strategy, err = NewNodePortStrategy(client, &Config{
 		NodeIP: "my-node-ip",
 	})
 	if assert.NoError(t, err) {
-		assert.Equal(t, "my-node-ip", strategy.(*NodePortStrategy).nodeIP)
+		assert.Equal(t, []string{"my-node-ip"}, strategy.(*NodePortStrategy).nodeIPs)
 	}
 
 	client = fake.NewSimpleClientset(&v1.Node{
@@ -69,13 +70,13 @@ func TestNodePortStrategy_NodeIP(t *testing.T) {
 	})
 	strategy, err = NewNodePortStrategy(client, &Config{})
 	if assert.NoError(t, err) {
-		assert.Equal(t, "192.168.1.100", strategy.(*NodePortStrategy).nodeIP)
+		assert.Equal(t, []string{"192.168.1.100"}, strategy.(*NodePortStrategy).nodeIPs)
 	}
 	strategy, err = NewNodePortStrategy(client, &Config{
 		NodeIP: "my-node-ip",
 	})
 	if assert.NoError(t, err) {
-		assert.Equal(t, "my-node-ip", strategy.(*NodePortStrategy).nodeIP)
+		assert.Equal(t, []string{"my-node-ip"}, strategy.(*NodePortStrategy).nodeIPs)
 	}
 
 	client = fake.NewSimpleClientset(&v1.Node{
@@ -89,7 +90,7 @@ func TestNodePortStrategy_NodeIP(t *testing.T) {
 		NodeIP: "my-node-ip",
 	})
 	if assert.NoError(t, err) {
-		assert.Equal(t, "my-node-ip", strategy.(*NodePortStrategy).nodeIP)
+		assert.Equal(t, []string{"my-node-ip"}, strategy.(*NodePortStrategy).nodeIPs)
 	}
 }
 
@@ -136,8 +137,9 @@ func TestNodePortStrategy_Add(t *testing.T) {
 				Namespace:   "ns",
 				Name:        "svc",
 				Annotations: map[string]string{
-					"test":              "test",
-					ExposeAnnotationKey: "",
+					"test":                    "test",
+					ExposeAnnotationKey:       "",
+					OriginalSpecAnnotationKey: `{"externalIPs":null,"nodePorts":{},"type":"ClusterIP"}`,
 				},
 			},
 			Spec: v1.ServiceSpec{
@@ -156,8 +158,9 @@ func TestNodePortStrategy_Add(t *testing.T) {
 			Namespace:   "ns",
 			Name:        "svc",
 			Annotations: map[string]string{
-				"test":              "test",
-				ExposeAnnotationKey: "",
+				"test":                    "test",
+				ExposeAnnotationKey:       "",
+				OriginalSpecAnnotationKey: `{"externalIPs":null,"nodePorts":{},"type":"ClusterIP"}`,
 			},
 		},
 		Spec: v1.ServiceSpec{
@@ -175,7 +178,8 @@ func TestNodePortStrategy_Add(t *testing.T) {
 			Namespace:   "ns",
 			Name:        "svc",
 			Annotations: map[string]string{
-				ExposeAnnotationKey: "",
+				ExposeAnnotationKey:       "",
+				OriginalSpecAnnotationKey: `{"externalIPs":null,"nodePorts":{},"type":"ClusterIP"}`,
 			},
 		},
 		Spec: v1.ServiceSpec{
@@ -195,8 +199,9 @@ func TestNodePortStrategy_Add(t *testing.T) {
 				Namespace:   "ns",
 				Name:        "svc",
 				Annotations: map[string]string{
-					"test":              "test",
-					ExposeAnnotationKey: "http://my-node-ip:5678",
+					"test":                    "test",
+					ExposeAnnotationKey:       "http://my-node-ip:5678",
+					OriginalSpecAnnotationKey: `{"externalIPs":null,"nodePorts":{},"type":"ClusterIP"}`,
 				},
 			},
 			Spec: v1.ServiceSpec{
@@ -221,6 +226,7 @@ func TestNodePortStrategy_Clean(t *testing.T) {
 			Annotations: map[string]string{
 				"test": "test",
 				ExposeAnnotationKey: "",
+				OriginalSpecAnnotationKey: `{"externalIPs":null,"nodePorts":{},"type":"ClusterIP"}`,
 			},
 		},
 		Spec: v1.ServiceSpec{
@@ -265,7 +271,8 @@ func TestNodePortStrategy_Clean(t *testing.T) {
 			Namespace:   "ns1",
 			Name:        "svc1",
 			Annotations: map[string]string{
-				ExposeAnnotationKey: "",
+				ExposeAnnotationKey:       "",
+				OriginalSpecAnnotationKey: `{"externalIPs":null,"nodePorts":{},"type":"ClusterIP"}`,
 			},
 		},
 		Spec: v1.ServiceSpec{
@@ -305,6 +312,31 @@ func TestNodePortStrategy_Clean(t *testing.T) {
 	}
 
 	assert.True(t, strategy.HasSynced(), "synced")
+
+	// the original spec without the type still falls back to ClusterIP
+	svc3 := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "ns3",
+			Name:      "svc3",
+			Annotations: map[string]string{
+				ExposeAnnotationKey:       "http://my-node-ip:30080",
+				OriginalSpecAnnotationKey: `{"externalIPs":["10.0.0.1"]}`,
+			},
+		},
+		Spec: v1.ServiceSpec{
+			Type:  v1.ServiceTypeNodePort,
+			Ports: []v1.ServicePort{{Port: 1234, NodePort: 30080}},
+		},
+	}
+	_, err = client.CoreV1().Services("ns3").Create(svc3.DeepCopy())
+	require.NoError(t, err)
+	require.NoError(t, strategy.Clean(svc3))
+	svc, err = client.CoreV1().Services("ns3").Get("svc3", metav1.GetOptions{})
+	if assert.NoError(t, err) {
+		assert.Equal(t, v1.ServiceTypeClusterIP, svc.Spec.Type)
+		assert.Equal(t, []string{"10.0.0.1"}, svc.Spec.ExternalIPs)
+		assert.Empty(t, svc.Annotations)
+	}
 }
 
 func TestNodePortStrategy_Delete(t *testing.T) {
@@ -313,7 +345,8 @@ func TestNodePortStrategy_Delete(t *testing.T) {
 			Namespace:   "ns",
 			Name:        "svc",
 			Annotations: map[string]string{
-				ExposeAnnotationKey: "",
+				ExposeAnnotationKey:       "",
+				OriginalSpecAnnotationKey: `{"externalIPs":null,"nodePorts":{},"type":"ClusterIP"}`,
 			},
 		},
 		Spec: v1.ServiceSpec{
@@ -345,3 +378,196 @@ func TestNodePortStrategy_Delete(t *testing.T) {
 	require.NoError(t, err)
 	assert.True(t, strategy.HasSynced(), "usynced")
 }
+
+func newNodePortNode(name, ip string, ready bool, labels map[string]string) *v1.Node {
+	status := v1.ConditionTrue
+	if !ready {
+		status = v1.ConditionFalse
+	}
+	return &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: labels,
+		},
+		Status: v1.NodeStatus{
+			Addresses: []v1.NodeAddress{{
+				Type:    v1.NodeInternalIP,
+				Address: ip,
+			}},
+			Conditions: []v1.NodeCondition{{
+				Type:   v1.NodeReady,
+				Status: status,
+			}},
+		},
+	}
+}
+
+func TestNodePortStrategy_MultiNode(t *testing.T) {
+	edge := map[string]string{"pool": "edge"}
+	svc := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "ns",
+			Name:      "svc",
+		},
+		Spec: v1.ServiceSpec{
+			Type: v1.ServiceTypeNodePort,
+			Ports: []v1.ServicePort{{
+				Port:     1234,
+				NodePort: 30080,
+			}},
+		},
+	}
+	client := fake.NewSimpleClientset(
+		newNodePortNode("node-b", "10.0.0.2", true, edge),
+		newNodePortNode("node-a", "10.0.0.1", true, edge),
+		newNodePortNode("node-c", "10.0.0.3", false, edge),
+		newNodePortNode("node-d", "10.0.0.4", true, nil),
+		svc.DeepCopy(),
+	)
+
+	_, err := NewNodePortStrategy(client, &Config{NodeSelector: "pool=other"})
+	assert.Error(t, err, "no node")
+	_, err = NewNodePortStrategy(client, &Config{NodeSelector: "pool in edge"})
+	assert.Error(t, err, "invalid selector")
+
+	// the controller exposes the services again from its store
+	var strategy ExposeStrategy
+	refreshed := make(chan string, 10)
+	refresh := func(namespace, name string) {
+		refreshed <- namespace + "/" + name
+		svc, err := client.CoreV1().Services(namespace).Get(name, metav1.GetOptions{})
+		if assert.NoError(t, err) {
+			assert.NoError(t, strategy.Add(svc))
+		}
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	strategy, err = NewNodePortStrategy(client, &Config{NodeSelector: "pool=edge", Refresh: refresh, Stop: stop})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.1", "10.0.0.2"}, strategy.(*NodePortStrategy).nodeIPs)
+
+	// the nodes of the kind clusters are all used when the exposer is chosen
+	kindClient := fake.NewSimpleClientset(
+		newNodePortNode("kind-control-plane", "172.18.0.2", true, nil),
+		newNodePortNode("kind-worker", "172.18.0.3", true, nil),
+	)
+	kind, err := NewNodePortStrategy(kindClient, &Config{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"172.18.0.2", "172.18.0.3"}, kind.(*NodePortStrategy).nodeIPs)
+	// the ports of the kind clusters are mapped to the host when detected automatically
+	kind, err = NewAutoStrategy(kindClient, &Config{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"127.0.0.1"}, kind.(*NodePortStrategy).nodeIPs)
+	kind, err = NewAutoStrategy(kindClient, &Config{NodeIP: "172.18.0.3"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"172.18.0.3"}, kind.(*NodePortStrategy).nodeIPs)
+	require.NoError(t, strategy.Sync())
+
+	err = strategy.Add(svc.DeepCopy())
+	require.NoError(t, err)
+	updated, err := client.CoreV1().Services("ns").Get("svc", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		ExposeAnnotationKey:       "http://10.0.0.1:30080",
+		ExposeURLsAnnotationKey:   "http://10.0.0.1:30080,http://10.0.0.2:30080",
+		OriginalSpecAnnotationKey: `{"externalIPs":null,"nodePorts":{"1234":30080},"type":"NodePort"}`,
+	}, updated.Annotations)
+
+	// the not ready node becomes ready, the first node leaves
+	_, err = client.CoreV1().Nodes().Update(newNodePortNode("node-c", "10.0.0.3", true, edge))
+	require.NoError(t, err)
+	err = client.CoreV1().Nodes().Delete("node-a", &metav1.DeleteOptions{})
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		updated, err := client.CoreV1().Services("ns").Get("svc", metav1.GetOptions{})
+		return err == nil &&
+			updated.Annotations[ExposeAnnotationKey] == "http://10.0.0.2:30080" &&
+			updated.Annotations[ExposeURLsAnnotationKey] == "http://10.0.0.2:30080,http://10.0.0.3:30080"
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, "ns/svc", <-refreshed)
+
+	err = strategy.Clean(updated)
+	require.NoError(t, err)
+	updated, err = client.CoreV1().Services("ns").Get("svc", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, updated.Annotations)
+}
+
+func TestNodePortStrategy_NodePortAllocation(t *testing.T) {
+	newService := func(namespace, name string, nodePort int32) *v1.Service {
+		return &v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace:   namespace,
+				Name:        name,
+				Annotations: map[string]string{},
+			},
+			Spec: v1.ServiceSpec{
+				Type: v1.ServiceTypeNodePort,
+				Ports: []v1.ServicePort{{
+					Port:     80,
+					NodePort: nodePort,
+				}},
+			},
+		}
+	}
+	explicit := newService("ns", "explicit", 0)
+	explicit.Annotations[NodePortAnnotationKey] = "30005"
+	conflict := newService("ns", "conflict", 0)
+	conflict.Annotations[NodePortAnnotationKey] = "30005"
+	invalid := newService("ns", "invalid", 0)
+	invalid.Annotations[NodePortAnnotationKey] = "port"
+	hashed := newService("ns", "hashed", 31234)
+	client := fake.NewSimpleClientset(explicit.DeepCopy(), conflict.DeepCopy(), invalid.DeepCopy(), hashed.DeepCopy())
+
+	_, err := NewNodePortStrategy(client, &Config{NodeIP: "my-node-ip", NodePortRange: "30009-30000"})
+	assert.Error(t, err, "invalid range")
+	strategy, err := NewNodePortStrategy(client, &Config{NodeIP: "my-node-ip", NodePortRange: "30000-30009"})
+	require.NoError(t, err)
+	require.NoError(t, strategy.Sync())
+
+	err = strategy.Add(explicit.DeepCopy())
+	require.NoError(t, err)
+	svc, err := client.CoreV1().Services("ns").Get("explicit", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, int32(30005), svc.Spec.Ports[0].NodePort)
+	assert.Equal(t, "http://my-node-ip:30005", svc.Annotations[ExposeAnnotationKey])
+	assert.Equal(t, "Allocated node port 30005", svc.Annotations[ExposeStatusAnnotationKey])
+
+	err = strategy.Add(conflict.DeepCopy())
+	assert.Error(t, err)
+	svc, err = client.CoreV1().Services("ns").Get("conflict", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, int32(0), svc.Spec.Ports[0].NodePort)
+	assert.Equal(t, "Conflict: node port 30005 of service ns/conflict is already used by service ns/explicit",
+		svc.Annotations[ExposeStatusAnnotationKey])
+
+	err = strategy.Add(invalid.DeepCopy())
+	assert.Error(t, err)
+
+	// the hashed port doesn't change between runs
+	err = strategy.Add(hashed.DeepCopy())
+	require.NoError(t, err)
+	svc, err = client.CoreV1().Services("ns").Get("hashed", metav1.GetOptions{})
+	require.NoError(t, err)
+	port := svc.Spec.Ports[0].NodePort
+	assert.True(t, port >= 30000 && port <= 30009 && port != 30005, "port %d in range", port)
+	err = strategy.Add(svc.DeepCopy())
+	require.NoError(t, err)
+	svc, err = client.CoreV1().Services("ns").Get("hashed", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, port, svc.Spec.Ports[0].NodePort)
+	other, err := NewNodePortStrategy(client, &Config{NodeIP: "my-node-ip", NodePortRange: "30000-30009"})
+	require.NoError(t, err)
+	require.NoError(t, other.Sync())
+	recreated := newService("ns", "hashed", 0)
+	require.NoError(t, other.Add(recreated))
+	svc, err = client.CoreV1().Services("ns").Get("hashed", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, port, svc.Spec.Ports[0].NodePort)
+
+	// the port is released when the service is cleaned
+	err = strategy.Clean(explicit)
+	require.NoError(t, err)
+	err = strategy.Add(conflict.DeepCopy())
+	assert.NoError(t, err)
+}
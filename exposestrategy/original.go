@@ -0,0 +1,154 @@
+package exposestrategy
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/pkg/errors"
+
+	"k8s.io/api/core/v1"
+)
+
+// OriginalSpecAnnotationKey annotation holds the fields of the service spec before they were changed by the controller, JSON format
+const OriginalSpecAnnotationKey = "fabric8.io/originalSpec"
+
+// nodePortsField is the pseudo field of the original spec holding the node ports of the service, by port
+const nodePortsField = "nodePorts"
+
+// recordOriginalSpec records the given fields of the service spec in the annotation of the clone
+// The fields already recorded in the clone are kept, as they hold the values before the service was exposed
+func recordOriginalSpec(svc, clone *v1.Service, fields ...string) error {
+	original, err := getOriginalSpec(clone)
+	if err != nil {
+		return err
+	}
+	if original == nil {
+		original = map[string]interface{}{}
+	}
+	spec, err := specToMap(&svc.Spec)
+	if err != nil {
+		return err
+	}
+	changed := false
+	for _, field := range fields {
+		if _, ok := original[field]; ok {
+			continue
+		}
+		changed = true
+		if field == nodePortsField {
+			nodePorts := map[string]interface{}{}
+			for _, port := range svc.Spec.Ports {
+				if port.NodePort > 0 {
+					nodePorts[strconv.Itoa(int(port.Port))] = port.NodePort
+				}
+			}
+			original[field] = nodePorts
+		} else {
+			// nil when not set, to be unset on restore
+			original[field] = spec[field]
+		}
+	}
+	if !changed {
+		return nil
+	}
+	data, err := json.Marshal(original)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode the original spec")
+	}
+	if clone.Annotations == nil {
+		clone.Annotations = map[string]string{}
+	}
+	clone.Annotations[OriginalSpecAnnotationKey] = string(data)
+	return nil
+}
+
+// restoreOriginalSpec restores the given fields of the service spec from the annotation
+// and removes them from the annotation
+// returns false if the annotation doesn't exist
+func restoreOriginalSpec(svc *v1.Service, fields ...string) (bool, error) {
+	original, err := getOriginalSpec(svc)
+	if err != nil || original == nil {
+		return false, err
+	}
+	spec, err := specToMap(&svc.Spec)
+	if err != nil {
+		return false, err
+	}
+	var nodePorts map[string]interface{}
+	found := false
+	for _, field := range fields {
+		value, ok := original[field]
+		if !ok {
+			continue
+		}
+		found = true
+		delete(original, field)
+		if field == nodePortsField {
+			nodePorts, _ = value.(map[string]interface{})
+			if nodePorts == nil {
+				nodePorts = map[string]interface{}{}
+			}
+		} else if value == nil {
+			delete(spec, field)
+		} else {
+			spec[field] = value
+		}
+	}
+	if !found {
+		return false, nil
+	}
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to encode the spec")
+	}
+	restored := v1.ServiceSpec{}
+	err = json.Unmarshal(data, &restored)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to restore the original spec")
+	}
+	if nodePorts != nil {
+		for i := range restored.Ports {
+			nodePort, _ := nodePorts[strconv.Itoa(int(restored.Ports[i].Port))].(float64)
+			restored.Ports[i].NodePort = int32(nodePort)
+		}
+	}
+	svc.Spec = restored
+
+	if len(original) == 0 {
+		delete(svc.Annotations, OriginalSpecAnnotationKey)
+	} else {
+		data, err = json.Marshal(original)
+		if err != nil {
+			return false, errors.Wrap(err, "failed to encode the original spec")
+		}
+		svc.Annotations[OriginalSpecAnnotationKey] = string(data)
+	}
+	return true, nil
+}
+
+func getOriginalSpec(svc *v1.Service) (map[string]interface{}, error) {
+	value := svc.Annotations[OriginalSpecAnnotationKey]
+	if value == "" {
+		return nil, nil
+	}
+	original := map[string]interface{}{}
+	err := json.Unmarshal([]byte(value), &original)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse annotation \"%s\" of service %s/%s",
+			OriginalSpecAnnotationKey, svc.Namespace, svc.Name)
+	}
+	return original, nil
+}
+
+func specToMap(spec *v1.ServiceSpec) (map[string]interface{}, error) {
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode the spec")
+	}
+	result := map[string]interface{}{}
+	err = json.Unmarshal(data, &result)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode the spec")
+	}
+	return result, nil
+}
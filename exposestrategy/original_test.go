@@ -0,0 +1,102 @@
+package exposestrategy
+
+import (
+	"testing"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOriginalSpec_Restore(t *testing.T) {
+	original := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "ns",
+			Name:        "svc",
+			Annotations: map[string]string{"test": "test"},
+		},
+		Spec: v1.ServiceSpec{
+			Type:        v1.ServiceTypeLoadBalancer,
+			ExternalIPs: []string{"192.168.1.10"},
+			Ports: []v1.ServicePort{{
+				Port:     80,
+				NodePort: 31000,
+			}},
+		},
+	}
+	client := fake.NewSimpleClientset(original.DeepCopy())
+	strategy, err := NewNodePortStrategy(client, &Config{NodeIP: "my-node-ip"})
+	require.NoError(t, err)
+	require.NoError(t, strategy.Sync())
+
+	err = strategy.Add(original.DeepCopy())
+	require.NoError(t, err)
+	svc, err := client.CoreV1().Services("ns").Get("svc", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, v1.ServiceTypeNodePort, svc.Spec.Type)
+	assert.Empty(t, svc.Spec.ExternalIPs)
+
+	// the original spec is kept when the service is added again
+	svc.Spec.Ports[0].NodePort = 32000
+	_, err = client.CoreV1().Services("ns").Update(svc)
+	require.NoError(t, err)
+	err = strategy.Add(svc.DeepCopy())
+	require.NoError(t, err)
+	svc, err = client.CoreV1().Services("ns").Get("svc", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, `{"externalIPs":["192.168.1.10"],"nodePorts":{"80":31000},"type":"LoadBalancer"}`,
+		svc.Annotations[OriginalSpecAnnotationKey])
+
+	err = strategy.Clean(svc)
+	require.NoError(t, err)
+	svc, err = client.CoreV1().Services("ns").Get("svc", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, original, svc)
+}
+
+func TestOriginalSpec_Partial(t *testing.T) {
+	svc := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "ns",
+			Name:      "svc",
+		},
+		Spec: v1.ServiceSpec{
+			Type:        v1.ServiceTypeClusterIP,
+			ExternalIPs: []string{"192.168.1.10"},
+		},
+	}
+	clone := svc.DeepCopy()
+	require.NoError(t, recordOriginalSpec(svc, clone, "type", "externalIPs"))
+	clone.Spec.Type = v1.ServiceTypeLoadBalancer
+	clone.Spec.ExternalIPs = nil
+
+	// only the requested fields are restored and removed from the annotation
+	restored, err := restoreOriginalSpec(clone, "type")
+	require.NoError(t, err)
+	assert.True(t, restored)
+	assert.Equal(t, v1.ServiceTypeClusterIP, clone.Spec.Type)
+	assert.Empty(t, clone.Spec.ExternalIPs)
+	assert.Equal(t, `{"externalIPs":["192.168.1.10"]}`, clone.Annotations[OriginalSpecAnnotationKey])
+
+	restored, err = restoreOriginalSpec(clone, "externalIPs")
+	require.NoError(t, err)
+	assert.True(t, restored)
+	assert.Equal(t, []string{"192.168.1.10"}, clone.Spec.ExternalIPs)
+	assert.NotContains(t, clone.Annotations, OriginalSpecAnnotationKey)
+
+	restored, err = restoreOriginalSpec(clone, "type")
+	require.NoError(t, err)
+	assert.False(t, restored)
+
+	// the annotation of other fields does not restore the type
+	clone.Annotations[OriginalSpecAnnotationKey] = `{"externalIPs":null}`
+	clone.Spec.Type = v1.ServiceTypeNodePort
+	restored, err = restoreOriginalSpec(clone, "type")
+	require.NoError(t, err)
+	assert.False(t, restored)
+	assert.Equal(t, v1.ServiceTypeNodePort, clone.Spec.Type)
+	assert.Equal(t, `{"externalIPs":null}`, clone.Annotations[OriginalSpecAnnotationKey])
+}
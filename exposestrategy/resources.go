@@ -0,0 +1,131 @@
+package exposestrategy
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/pkg/errors"
+	"k8s.io/klog"
+
+	"k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+)
+
+// newOwnedResource creates a custom resource owned by the service
+// with the labels and annotations of the resources generated by the controller
+func newOwnedResource(svc *v1.Service, apiVersion, kind, name string, content map[string]interface{}) *unstructured.Unstructured {
+	object := &unstructured.Unstructured{
+		Object: content,
+	}
+	object.SetAPIVersion(apiVersion)
+	object.SetKind(kind)
+	object.SetNamespace(svc.Namespace)
+	object.SetName(name)
+	object.SetLabels(map[string]string{
+		"provider": "fabric8",
+	})
+	object.SetAnnotations(map[string]string{
+		"fabric8.io/generated-by": "exposecontroller",
+	})
+	object.SetOwnerReferences([]metav1.OwnerReference{{
+		Kind:       ServiceKind,
+		APIVersion: ServiceAPIVersion,
+		Name:       svc.Name,
+		UID:        svc.UID,
+	}})
+	return object
+}
+
+// applyResource creates or updates a custom resource, if needed
+func applyResource(client dynamic.ResourceInterface, svc *v1.Service, object *unstructured.Unstructured) error {
+	existing, err := client.Get(object.GetName(), metav1.GetOptions{})
+	if err == nil {
+		// if the resource is the same in all points, no need to update
+		if reflect.DeepEqual(object.GetLabels(), existing.GetLabels()) &&
+			reflect.DeepEqual(object.GetAnnotations(), existing.GetAnnotations()) &&
+			reflect.DeepEqual(object.GetOwnerReferences(), existing.GetOwnerReferences()) &&
+			reflect.DeepEqual(resourceContent(object), resourceContent(existing)) {
+			klog.Infof("%s %s/%s already up to date for service %s/%s",
+				object.GetKind(), object.GetNamespace(), object.GetName(), svc.Namespace, svc.Name)
+			return nil
+		}
+		object.SetResourceVersion(existing.GetResourceVersion())
+	} else if !apierrors.IsNotFound(err) {
+		return errors.Wrapf(err, "could not check for existing %s %s/%s",
+			object.GetKind(), object.GetNamespace(), object.GetName())
+	}
+
+	klog.Infof("processing %s %s/%s for service %s/%s",
+		object.GetKind(), object.GetNamespace(), object.GetName(), svc.Namespace, svc.Name)
+	if object.GetResourceVersion() == "" {
+		_, err = client.Create(object, metav1.CreateOptions{})
+		if err != nil {
+			return errors.Wrapf(err, "failed to create %s %s/%s",
+				object.GetKind(), object.GetNamespace(), object.GetName())
+		}
+	} else {
+		_, err = client.Update(object, metav1.UpdateOptions{})
+		if err != nil {
+			return errors.Wrapf(err, "failed to update %s %s/%s",
+				object.GetKind(), object.GetNamespace(), object.GetName())
+		}
+	}
+	return nil
+}
+
+// resourceContent returns the fields of a custom resource, without its metadata and status
+func resourceContent(object *unstructured.Unstructured) map[string]interface{} {
+	content := map[string]interface{}{}
+	for key, value := range object.Object {
+		if key != "apiVersion" && key != "kind" && key != "metadata" && key != "status" {
+			content[key] = value
+		}
+	}
+	return content
+}
+
+func deleteResource(client dynamic.ResourceInterface, object *unstructured.Unstructured) {
+	resourceVersion := object.GetResourceVersion()
+	options := metav1.DeleteOptions{
+		Preconditions: &metav1.Preconditions{
+			ResourceVersion: &resourceVersion,
+		},
+	}
+	klog.Infof("cleaning the %s %s/%s", object.GetKind(), object.GetNamespace(), object.GetName())
+	err := client.Delete(object.GetName(), &options)
+	if err != nil {
+		klog.Errorf("error when deleting %s %s/%s: %s",
+			object.GetKind(), object.GetNamespace(), object.GetName(), err)
+	}
+}
+
+// normalizeYAMLValue converts a value parsed from YAML to a value usable in a custom resource
+func normalizeYAMLValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			m[fmt.Sprint(key)] = normalizeYAMLValue(item)
+		}
+		return m
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			m[key] = normalizeYAMLValue(item)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(v))
+		for i, item := range v {
+			s[i] = normalizeYAMLValue(item)
+		}
+		return s
+	case int:
+		return int64(v)
+	default:
+		return v
+	}
+}
@@ -1,12 +1,16 @@
 package exposestrategy
 
 import (
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 
 	"k8s.io/api/core/v1"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
 )
 
 // ExposeStrategy represents a strategy
@@ -32,7 +36,64 @@ type Config struct {
 	TLSAcme        bool
 	URLTemplate    string
 	PathMode       string
-	IngressClass   string
+	// PathTemplate is the template of the paths in path mode
+	PathTemplate string
+	IngressClass string
+	// AllowedHosts are the host patterns the services can be exposed on, any if empty
+	AllowedHosts []string
+	// ClusterName is the name of the cluster, for the url templates
+	ClusterName string
+	// Domains are the named domains the services can choose
+	Domains map[string]DomainProfile
+	// DomainRules choose the named domain of the services, the first matching rule wins
+	DomainRules []DomainRule
+
+	// AmbassadorMode is either "annotation" or "crd"
+	AmbassadorMode       string
+	AmbassadorAPIVersion string
+
+	// WildcardDNS is the wildcard DNS provider of the automatic domains, like "nip.io" or "sslip.io"
+	WildcardDNS string
+
+	// NodeSelector selects the nodes of the nodeport strategy, label selector format
+	NodeSelector string
+	// NodePortRange is the range of the node ports allocated by the nodeport strategy, like "30000-30099"
+	NodePortRange string
+
+	// LoadBalancerTimeout is the time to wait for the load balancers to be provisioned in run mode
+	LoadBalancerTimeout time.Duration
+	// LoadBalancerProfile is the default profile of the load balancer services
+	LoadBalancerProfile string
+	// LoadBalancerProfiles are custom profiles, added to DefaultLoadBalancerProfiles
+	LoadBalancerProfiles map[string]LoadBalancerProfile
+	// LoadBalancerIPs is the pool of IPs to allocate to the load balancers
+	LoadBalancerIPs []string
+	// LoadBalancerIPLedger is the ConfigMap recording the allocated IPs, "[namespace/]name"
+	LoadBalancerIPLedger string
+
+	// ExternalIPs is the pool of IPs of the externalip strategy
+	ExternalIPs []string
+
+	// Webhook* configure the webhook strategy
+	WebhookURL     string
+	WebhookSecret  string
+	WebhookTimeout time.Duration
+	WebhookRetries int
+
+	// Namespaces are the settings overridden by the namespace annotations and the policies
+	Namespaces *NamespaceOverrides
+
+	// Claims are the hosts and paths claimed by the services, shared by the strategies
+	Claims *HostClaims
+	// Recorder records the events of the services
+	Recorder record.EventRecorder
+	// Refresh asks the controller to expose the service again, through all its exposers
+	Refresh func(namespace, name string)
+	// Stop is closed when the controller stops, ending the watches of the strategies
+	Stop <-chan struct{}
+
+	// DynamicClient is used to manage custom resources
+	DynamicClient dynamic.Interface
 }
 
 type label struct {
@@ -49,10 +110,16 @@ var (
 	InjectAnnotation              = label{Key: "fabric8.io/inject", Value: "true"}
 	// ExposeHostNameAsAnnotationKey annotation sets the hostname to use
 	ExposeHostNameAsAnnotationKey = "fabric8.io/exposeHostNameAs"
+	// ExposerAnnotationKey annotation selects the exposers of the service, comma separated
+	ExposerAnnotationKey          = "fabric8.io/exposer"
 	// ExposeAnnotationKey annotation will be created with the exposed url
 	ExposeAnnotationKey           = "fabric8.io/exposeURL"
 	// ExposePortAnnotationKey annotation sets the service port to export
 	ExposePortAnnotationKey       = "fabric8.io/exposePort"
+	// ExposeURLsAnnotationKey annotation will be created with all the exposed urls, comma separated
+	ExposeURLsAnnotationKey       = "fabric8.io/exposeURLs"
+	// ExposeStatusAnnotationKey annotation will be created with the status of the exposure
+	ExposeStatusAnnotationKey     = "fabric8.io/exposeStatus"
 	// APIServicePathAnnotationKey annotation sets the path to export
 	APIServicePathAnnotationKey   = "api.service.kubernetes.io/path"
 )
@@ -60,9 +127,12 @@ var (
 type exposeStrategyFunc = func(client kubernetes.Interface, config *Config) (ExposeStrategy, error)
 var exposeStrategyFuncs map[string]exposeStrategyFunc = map[string]exposeStrategyFunc{
 	"ambassador":   NewAmbassadorStrategy,
+	"externalip":   NewExternalIPStrategy,
 	"ingress":      NewIngressStrategy,
+	"kong":         NewKongStrategy,
 	"loadbalancer": NewLoadBalancerStrategy,
 	"nodeport":     NewNodePortStrategy,
+	"webhook":      NewWebhookStrategy,
 }
 
 // New creates a new strategy
@@ -73,17 +143,27 @@ func New(client kubernetes.Interface, config *Config) (ExposeStrategy, error) {
 	}
 
 	f, ok := exposeStrategyFuncs[exposer]
-	if ok {
-		strategy, err := f(client, config)
-		if err != nil {
-			return nil, errors.Wrapf(err, "failed to create %s expose strategy", exposer)
-		}
-		return strategy, nil
+	if !ok {
+		return nil, checkExposer(exposer)
+	}
+	strategy, err := f(client, config)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create %s expose strategy", exposer)
+	}
+	return strategy, nil
+}
+
+// checkExposer checks the exposer is a known strategy
+func checkExposer(exposer string) error {
+	exposer = strings.ToLower(exposer)
+	if _, ok := exposeStrategyFuncs[exposer]; ok || exposer == "" || exposer == "auto" {
+		return nil
 	}
-	strategies := make([]string, 1, 1+len(exposeStrategyFuncs))
-	strategies[0] = "auto"
+	strategies := make([]string, 0, len(exposeStrategyFuncs))
 	for s := range exposeStrategyFuncs {
 		strategies = append(strategies, s)
 	}
-	return nil, errors.Errorf("unknown expose strategy \"%s\", must be one of \"%s\"", exposer, strings.Join(strategies, "\", \""))
+	sort.Strings(strategies)
+	strategies = append([]string{"auto"}, strategies...)
+	return errors.Errorf("unknown expose strategy \"%s\", must be one of \"%s\"", exposer, strings.Join(strategies, "\", \""))
 }
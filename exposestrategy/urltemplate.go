@@ -0,0 +1,278 @@
+package exposestrategy
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	// DefaultURLTemplate is the url template used when none is configured
+	DefaultURLTemplate = "{{.Service}}.{{.Namespace}}.{{.Domain}}"
+	// DefaultPathTemplate is the path template of the path mode used when none is configured
+	DefaultPathTemplate = "/{{.Namespace}}/{{.Service}}"
+	// PathTemplateAnnotationKey annotation sets the path template of the service in path mode
+	PathTemplateAnnotationKey = "fabric8.io/path.template"
+)
+
+// URLTemplateData is the data the url templates are executed with
+type URLTemplateData struct {
+	// Service is the host name of the service, its name by default
+	Service   string
+	Namespace string
+	Domain    string
+	// Name is the actual name of the service
+	Name        string
+	Labels      map[string]string
+	Annotations map[string]string
+	// Release is the helm release of the service, if any
+	Release string
+	// PortName is the name of the exposed port, if any
+	PortName string
+	// Cluster is the configured cluster name
+	Cluster string
+}
+
+// urlTemplateFuncs are the sprig like functions available in the url templates
+var urlTemplateFuncs = template.FuncMap{
+	"lower":      strings.ToLower,
+	"upper":      strings.ToUpper,
+	"trim":       strings.TrimSpace,
+	"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+	"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+	"replace":    func(old, new, s string) string { return strings.Replace(s, old, new, -1) },
+	"trunc": func(length int, s string) string {
+		if length < 0 && len(s)+length > 0 {
+			return s[len(s)+length:]
+		} else if length >= 0 && len(s) > length {
+			return s[:length]
+		}
+		return s
+	},
+	"sha1sum": func(s string) string {
+		sum := sha1.Sum([]byte(s))
+		return hex.EncodeToString(sum[:])
+	},
+	"default": func(value, s string) string {
+		if s == "" {
+			return value
+		}
+		return s
+	},
+}
+
+// TemplateFuncs returns the functions available in the url templates, for the other templates of the controller
+func TemplateFuncs() template.FuncMap {
+	return urlTemplateFuncs
+}
+
+// urlTemplate renders the host names or the paths of the services
+type urlTemplate struct {
+	name string
+	text string
+	tmpl *template.Template
+}
+
+var (
+	// defaultURLTemplate is the parsed DefaultURLTemplate
+	defaultURLTemplate = mustParseTemplate(parseURLTemplate(DefaultURLTemplate))
+	// defaultPathTemplate is the parsed DefaultPathTemplate
+	defaultPathTemplate = mustParseTemplate(parsePathTemplate(DefaultPathTemplate))
+)
+
+func mustParseTemplate(t *urlTemplate, err error) *urlTemplate {
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// parseURLTemplate parses the url template, DefaultURLTemplate if empty
+func parseURLTemplate(text string) (*urlTemplate, error) {
+	if text == "" {
+		text = DefaultURLTemplate
+	}
+	return parseTemplate("urltemplate", text)
+}
+
+// parsePathTemplate parses the path template, DefaultPathTemplate if empty
+func parsePathTemplate(text string) (*urlTemplate, error) {
+	if text == "" {
+		text = DefaultPathTemplate
+	}
+	return parseTemplate("path-template", text)
+}
+
+// parseTemplate parses the template
+// The template is executed for an example service, so that the errors are found before exposing any service
+func parseTemplate(name, text string) (*urlTemplate, error) {
+	// the missing labels and annotations are empty
+	tmpl, err := template.New(name).Funcs(urlTemplateFuncs).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse %s \"%s\"", name, text)
+	}
+	t := &urlTemplate{name: name, text: text, tmpl: tmpl}
+	if _, err = t.execute(exampleURLTemplateData("example.com")); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// exampleURLTemplateData is the data of an example service, to check the url templates
+func exampleURLTemplateData(domain string) *URLTemplateData {
+	return &URLTemplateData{
+		Service:     "my-service",
+		Namespace:   "my-namespace",
+		Domain:      domain,
+		Name:        "my-service",
+		Labels:      map[string]string{},
+		Annotations: map[string]string{},
+		Release:     "my-release",
+		PortName:    "http",
+		Cluster:     "my-cluster",
+	}
+}
+
+// source returns the source of the template
+func (t *urlTemplate) source() string {
+	if t == nil {
+		return DefaultURLTemplate
+	}
+	return t.text
+}
+
+// reparse returns the template of the text, which must be valid, t itself if unchanged
+func (t *urlTemplate) reparse(text string) *urlTemplate {
+	if text == t.source() {
+		return t
+	}
+	// already checked
+	parsed, _ := parseURLTemplate(text)
+	return parsed
+}
+
+func (t *urlTemplate) execute(data *URLTemplateData) (string, error) {
+	var buffer bytes.Buffer
+	if err := t.tmpl.Execute(&buffer, data); err != nil {
+		return "", errors.Wrapf(err, "failed to execute %s \"%s\"", t.name, t.text)
+	}
+	return buffer.String(), nil
+}
+
+// render renders the host name of the service, and checks it is valid
+// a nil template renders DefaultURLTemplate
+func (t *urlTemplate) render(svc *v1.Service, hostName, domain, cluster string) (string, error) {
+	if t == nil {
+		t = defaultURLTemplate
+	}
+	host, err := t.execute(newURLTemplateData(svc, hostName, domain, cluster))
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to render the host of service %s/%s", svc.Namespace, svc.Name)
+	}
+	host = shortenHostLabels(host)
+	if msgs := validation.IsDNS1123Subdomain(host); len(msgs) > 0 {
+		return "", errors.Errorf("invalid host \"%s\" rendered for service %s/%s: %s",
+			host, svc.Namespace, svc.Name, strings.Join(msgs, ", "))
+	}
+	return host, nil
+}
+
+// renderPath renders the path of the service in path mode, from its annotation template if any,
+// and joins it with the path of the service
+// a nil template renders DefaultPathTemplate
+func (t *urlTemplate) renderPath(svc *v1.Service, appName, domain, cluster, path string) (string, error) {
+	if t == nil {
+		t = defaultPathTemplate
+	}
+	if text := svc.Annotations[PathTemplateAnnotationKey]; text != "" {
+		var err error
+		t, err = parsePathTemplate(text)
+		if err != nil {
+			return "", errors.Wrapf(err, "invalid annotation \"%s\" in service %s/%s",
+				PathTemplateAnnotationKey, svc.Namespace, svc.Name)
+		}
+	}
+	prefix, err := t.execute(newURLTemplateData(svc, appName, domain, cluster))
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to render the path of service %s/%s", svc.Namespace, svc.Name)
+	}
+	if strings.ContainsAny(prefix, " \t\n?#") {
+		return "", errors.Errorf("invalid path \"%s\" rendered for service %s/%s", prefix, svc.Namespace, svc.Name)
+	}
+	if path == "" {
+		path = "/"
+	}
+	return URLJoin("/"+strings.TrimPrefix(prefix, "/"), path), nil
+}
+
+// newURLTemplateData returns the data of the service for the templates
+func newURLTemplateData(svc *v1.Service, hostName, domain, cluster string) *URLTemplateData {
+	data := &URLTemplateData{
+		Service:     hostName,
+		Namespace:   svc.Namespace,
+		Domain:      domain,
+		Name:        svc.Name,
+		Labels:      svc.Labels,
+		Annotations: svc.Annotations,
+		Release:     getHelmRelease(svc),
+		PortName:    getExposedPortName(svc),
+		Cluster:     cluster,
+	}
+	if data.Labels == nil {
+		data.Labels = map[string]string{}
+	}
+	if data.Annotations == nil {
+		data.Annotations = map[string]string{}
+	}
+	return data
+}
+
+// shortenHostLabels truncates the DNS labels longer than 63 characters
+// and ends them with a hash of the whole label, to keep them unique
+func shortenHostLabels(host string) string {
+	labels := strings.Split(host, ".")
+	for i, label := range labels {
+		if len(label) <= validation.DNS1123LabelMaxLength {
+			continue
+		}
+		sum := sha1.Sum([]byte(label))
+		hash := hex.EncodeToString(sum[:])[:8]
+		prefix := strings.TrimRight(label[:validation.DNS1123LabelMaxLength-len(hash)-1], "-")
+		labels[i] = prefix + "-" + hash
+	}
+	return strings.Join(labels, ".")
+}
+
+// getHelmRelease returns the helm release of the service, from the helm 3 annotation or the usual labels
+func getHelmRelease(svc *v1.Service) string {
+	if release := svc.Annotations["meta.helm.sh/release-name"]; release != "" {
+		return release
+	}
+	if release := svc.Labels["release"]; release != "" {
+		return release
+	}
+	return svc.Labels["app.kubernetes.io/instance"]
+}
+
+// getExposedPortName returns the name of the port of the expose port annotation, else of the first port
+func getExposedPortName(svc *v1.Service) string {
+	if len(svc.Spec.Ports) == 0 {
+		return ""
+	}
+	if port, err := strconv.Atoi(svc.Annotations[ExposePortAnnotationKey]); err == nil {
+		for _, p := range svc.Spec.Ports {
+			if int(p.Port) == port {
+				return p.Name
+			}
+		}
+	}
+	return svc.Spec.Ports[0].Name
+}
@@ -0,0 +1,130 @@
+package exposestrategy
+
+import (
+	"strings"
+	"testing"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestURLTemplate_Render(t *testing.T) {
+	svc := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "preview",
+			Name:        "my-release-api",
+			Labels:      map[string]string{"release": "my-release", "tier": "backend", "team": "Core"},
+			Annotations: map[string]string{ExposePortAnnotationKey: "8080"},
+		},
+		Spec: v1.ServiceSpec{Ports: []v1.ServicePort{{Name: "grpc", Port: 9090}, {Name: "http", Port: 8080}}},
+	}
+	for _, test := range []struct {
+		template string
+		expected string
+	}{
+		{"", "api.preview.example.com"},
+		{"{{.Service}}.{{.Labels.team | lower}}.{{.Domain}}", "api.core.example.com"},
+		{"{{.Name}}-{{.PortName}}.{{.Cluster}}.{{.Domain}}", "my-release-api-http.prod.example.com"},
+		{"{{.Labels.tier}}-{{.Release}}{{.Labels.missing}}.{{.Domain}}", "backend-my-release.example.com"},
+		{"{{index .Annotations \"fabric8.io/exposePort\"}}.{{.Domain}}", "8080.example.com"},
+		{"{{trunc 3 .Name}}-{{replace \"-\" \"\" .Name}}.{{trunc 7 (sha1sum .Name)}}.{{.Domain}}", "my--myreleaseapi.7162725.example.com"},
+		{"{{.Labels.owner | default \"nobody\"}}.{{.Domain}}", "nobody.example.com"},
+	} {
+		tmpl, err := parseURLTemplate(test.template)
+		require.NoError(t, err, test.template)
+		host, err := tmpl.render(svc, "api", "example.com", "prod")
+		if assert.NoError(t, err, test.template) {
+			assert.Equal(t, test.expected, host, test.template)
+		}
+	}
+
+	var none *urlTemplate
+	host, err := none.render(svc, "api", "example.com", "")
+	require.NoError(t, err)
+	assert.Equal(t, "api.preview.example.com", host)
+
+	tmpl, err := parseURLTemplate("{{.Service}}_{{.Namespace}}.{{.Domain}}")
+	require.NoError(t, err)
+	_, err = tmpl.render(svc, "api", "example.com", "")
+	assert.Error(t, err, "invalid host")
+
+	_, err = parseURLTemplate("{{.Service}}.{{.Unknown}}")
+	assert.Error(t, err, "fails on parse")
+}
+
+func TestShortenHostLabels(t *testing.T) {
+	long := strings.Repeat("a", 60) + "-" + strings.Repeat("b", 10)
+	host := shortenHostLabels(long + ".preview.example.com")
+	labels := strings.Split(host, ".")
+	require.Len(t, labels, 4)
+	assert.Len(t, labels[0], 63)
+	assert.True(t, strings.HasPrefix(labels[0], strings.Repeat("a", 54)+"-"), labels[0])
+	assert.Equal(t, "preview.example.com", strings.Join(labels[1:], "."))
+	assert.NotEqual(t, labels[0], strings.Split(shortenHostLabels(long+"c.example.com"), ".")[0], "unique")
+	assert.Equal(t, "my-service.example.com", shortenHostLabels("my-service.example.com"))
+}
+
+func TestURLTemplate_RenderPath(t *testing.T) {
+	svc := func(annotations map[string]string) *v1.Service {
+		return &v1.Service{ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "preview",
+			Name:        "my-service",
+			Labels:      map[string]string{"team": "core"},
+			Annotations: annotations,
+		}}
+	}
+	api, err := parsePathTemplate("/api/{{.Service}}")
+	require.NoError(t, err)
+	for _, test := range []struct {
+		template *urlTemplate
+		svc      *v1.Service
+		path     string
+		expected string
+	}{
+		{nil, svc(nil), "", "/preview/my-app/"},
+		{nil, svc(nil), "/docs", "/preview/my-app/docs"},
+		{api, svc(nil), "", "/api/my-app/"},
+		{api, svc(map[string]string{PathTemplateAnnotationKey: "{{.Labels.team}}/{{.Service}}"}), "v1", "/core/my-app/v1"},
+	} {
+		path, err := test.template.renderPath(test.svc, "my-app", "example.com", "", test.path)
+		if assert.NoError(t, err) {
+			assert.Equal(t, test.expected, path)
+		}
+	}
+
+	_, err = api.renderPath(svc(map[string]string{PathTemplateAnnotationKey: "/{{.Unknown}}"}), "my-app", "example.com", "", "")
+	assert.Error(t, err, "invalid annotation")
+	_, err = api.renderPath(svc(map[string]string{PathTemplateAnnotationKey: "/{{.Service}}?query"}), "my-app", "example.com", "", "")
+	assert.Error(t, err, "invalid path")
+}
+
+func TestIngressStrategy_PathTemplate(t *testing.T) {
+	client := fake.NewSimpleClientset(&v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "main",
+			Name:        "my-service",
+			Annotations: map[string]string{ExposeAnnotation.Key: ExposeAnnotation.Value},
+		},
+		Spec: v1.ServiceSpec{Ports: []v1.ServicePort{{Port: 80}}},
+	})
+	strategy, err := NewIngressStrategy(client, &Config{
+		Exposer:      "ingress",
+		Domain:       "example.com",
+		HTTP:         true,
+		PathMode:     PathModeUsePath,
+		PathTemplate: "/api/{{.Service}}",
+	})
+	require.NoError(t, err)
+	require.NoError(t, strategy.Sync())
+	service, err := client.CoreV1().Services("main").Get("my-service", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.NoError(t, strategy.Add(service))
+
+	service, err = client.CoreV1().Services("main").Get("my-service", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "http://example.com/api/my-service/", service.Annotations[ExposeAnnotationKey])
+}
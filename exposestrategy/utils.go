@@ -3,13 +3,16 @@ package exposestrategy
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"net"
+	"strconv"
 	"strings"
-	"text/template"
 
 	"github.com/pkg/errors"
+	"k8s.io/klog"
 
 	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/strategicpatch"
 )
@@ -40,11 +43,8 @@ func addServiceAnnotation(svc *v1.Service, hostName string) error {
 }
 
 func addServiceAnnotationWithProtocol(svc *v1.Service, hostName, path, protocol string) error {
-	if svc.Annotations == nil {
-		svc.Annotations = map[string]string{}
-	}
 	if hostName == "" {
-		svc.Annotations[ExposeAnnotationKey] = ""
+		setServiceExposeURL(svc, "", "")
 		return nil
 	}
 
@@ -55,16 +55,33 @@ func addServiceAnnotationWithProtocol(svc *v1.Service, hostName, path, protocol
 	if len(path) > 0 {
 		exposeURL = urlJoin(exposeURL, path)
 	}
-	svc.Annotations[ExposeAnnotationKey] = exposeURL
+	setServiceExposeURL(svc, exposeURL, hostName)
+	return nil
+}
 
-	if key := svc.Annotations[ExposeHostNameAsAnnotationKey]; key != "" {
+// setServiceExposeURL writes the exposed URL and host to the annotations of the service
+func setServiceExposeURL(svc *v1.Service, exposeURL, hostName string) {
+	if svc.Annotations == nil {
+		svc.Annotations = map[string]string{}
+	}
+	if urlKey := svc.Annotations[exposeURLKeyAnnotation]; urlKey != "" {
+		svc.Annotations[urlKey] = exposeURL
+		return
+	}
+	svc.Annotations[ExposeAnnotationKey] = exposeURL
+	if key := svc.Annotations[ExposeHostNameAsAnnotationKey]; key != "" && hostName != "" {
 		svc.Annotations[key] = hostName
 	}
-
-	return nil
 }
 
 func removeServiceAnnotation(svc *v1.Service) bool {
+	if urlKey := svc.Annotations[exposeURLKeyAnnotation]; urlKey != "" {
+		if _, ok := svc.Annotations[urlKey]; !ok {
+			return false
+		}
+		delete(svc.Annotations, urlKey)
+		return true
+	}
 	if _, ok := svc.Annotations[ExposeAnnotationKey]; !ok {
 		return false
 	}
@@ -75,6 +92,27 @@ func removeServiceAnnotation(svc *v1.Service) bool {
 	return true
 }
 
+// exposeURLKeyAnnotation is only set on the copy of a service given to a strategy
+// it tells the strategy to write the exposed URL to another annotation than fabric8.io/exposeURL
+// as it is set on both the original and the modified service, it never ends in a patch
+const exposeURLKeyAnnotation = "fabric8.io/exposeURL.key"
+
+// ExposeURLAnnotationKeyFor returns the annotation holding the URL exposed by an additional exposer of a service
+func ExposeURLAnnotationKeyFor(exposer string) string {
+	return ExposeAnnotationKey + "." + exposer
+}
+
+// WithExposeURLKey returns a copy of the service, telling the strategies to write the exposed URL
+// to the given annotation instead of fabric8.io/exposeURL
+func WithExposeURLKey(svc *v1.Service, key string) *v1.Service {
+	clone := svc.DeepCopy()
+	if clone.Annotations == nil {
+		clone.Annotations = map[string]string{}
+	}
+	clone.Annotations[exposeURLKeyAnnotation] = key
+	return clone
+}
+
 // urlJoin joins the given URL paths so that there is a / separating them but not a double //
 func urlJoin(repo string, path string) string {
 	return strings.TrimSuffix(repo, "/") + "/" + strings.TrimPrefix(path, "/")
@@ -117,27 +155,88 @@ func createServicePatch(origin, modified *v1.Service) ([]byte, error) {
 	return patch, nil
 }
 
-type urlTemplateParts struct {
-	Service   string
-	Namespace string
-	Domain    string
+// getExposePort returns the port of the service to expose
+// the one from the fabric8.io/exposePort annotation if available, or the first one
+func getExposePort(svc *v1.Service) (*v1.ServicePort, error) {
+	if len(svc.Spec.Ports) == 0 {
+		return nil, errors.Errorf("service %s/%s has no ports specified", svc.Namespace, svc.Name)
+	}
+	exposePort := svc.Annotations[ExposePortAnnotationKey]
+	if exposePort != "" {
+		port, err := strconv.Atoi(exposePort)
+		if err != nil {
+			return nil, errors.Wrapf(err, "port \"%s\" provided in the annotation \"%s\" is not a valid number in service %s/%s",
+				exposePort, ExposePortAnnotationKey, svc.Namespace, svc.Name)
+		}
+		for i := range svc.Spec.Ports {
+			if port == int(svc.Spec.Ports[i].Port) {
+				return &svc.Spec.Ports[i], nil
+			}
+		}
+		klog.Warningf("port \"%s\" provided in the annotation \"%s\" is not available in the ports of service %s/%s",
+			exposePort, ExposePortAnnotationKey, svc.Namespace, svc.Name)
+	}
+	return &svc.Spec.Ports[0], nil
 }
 
-func getURLFormat(urltemplate string) (string, error) {
-	if urltemplate == "" {
-		urltemplate = "{{.Service}}.{{.Namespace}}.{{.Domain}}"
+// getAppName returns the name of the app of the service, from fabric8.io/ingress.name,
+// else the name of the service without its helm release prefix
+func getAppName(svc *v1.Service) string {
+	if appName := svc.Annotations["fabric8.io/ingress.name"]; appName != "" {
+		return appName
 	}
-	placeholders := urlTemplateParts{"%[1]s", "%[2]s", "%[3]s"}
-	tmpl, err := template.New("format").Parse(urltemplate)
-	if err != nil {
-		errors.Wrap(err, "Failed to parse URLTemplate")
+	if svc.Labels["release"] != "" {
+		return strings.TrimPrefix(svc.Name, svc.Labels["release"]+"-")
 	}
-	var buffer bytes.Buffer
-	err = tmpl.Execute(&buffer, placeholders)
+	return svc.Name
+}
+
+// getPathMode returns the path mode of the service, from fabric8.io/path.mode, else the configured one
+func getPathMode(svc *v1.Service, pathMode string) string {
+	if mode := svc.Annotations["fabric8.io/path.mode"]; mode != "" {
+		return mode
+	}
+	return pathMode
+}
+
+// getHostAndPath returns the host and the path to expose the service on, from its annotations and the templates
+// in path mode, the service is exposed on the domain itself, with the rendered path
+// otherwise the path starts with "/", or is empty if not set
+func getHostAndPath(svc *v1.Service, appName string, urltemplate, pathTemplate *urlTemplate, domain, clusterName, pathMode string) (string, string, error) {
+	hostName := svc.Annotations["fabric8.io/host.name"]
+	if hostName == "" {
+		hostName = appName
+	}
+	hostName, err := urltemplate.render(svc, hostName, domain, clusterName)
 	if err != nil {
-		errors.Wrap(err, "Failed to execute URLTemplate")
+		return "", "", err
+	}
+	path := svc.Annotations["fabric8.io/ingress.path"]
+	if getPathMode(svc, pathMode) == PathModeUsePath {
+		path, err = pathTemplate.renderPath(svc, appName, domain, clusterName, path)
+		if err != nil {
+			return "", "", err
+		}
+		return domain, path, nil
+	} else if path != "" && path[0] != '/' {
+		path = "/" + path
+	}
+	return hostName, path, nil
+}
+
+// getOwnerService returns the key of the service owning an object generated by the controller
+// returns true if the object was generated by the controller but has no valid owner and should be deleted
+func getOwnerService(object metav1.Object) (string, bool) {
+	if object.GetLabels()["provider"] != "fabric8" || object.GetAnnotations()["fabric8.io/generated-by"] != "exposecontroller" {
+		return "", false
+	}
+	owners := object.GetOwnerReferences()
+	if len(owners) != 1 {
+		return "", true
+	} else if owner := owners[0]; owner.Kind != ServiceKind || owner.APIVersion != ServiceAPIVersion {
+		return "", true
 	}
-	return buffer.String(), nil
+	return fmt.Sprintf("%s/%s", object.GetNamespace(), owners[0].Name), false
 }
 
 // URLJoin joins the given paths so that there is only ever one '/' character between the paths
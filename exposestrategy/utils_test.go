@@ -143,3 +143,44 @@ func TestRemoveServiceAnnotation(t *testing.T) {
 		assert.Equal(t, test.expectedAnnotations, test.svc.Annotations, test.name)
 	}
 }
+
+func TestGetHostAndPath(t *testing.T) {
+	service := func(labels, annotations map[string]string) *v1.Service {
+		return &v1.Service{ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "main",
+			Name:        "my-release-my-app",
+			Labels:      labels,
+			Annotations: annotations,
+		}}
+	}
+	for _, test := range []struct {
+		name     string
+		svc      *v1.Service
+		pathMode string
+		appName  string
+		host     string
+		path     string
+	}{
+		{"default", service(nil, nil), "", "my-release-my-app", "my-release-my-app.main.example.com", ""},
+		{"release", service(map[string]string{"release": "my-release"}, nil), "", "my-app", "my-app.main.example.com", ""},
+		{"annotations", service(nil, map[string]string{
+			"fabric8.io/ingress.name": "app",
+			"fabric8.io/host.name":    "host",
+			"fabric8.io/ingress.path": "api",
+		}), "", "app", "host.main.example.com", "/api"},
+		{"path mode", service(nil, map[string]string{"fabric8.io/ingress.name": "app"}), PathModeUsePath, "app", "example.com", "/main/app/"},
+		{"path mode annotation", service(nil, map[string]string{
+			"fabric8.io/ingress.name": "app",
+			"fabric8.io/path.mode":    PathModeUsePath,
+			"fabric8.io/ingress.path": "api",
+		}), "", "app", "example.com", "/main/app/api"},
+	} {
+		appName := getAppName(test.svc)
+		assert.Equal(t, test.appName, appName, test.name)
+		host, path, err := getHostAndPath(test.svc, appName, nil, nil, "example.com", "", test.pathMode)
+		if assert.NoError(t, err, test.name) {
+			assert.Equal(t, test.host, host, test.name)
+			assert.Equal(t, test.path, path, test.name)
+		}
+	}
+}
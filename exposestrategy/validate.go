@@ -0,0 +1,95 @@
+package exposestrategy
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"k8s.io/apimachinery/pkg/labels"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// Validate checks the config without connecting to the cluster
+// returns all the errors found, aggregated
+func Validate(config *Config) error {
+	errs := []error{}
+	check := func(err error) {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	check(checkExposer(config.Exposer))
+	check(checkURLTemplate(config.URLTemplate, config.Domain))
+	if _, err := parsePathTemplate(config.PathTemplate); err != nil {
+		check(err)
+	}
+	if config.PathMode != "" && config.PathMode != PathModeUsePath {
+		check(errors.Errorf("unknown path mode \"%s\", must be empty or \"%s\"", config.PathMode, PathModeUsePath))
+	}
+	if config.TLSUseWildcard && config.Domain == "" {
+		check(errors.New("tls-use-wildcard requires a domain"))
+	}
+	if config.TLSAcme && config.HTTP {
+		check(errors.New("tls-acme conflicts with http, which disables TLS"))
+	}
+	check(checkAllowedHosts(config.AllowedHosts))
+	_, err := getDomainProfiles(config)
+	check(err)
+	_, _, err = getAmbassadorMode(config)
+	check(err)
+
+	if config.NodeIP != "" && config.NodeSelector != "" {
+		check(errors.New("node-ip conflicts with node-selector"))
+	}
+	if _, err := labels.Parse(config.NodeSelector); err != nil {
+		check(errors.Wrapf(err, "failed to parse node selector \"%s\"", config.NodeSelector))
+	}
+	if config.NodePortRange != "" {
+		_, _, err := parseNodePortRange(config.NodePortRange)
+		check(err)
+	}
+
+	if config.LoadBalancerTimeout < 0 {
+		check(errors.Errorf("loadbalancer-timeout must be positive, got %s", config.LoadBalancerTimeout))
+	}
+	_, err = getLoadBalancerProfiles(config)
+	check(err)
+	check(checkIPs("load balancer", config.LoadBalancerIPs))
+	check(checkIPs("external", config.ExternalIPs))
+	if strings.ToLower(config.Exposer) == "externalip" && len(config.ExternalIPs) == 0 {
+		check(errors.New("external ip strategy requires a pool of external IPs"))
+	}
+
+	if strings.ToLower(config.Exposer) == "webhook" && config.WebhookURL == "" {
+		check(errors.New("webhook strategy requires a webhook url"))
+	}
+	if config.WebhookTimeout < 0 {
+		check(errors.Errorf("webhook-timeout must be positive, got %s", config.WebhookTimeout))
+	}
+	check(checkWebhookConfig(config))
+
+	return utilerrors.NewAggregate(errs)
+}
+
+// checkURLTemplate checks the URL template renders a valid host name for an example service
+func checkURLTemplate(urltemplate, domain string) error {
+	t, err := parseURLTemplate(urltemplate)
+	if err != nil {
+		return err
+	}
+	if domain == "" {
+		domain = "example.com"
+	}
+	host, err := t.execute(exampleURLTemplateData(domain))
+	if err != nil {
+		return err
+	}
+	host = shortenHostLabels(host)
+	if msgs := validation.IsDNS1123Subdomain(host); len(msgs) > 0 {
+		return errors.Errorf("invalid urltemplate \"%s\", renders \"%s\": %s",
+			t.source(), host, strings.Join(msgs, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package exposestrategy
+
+import (
+	"testing"
+
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, Validate(&Config{}), "empty config")
+	assert.NoError(t, Validate(&Config{
+		Exposer:        "Ingress",
+		Domain:         "example.com",
+		URLTemplate:    "{{.Service}}-{{.Namespace}}.{{.Domain}}",
+		TLSUseWildcard: true,
+		PathMode:       PathModeUsePath,
+		NodePortRange:  "30000-30099",
+	}), "valid config")
+
+	examples := []struct {
+		name   string
+		config Config
+	}{
+		{"unknown exposer", Config{Exposer: "router"}},
+		{"unparsable template", Config{URLTemplate: "{{.Service"}},
+		{"unknown path template field", Config{PathTemplate: "/{{.Unknown}}"}},
+		{"unknown template field", Config{URLTemplate: "{{.Unknown}}.{{.Domain}}"}},
+		{"unknown template function", Config{URLTemplate: "{{.Service | title}}.{{.Domain}}"}},
+		{"invalid template function arguments", Config{URLTemplate: "{{trunc \"a\" .Service}}.{{.Domain}}"}},
+		{"invalid host", Config{URLTemplate: "{{.Service}}_{{.Namespace}}.{{.Domain}}"}},
+		{"unknown path mode", Config{PathMode: "subdomain"}},
+		{"wildcard without domain", Config{TLSUseWildcard: true}},
+		{"acme with http", Config{TLSAcme: true, HTTP: true}},
+		{"unknown ambassador mode", Config{AmbassadorMode: "mapping"}},
+		{"node ip and selector", Config{NodeIP: "10.0.0.1", NodeSelector: "pool=edge"}},
+		{"invalid node selector", Config{NodeSelector: "pool in edge"}},
+		{"invalid node port range", Config{NodePortRange: "30099-30000"}},
+		{"unknown load balancer profile", Config{LoadBalancerProfile: "oracle"}},
+		{"invalid load balancer IP", Config{LoadBalancerIPs: []string{"10.0.0"}}},
+		{"externalip without pool", Config{Exposer: "externalip"}},
+		{"webhook without url", Config{Exposer: "webhook"}},
+		{"invalid webhook url", Config{WebhookURL: "ftp://example.com"}},
+	}
+	for _, example := range examples {
+		assert.Error(t, Validate(&example.config), example.name)
+	}
+
+	err := Validate(&Config{Exposer: "router", TLSUseWildcard: true})
+	require.Error(t, err)
+	if assert.Implements(t, (*utilerrors.Aggregate)(nil), err) {
+		assert.Len(t, err.(utilerrors.Aggregate).Errors(), 2, "all errors reported")
+	}
+}
@@ -0,0 +1,338 @@
+package exposestrategy
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"k8s.io/klog"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// WebhookSignatureHeader is the header holding the HMAC-SHA256 signature of the webhook requests
+	WebhookSignatureHeader = "X-Exposecontroller-Signature"
+
+	// WebhookActionSync is sent before starting / resyncing
+	WebhookActionSync = "sync"
+	// WebhookActionAdd is sent when an exposed service is created or updated
+	WebhookActionAdd = "add"
+	// WebhookActionClean is sent when an exposed service is unexposed
+	WebhookActionClean = "clean"
+	// WebhookActionDelete is sent when an exposed service is deleted
+	WebhookActionDelete = "delete"
+
+	defaultWebhookTimeout = 10 * time.Second
+)
+
+// WebhookRequest is the JSON envelope posted to the webhook
+type WebhookRequest struct {
+	Action    string      `json:"action"`
+	Namespace string      `json:"namespace,omitempty"`
+	Service   *v1.Service `json:"service,omitempty"`
+	Host      string      `json:"host,omitempty"`
+	Path      string      `json:"path,omitempty"`
+	Port      int32       `json:"port,omitempty"`
+}
+
+// WebhookResponse is the JSON response expected from the webhook
+type WebhookResponse struct {
+	URLs   []string `json:"urls"`
+	Status string   `json:"status"`
+}
+
+// WebhookStrategy is a strategy that delegates the exposure of the services to an HTTP endpoint
+type WebhookStrategy struct {
+	client    kubernetes.Interface
+	namespace string
+
+	url        string
+	secret     []byte
+	retries    int
+	retryDelay time.Duration
+	http       *http.Client
+
+	domain       string
+	urltemplate  *urlTemplate
+	clusterName  string
+	pathMode     string
+	pathTemplate *urlTemplate
+	allowedHosts []string
+	namespaces   *NamespaceOverrides
+}
+
+// NewWebhookStrategy creates a new WebhookStrategy
+func NewWebhookStrategy(client kubernetes.Interface, config *Config) (ExposeStrategy, error) {
+	if config.WebhookURL == "" {
+		return nil, errors.New("webhook strategy requires a webhook url")
+	}
+	err := checkWebhookConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	timeout := config.WebhookTimeout
+	if timeout <= 0 {
+		timeout = defaultWebhookTimeout
+	}
+
+	urltemplate, err := parseURLTemplate(config.URLTemplate)
+	if err != nil {
+		return nil, err
+	}
+	pathtemplate, err := parsePathTemplate(config.PathTemplate)
+	if err != nil {
+		return nil, err
+	}
+	klog.Infof("Using webhook %s", config.WebhookURL)
+
+	return &WebhookStrategy{
+		client:       client,
+		namespace:    config.Namespace,
+		url:          config.WebhookURL,
+		secret:       []byte(config.WebhookSecret),
+		retries:      config.WebhookRetries,
+		retryDelay:   time.Second,
+		http:         &http.Client{Timeout: timeout},
+		domain:       config.Domain,
+		urltemplate:  urltemplate,
+		clusterName:  config.ClusterName,
+		pathMode:     config.PathMode,
+		pathTemplate: pathtemplate,
+		allowedHosts: config.AllowedHosts,
+		namespaces:   config.Namespaces,
+	}, nil
+}
+
+// forService returns the strategy with the settings overridden for the service
+func (s *WebhookStrategy) forService(svc *v1.Service) *WebhookStrategy {
+	settings, ok := s.namespaces.Apply(svc, NamespaceSettings{
+		Domain:       s.domain,
+		URLTemplate:  s.urltemplate.source(),
+		PathMode:     s.pathMode,
+		AllowedHosts: s.allowedHosts,
+	})
+	if !ok {
+		return s
+	}
+	clone := *s
+	clone.domain = settings.Domain
+	clone.urltemplate = s.urltemplate.reparse(settings.URLTemplate)
+	clone.pathMode = settings.PathMode
+	clone.allowedHosts = settings.AllowedHosts
+	return &clone
+}
+
+// checkWebhookConfig checks the webhook url, if any, and the retries
+func checkWebhookConfig(config *Config) error {
+	if config.WebhookURL != "" {
+		u, err := url.Parse(config.WebhookURL)
+		if err != nil {
+			return errors.Wrapf(err, "failed to parse webhook url \"%s\"", config.WebhookURL)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return errors.Errorf("webhook url \"%s\" must be http or https", config.WebhookURL)
+		}
+	}
+	if config.WebhookRetries < 0 {
+		return errors.Errorf("webhook retries must be positive, got %d", config.WebhookRetries)
+	}
+	return nil
+}
+
+// Sync is called before starting / resyncing
+// Notifies the webhook
+func (s *WebhookStrategy) Sync() error {
+	_, err := s.post(&WebhookRequest{
+		Action:    WebhookActionSync,
+		Namespace: s.namespace,
+	})
+	return err
+}
+
+// HasSynced tells if the strategy is complete
+// Nothing to do
+func (s *WebhookStrategy) HasSynced() bool {
+	return true
+}
+
+// Add is called when an exposed service is created or updated
+// Posts the service to the webhook and writes the returned urls and status in the annotations
+func (s *WebhookStrategy) Add(svc *v1.Service) error {
+	port, err := getExposePort(svc)
+	if err != nil {
+		return err
+	}
+	s = s.forService(svc)
+	hostName, path, err := s.getHostAndPath(svc)
+	if err != nil {
+		return err
+	}
+	if err := checkAllowedHost(hostName, s.allowedHosts); err != nil {
+		return errors.Wrapf(err, "failed to expose service %s/%s", svc.Namespace, svc.Name)
+	}
+	response, err := s.post(&WebhookRequest{
+		Action:    WebhookActionAdd,
+		Namespace: svc.Namespace,
+		Service:   svc,
+		Host:      hostName,
+		Path:      path,
+		Port:      port.Port,
+	})
+	if err != nil {
+		return err
+	}
+
+	clone := svc.DeepCopy()
+	if len(response.URLs) == 0 {
+		setServiceExposeURL(clone, "", "")
+		delete(clone.Annotations, ExposeURLsAnnotationKey)
+	} else {
+		u, err := url.Parse(response.URLs[0])
+		if err != nil {
+			return errors.Wrapf(err, "failed to parse the url \"%s\" returned by the webhook for service %s/%s",
+				response.URLs[0], svc.Namespace, svc.Name)
+		}
+		setServiceExposeURL(clone, response.URLs[0], u.Host)
+		clone.Annotations[ExposeURLsAnnotationKey] = strings.Join(response.URLs, ",")
+	}
+	if response.Status != "" {
+		clone.Annotations[ExposeStatusAnnotationKey] = response.Status
+	} else {
+		delete(clone.Annotations, ExposeStatusAnnotationKey)
+	}
+
+	patch, err := createServicePatch(svc, clone)
+	if err != nil {
+		return errors.Wrap(err, "failed to create patch")
+	}
+	if patch != nil {
+		_, err = s.client.CoreV1().Services(svc.Namespace).
+			Patch(svc.Name, patchType, patch)
+		if err != nil {
+			return errors.Wrap(err, "failed to send patch")
+		}
+	}
+	return nil
+}
+
+// Clean is called when an exposed service is unexposed
+// Notifies the webhook and cleans various annotations
+func (s *WebhookStrategy) Clean(svc *v1.Service) error {
+	_, err := s.post(&WebhookRequest{
+		Action:    WebhookActionClean,
+		Namespace: svc.Namespace,
+		Service:   svc,
+	})
+	if err != nil {
+		return err
+	}
+
+	clone := svc.DeepCopy()
+	removeServiceAnnotation(clone)
+	delete(clone.Annotations, ExposeURLsAnnotationKey)
+	delete(clone.Annotations, ExposeStatusAnnotationKey)
+
+	patch, err := createServicePatch(svc, clone)
+	if err != nil {
+		return errors.Wrap(err, "failed to create patch")
+	}
+	if patch != nil {
+		_, err = s.client.CoreV1().Services(clone.Namespace).
+			Patch(clone.Name, patchType, patch)
+		if err != nil {
+			return errors.Wrap(err, "failed to send patch")
+		}
+	}
+	return nil
+}
+
+// Delete is called when an exposed service is deleted
+// Notifies the webhook
+func (s *WebhookStrategy) Delete(svc *v1.Service) error {
+	_, err := s.post(&WebhookRequest{
+		Action:    WebhookActionDelete,
+		Namespace: svc.Namespace,
+		Service:   svc,
+	})
+	return err
+}
+
+// getHostAndPath computes the host and path of the service, the same way the ingress strategy does
+func (s *WebhookStrategy) getHostAndPath(svc *v1.Service) (string, string, error) {
+	hostName, path, err := getHostAndPath(svc, getAppName(svc), s.urltemplate, s.pathTemplate, s.domain, s.clusterName, s.pathMode)
+	if path == "" {
+		path = "/"
+	}
+	return hostName, path, err
+}
+
+// post sends the request to the webhook, retrying on connection errors and server errors
+func (s *WebhookStrategy) post(request *WebhookRequest) (*WebhookResponse, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal webhook request")
+	}
+	for attempt := 0; ; attempt++ {
+		response, retry, err := s.send(body)
+		if err == nil {
+			return response, nil
+		}
+		if !retry || attempt >= s.retries {
+			return nil, errors.Wrapf(err, "webhook %s failed for action %s", s.url, request.Action)
+		}
+		klog.Warningf("webhook %s failed for action %s, retrying: %v", s.url, request.Action, err)
+		time.Sleep(s.retryDelay * time.Duration(attempt+1))
+	}
+}
+
+// send sends the body to the webhook once
+// returns whether the request can be retried on error
+func (s *WebhookStrategy) send(body []byte) (*WebhookResponse, bool, error) {
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, false, errors.Wrap(err, "failed to create request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(s.secret) > 0 {
+		req.Header.Set(WebhookSignatureHeader, "sha256="+WebhookSignature(s.secret, body))
+	}
+
+	resp, err := s.http.Do(req)
+	if err != nil {
+		return nil, true, err
+	}
+	defer resp.Body.Close()
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, true, errors.Wrap(err, "failed to read response")
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, resp.StatusCode >= 500, errors.Errorf("unexpected status %s: %s", resp.Status, string(content))
+	}
+
+	response := &WebhookResponse{}
+	if len(bytes.TrimSpace(content)) > 0 {
+		err = json.Unmarshal(content, response)
+		if err != nil {
+			return nil, false, errors.Wrap(err, "failed to parse response")
+		}
+	}
+	return response, false, nil
+}
+
+// WebhookSignature returns the hex encoded HMAC-SHA256 signature of the body
+func WebhookSignature(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
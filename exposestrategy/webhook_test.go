@@ -0,0 +1,129 @@
+package exposestrategy
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhookStrategy_New(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	_, err := NewWebhookStrategy(client, &Config{})
+	assert.Error(t, err, "no url")
+	_, err = NewWebhookStrategy(client, &Config{WebhookURL: "ftp://example.com"})
+	assert.Error(t, err, "invalid scheme")
+	_, err = NewWebhookStrategy(client, &Config{WebhookURL: "http://example.com", WebhookRetries: -1})
+	assert.Error(t, err, "negative retries")
+	_, err = NewWebhookStrategy(client, &Config{WebhookURL: "https://example.com/expose"})
+	assert.NoError(t, err, "valid")
+}
+
+func TestWebhookStrategy(t *testing.T) {
+	requests := []WebhookRequest{}
+	failures := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "sha256="+WebhookSignature([]byte("my-secret"), body), r.Header.Get(WebhookSignatureHeader))
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		request := WebhookRequest{}
+		require.NoError(t, json.Unmarshal(body, &request))
+		requests = append(requests, request)
+		if request.Action == WebhookActionAdd {
+			json.NewEncoder(w).Encode(&WebhookResponse{
+				URLs:   []string{"https://" + request.Host + request.Path, "https://backup.example.com" + request.Path},
+				Status: "Ready",
+			})
+		}
+	}))
+	defer server.Close()
+
+	svc := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "main",
+			Name:      "my-app",
+			Annotations: map[string]string{
+				"fabric8.io/ingress.path": "/api",
+			},
+		},
+		Spec: v1.ServiceSpec{
+			Ports: []v1.ServicePort{{Port: 8080}},
+		},
+	}
+	client := fake.NewSimpleClientset(svc.DeepCopy())
+	strategy, err := NewWebhookStrategy(client, &Config{
+		Namespace:      "main",
+		Domain:         "example.com",
+		WebhookURL:     server.URL,
+		WebhookSecret:  "my-secret",
+		WebhookRetries: 2,
+	})
+	require.NoError(t, err)
+	strategy.(*WebhookStrategy).retryDelay = 0
+
+	// the first attempts fail
+	failures = 2
+	require.NoError(t, strategy.Sync())
+	if assert.Len(t, requests, 1) {
+		assert.Equal(t, WebhookActionSync, requests[0].Action)
+		assert.Equal(t, "main", requests[0].Namespace)
+	}
+
+	requests = requests[:0]
+	err = strategy.Add(svc.DeepCopy())
+	require.NoError(t, err)
+	if assert.Len(t, requests, 1) {
+		assert.Equal(t, WebhookActionAdd, requests[0].Action)
+		assert.Equal(t, "my-app.main.example.com", requests[0].Host)
+		assert.Equal(t, "/api", requests[0].Path)
+		assert.Equal(t, int32(8080), requests[0].Port)
+		if assert.NotNil(t, requests[0].Service) {
+			assert.Equal(t, "my-app", requests[0].Service.Name)
+		}
+	}
+	updated, err := client.CoreV1().Services("main").Get("my-app", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"fabric8.io/ingress.path": "/api",
+		ExposeAnnotationKey:       "https://my-app.main.example.com/api",
+		ExposeURLsAnnotationKey:   "https://my-app.main.example.com/api,https://backup.example.com/api",
+		ExposeStatusAnnotationKey: "Ready",
+	}, updated.Annotations)
+
+	requests = requests[:0]
+	err = strategy.Clean(updated)
+	require.NoError(t, err)
+	if assert.Len(t, requests, 1) {
+		assert.Equal(t, WebhookActionClean, requests[0].Action)
+	}
+	updated, err = client.CoreV1().Services("main").Get("my-app", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"fabric8.io/ingress.path": "/api",
+	}, updated.Annotations)
+
+	requests = requests[:0]
+	err = strategy.Delete(updated)
+	require.NoError(t, err)
+	if assert.Len(t, requests, 1) {
+		assert.Equal(t, WebhookActionDelete, requests[0].Action)
+	}
+
+	// too many failures
+	failures = 3
+	err = strategy.Delete(updated)
+	assert.Error(t, err)
+}